@@ -0,0 +1,200 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An AccessLogEntry describes a single request for the access log.
+type AccessLogEntry struct {
+	// RemoteHost is the client's IP address, without the port.
+	RemoteHost string
+	Time       time.Time
+	URL        string
+	Status     int
+	Meta       string
+
+	// Bytes is the size of the response body, not including the header.
+	Bytes    int64
+	Duration time.Duration
+
+	// Fingerprint is the Fingerprint of the client certificate, or empty if
+	// there wasn't one.
+	Fingerprint string
+
+	// Extra holds additional fields added by AccessLog.Enrich, such as a
+	// country or ASN for the client.
+	Extra map[string]string
+}
+
+// SetExtra sets an extra field on e, creating the map if needed.
+func (e *AccessLogEntry) SetExtra(key, value string) {
+	if e.Extra == nil {
+		e.Extra = make(map[string]string)
+	}
+	e.Extra[key] = value
+}
+
+// String formats e in a format based on the Apache combined log format, so
+// existing log analysis tools can be adapted easily. The client certificate
+// fingerprint takes the place of the user, and the meta of the referer. Any
+// Extra fields are appended as key="value" pairs, sorted by key:
+//
+//	192.0.2.1 - AB:CD:... [15/Oct/2026:10:00:00 +0000] "gemini://example.com/" 20 1234 "text/gemini" country="NL"
+func (e AccessLogEntry) String() string {
+	var b strings.Builder
+
+	b.WriteString(dashIfEmpty(e.RemoteHost))
+	b.WriteString(" - ")
+	b.WriteString(dashIfEmpty(e.Fingerprint))
+	b.WriteString(" [")
+	b.WriteString(e.Time.Format("02/Jan/2006:15:04:05 -0700"))
+	b.WriteString("] ")
+	b.WriteString(strconv.Quote(e.URL))
+	b.WriteString(" ")
+	b.WriteString(strconv.Itoa(e.Status))
+	b.WriteString(" ")
+	b.WriteString(strconv.FormatInt(e.Bytes, 10))
+	b.WriteString(" ")
+	b.WriteString(strconv.Quote(e.Meta))
+
+	keys := make([]string, 0, len(e.Extra))
+	for key := range e.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		b.WriteString(" ")
+		b.WriteString(key)
+		b.WriteString("=")
+		b.WriteString(strconv.Quote(e.Extra[key]))
+	}
+
+	return b.String()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// AccessLog is a middleware which writes an AccessLogEntry for every request.
+//
+//	logger := &gemini.AccessLog{Output: logFile}
+//	mux.Use(logger.Middleware)
+type AccessLog struct {
+	// Output is where log lines are written. If nil, os.Stderr is used.
+	Output io.Writer
+
+	// Enrich, if set, is called with every entry before it is written, and
+	// may modify it or add Extra fields. This is the place to hook in
+	// things like GeoIP lookups without the package depending on them. It
+	// runs after the handler, but before the connection is closed, so slow
+	// lookups should be cached.
+	Enrich func(ctx context.Context, r *Request, entry *AccessLogEntry)
+
+	mu sync.Mutex
+}
+
+// Middleware wraps next with the access log. It can be passed to Router.Use.
+//
+// Requests which no handler writes a response to are logged as
+// gemini.StatusNotFound, as that is what the Server replies with.
+func (l *AccessLog) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		start := time.Now()
+
+		rec := &accessLogWriter{ResponseWriter: w}
+		next.ServeGemini(ctx, rec, r)
+
+		entry := AccessLogEntry{
+			Time:     start,
+			URL:      r.URL.String(),
+			Status:   rec.status,
+			Meta:     rec.meta,
+			Bytes:    rec.bytes,
+			Duration: time.Since(start),
+		}
+
+		if entry.Status == 0 {
+			entry.Status = StatusNotFound
+		}
+
+		if addr := CtxRemoteAddr(ctx); addr != nil {
+			entry.RemoteHost = addr.String()
+			if host, _, err := net.SplitHostPort(entry.RemoteHost); err == nil {
+				entry.RemoteHost = host
+			}
+		}
+
+		if r.Identity != nil {
+			entry.Fingerprint = Fingerprint(r.Identity)
+		}
+
+		if l.Enrich != nil {
+			l.Enrich(ctx, r, &entry)
+		}
+
+		l.write(entry)
+	})
+}
+
+func (l *AccessLog) write(entry AccessLogEntry) {
+	out := l.Output
+	if out == nil {
+		out = os.Stderr
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, _ = io.WriteString(out, entry.String()+"\n")
+}
+
+// accessLogWriter records what was written for the access log.
+type accessLogWriter struct {
+	ResponseWriter
+
+	status int
+	meta   string
+	bytes  int64
+}
+
+func (w *accessLogWriter) WriteStatus(statusCode int, meta string) {
+	if w.status == 0 {
+		w.status = statusCode
+		w.meta = meta
+	}
+	w.ResponseWriter.WriteStatus(statusCode, meta)
+}
+
+func (w *accessLogWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = StatusSuccess
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements Flusher.
+func (w *accessLogWriter) Flush() error {
+	return Flush(w.ResponseWriter)
+}
+
+// Hijack implements Hijacker.
+func (w *accessLogWriter) Hijack() (*tls.Conn, error) {
+	return Hijack(w.ResponseWriter)
+}
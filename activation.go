@@ -0,0 +1,62 @@
+package gemini
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// ListenersFromEnv returns the sockets passed to the process by systemd
+// socket activation, in the order they are listed in the socket unit. If the
+// process wasn't socket activated, it returns no listeners and no error.
+//
+// The environment variables are unset afterwards, so they aren't inherited
+// by child processes such as CGI scripts. Each listener can be passed to
+// Server.Serve:
+//
+//	listeners, err := gemini.ListenersFromEnv()
+//	...
+//	for _, l := range listeners {
+//		go server.Serve(l)
+//	}
+func ListenersFromEnv() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+
+		// FileListener duplicates the descriptor, so the original is closed
+		// either way.
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket activation fd %d: %w", fd, err)
+		}
+
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
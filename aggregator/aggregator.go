@@ -0,0 +1,276 @@
+// Package aggregator collects posts from many Gemini feeds into a single
+// timeline, in the style of Antenna.
+//
+// Both gemfeeds (gemtext pages with dated links) and Atom feeds are supported.
+//
+//	a := &aggregator.Aggregator{
+//		Feeds:    []string{"gemini://example.com/gemlog/"},
+//		Interval: time.Hour,
+//	}
+//	go a.Run(ctx)
+//
+//	mux.Handle("/", a.Handler("My Aggregator"))
+package aggregator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// maxFeedSize is the largest feed which will be parsed.
+const maxFeedSize = 4 << 20
+
+// Aggregator periodically fetches a list of feeds and merges their entries.
+type Aggregator struct {
+	// Feeds is the list of feed URLs. It must not be modified while Run or
+	// Refresh are in progress.
+	Feeds []string
+
+	// Client is used to fetch feeds. If nil, a Client with a memory TOFU store
+	// and a one minute timeout is used.
+	Client *gemini.Client
+
+	// Interval is how often feeds are refetched by Run. If zero, one hour is
+	// used.
+	Interval time.Duration
+
+	// HostDelay is the time to wait between requests to the same host. Feeds
+	// on different hosts are fetched concurrently. If zero, one second is
+	// used.
+	HostDelay time.Duration
+
+	// MaxAge drops entries published longer ago than this. If zero, entries
+	// are kept regardless of age.
+	MaxAge time.Duration
+
+	// MaxEntries limits the size of the timeline. If zero, there is no limit.
+	MaxEntries int
+
+	// OnError, if set, is called when a feed can't be fetched or parsed. If
+	// nil, errors are logged with the log package.
+	OnError func(feed string, err error)
+
+	mu       sync.RWMutex
+	timeline []Entry
+	byFeed   map[string][]Entry
+}
+
+// Run refreshes the feeds immediately and then every Interval until ctx is
+// done.
+func (a *Aggregator) Run(ctx context.Context) {
+	interval := a.Interval
+	if interval == 0 {
+		interval = time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		a.Refresh(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh fetches every feed once and rebuilds the timeline. Feeds which fail
+// keep the entries from their last successful fetch.
+func (a *Aggregator) Refresh(ctx context.Context) {
+	delay := a.HostDelay
+	if delay == 0 {
+		delay = time.Second
+	}
+
+	// Group the feeds by host so each host only sees one request at a time.
+	hosts := make(map[string][]string)
+	for _, feed := range a.Feeds {
+		u, err := url.Parse(feed)
+		if err != nil {
+			a.reportError(feed, err)
+			continue
+		}
+		hosts[u.Host] = append(hosts[u.Host], feed)
+	}
+
+	var wg sync.WaitGroup
+	for _, feeds := range hosts {
+		wg.Add(1)
+		go func(feeds []string) {
+			defer wg.Done()
+
+			for i, feed := range feeds {
+				if i > 0 {
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(delay):
+					}
+				}
+
+				entries, err := a.fetch(ctx, feed)
+				if err != nil {
+					a.reportError(feed, err)
+					continue
+				}
+
+				a.mu.Lock()
+				if a.byFeed == nil {
+					a.byFeed = make(map[string][]Entry)
+				}
+				a.byFeed[feed] = entries
+				a.mu.Unlock()
+			}
+		}(feeds)
+	}
+	wg.Wait()
+
+	a.rebuild()
+}
+
+// Timeline returns the merged entries, newest first. Entries which appear in
+// more than one feed are only included once.
+func (a *Aggregator) Timeline() []Entry {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return append([]Entry(nil), a.timeline...)
+}
+
+func (a *Aggregator) fetch(ctx context.Context, feed string) ([]Entry, error) {
+	client := a.Client
+	if client == nil {
+		client = &gemini.Client{TOFU: &gemini.MemoryTOFUStore{}, Timeout: time.Minute}
+	}
+
+	resp, err := client.GetContext(ctx, feed)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !resp.IsSuccess() {
+		return nil, fmt.Errorf("aggregator: %d %s", resp.Status, resp.Meta)
+	}
+
+	feedURL := resp.Request.URL
+	body := io.LimitReader(resp.Body, maxFeedSize)
+
+	mediaType, _, err := resp.MediaType()
+	if err != nil {
+		return nil, err
+	}
+
+	var title string
+	var entries []Entry
+	switch mediaType {
+	case "text/gemini":
+		title, entries, err = parseGemfeed(feedURL, body)
+	case "application/atom+xml", "application/xml", "text/xml":
+		title, entries, err = parseAtom(feedURL, body)
+	default:
+		err = fmt.Errorf("aggregator: unsupported feed type %q", mediaType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		entries[i].Feed = feed
+		entries[i].FeedTitle = title
+	}
+
+	return entries, nil
+}
+
+func (a *Aggregator) rebuild() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var cutoff time.Time
+	if a.MaxAge > 0 {
+		cutoff = time.Now().Add(-a.MaxAge)
+	}
+
+	// When the same URL is in multiple feeds, the earliest entry wins, as
+	// that is most likely the original post.
+	seen := make(map[string]int)
+	var timeline []Entry
+	for _, entries := range a.byFeed {
+		for _, e := range entries {
+			if e.Published.Before(cutoff) {
+				continue
+			}
+
+			if i, ok := seen[e.URL]; ok {
+				if e.Published.Before(timeline[i].Published) {
+					timeline[i] = e
+				}
+				continue
+			}
+
+			seen[e.URL] = len(timeline)
+			timeline = append(timeline, e)
+		}
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		if !timeline[i].Published.Equal(timeline[j].Published) {
+			return timeline[i].Published.After(timeline[j].Published)
+		}
+		return timeline[i].URL < timeline[j].URL
+	})
+
+	if a.MaxEntries > 0 && len(timeline) > a.MaxEntries {
+		timeline = timeline[:a.MaxEntries]
+	}
+
+	a.timeline = timeline
+}
+
+func (a *Aggregator) reportError(feed string, err error) {
+	if a.OnError != nil {
+		a.OnError(feed, err)
+		return
+	}
+
+	log.Printf("aggregator: %s: %v", feed, err)
+}
+
+// Handler returns a handler which renders the timeline as gemtext, grouped by
+// day.
+func (a *Aggregator) Handler(title string) gemini.Handler {
+	return gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		w.WriteStatus(gemini.StatusSuccess, "text/gemini; charset=utf-8")
+
+		if title != "" {
+			fmt.Fprintf(w, "# %s\n", title)
+		}
+
+		var day string
+		for _, e := range a.Timeline() {
+			if d := e.Published.UTC().Format("2006-01-02"); d != day {
+				day = d
+				fmt.Fprintf(w, "\n## %s\n\n", day)
+			}
+
+			label := e.Title
+			if e.FeedTitle != "" {
+				label = e.FeedTitle + " - " + label
+			}
+
+			fmt.Fprintln(w, gemini.Link{URL: e.URL, Label: label}.String())
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package aggregator
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// An Entry is a single post from a feed.
+type Entry struct {
+	// Feed is the URL of the feed the entry came from, and FeedTitle is its
+	// title, if it has one.
+	Feed      string
+	FeedTitle string
+
+	Title     string
+	URL       string
+	Published time.Time
+}
+
+// parseGemfeed parses a gemtext page as a feed, as described by the gemfeed
+// companion spec. Entries are link lines whose label starts with a date.
+func parseGemfeed(feedURL *url.URL, r io.Reader) (string, []Entry, error) {
+	var title string
+	var entries []Entry
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if title == "" && strings.HasPrefix(line, "# ") {
+			title = strings.TrimSpace(line[2:])
+			continue
+		}
+
+		link, ok := gemini.ParseLink(line)
+		if !ok || len(link.Label) < 10 {
+			continue
+		}
+
+		published, err := time.Parse("2006-01-02", link.Label[:10])
+		if err != nil {
+			continue
+		}
+
+		u, ok := resolve(feedURL, link.URL)
+		if !ok {
+			continue
+		}
+
+		entries = append(entries, Entry{
+			Title:     strings.TrimLeft(link.Label[10:], " \t-–—:"),
+			URL:       u,
+			Published: published,
+		})
+	}
+
+	return title, entries, scanner.Err()
+}
+
+type atomFeed struct {
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+func parseAtom(feedURL *url.URL, r io.Reader) (string, []Entry, error) {
+	var feed atomFeed
+	if err := xml.NewDecoder(r).Decode(&feed); err != nil {
+		return "", nil, err
+	}
+
+	var entries []Entry
+	for _, e := range feed.Entries {
+		var href string
+		for _, link := range e.Links {
+			if link.Rel == "" || link.Rel == "alternate" {
+				href = link.Href
+				break
+			}
+		}
+
+		u, ok := resolve(feedURL, href)
+		if href == "" || !ok {
+			continue
+		}
+
+		date := e.Published
+		if date == "" {
+			date = e.Updated
+		}
+
+		published, err := time.Parse(time.RFC3339, strings.TrimSpace(date))
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, Entry{
+			Title:     strings.TrimSpace(e.Title),
+			URL:       u,
+			Published: published,
+		})
+	}
+
+	return strings.TrimSpace(feed.Title), entries, nil
+}
+
+func resolve(base *url.URL, link string) (string, bool) {
+	ref, err := url.Parse(link)
+	if err != nil {
+		return "", false
+	}
+
+	return base.ResolveReference(ref).String(), true
+}
@@ -0,0 +1,299 @@
+// Package archive stores Gemini transactions so crawls can be preserved and
+// replayed later.
+//
+// Archives are a sequence of WARC 1.1 response records, each holding the
+// request URL, the time of the request, the raw response (header and body)
+// and the server's certificate. Generic WARC tools can read them, although
+// they won't know what to do with the Gemini responses inside.
+//
+//	w := archive.NewWriter(f)
+//	resp, err := client.Get("gemini://example.com/")
+//	...
+//	rec, err := archive.Capture(resp)
+//	...
+//	err = w.Write(rec)
+//
+// Servers can capture their own traffic with a Recorder, and cmd/replay
+// re-issues the captured requests to compare the responses.
+package archive
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// MaxBodySize is the largest response body Capture and Reader will accept.
+var MaxBodySize int64 = 16 << 20
+
+// ErrBodyTooLarge is returned when a response body is larger than MaxBodySize.
+var ErrBodyTooLarge = errors.New("archive: body too large")
+
+// ErrMalformed is returned by Reader.Next for records which can't be parsed.
+var ErrMalformed = errors.New("archive: malformed record")
+
+const (
+	warcVersion      = "WARC/1.1"
+	geminiRecordType = "application/gemini; msgtype=response"
+	certificateField = "Gemini-Certificate"
+	durationField    = "Gemini-Duration"
+)
+
+// A Record is a single archived Gemini transaction.
+type Record struct {
+	// URL is the URL which was requested.
+	URL string
+
+	// Time is when the request was made.
+	Time time.Time
+
+	Status int
+	Meta   string
+	Body   []byte
+
+	// Certificate is the certificate presented by the server, if known.
+	Certificate *x509.Certificate
+
+	// Duration is how long the server took to respond, if known.
+	Duration time.Duration
+}
+
+// Capture reads resp into a Record and closes its body. resp must have been
+// returned by a gemini.Client, so its Request is set.
+func Capture(resp *gemini.Response) (*Record, error) {
+	defer resp.Body.Close()
+
+	if resp.Request == nil {
+		return nil, errors.New("archive: response has no request")
+	}
+
+	rec := &Record{
+		URL:    resp.Request.URL.String(),
+		Time:   time.Now().UTC(),
+		Status: resp.Status,
+		Meta:   resp.Meta,
+	}
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		rec.Certificate = resp.TLS.PeerCertificates[0]
+	}
+
+	if resp.IsSuccess() {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxBodySize+1))
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(body)) > MaxBodySize {
+			return nil, ErrBodyTooLarge
+		}
+		rec.Body = body
+	}
+
+	return rec, nil
+}
+
+// Response returns the archived response. Its Body reads from the record.
+func (r *Record) Response() *gemini.Response {
+	return &gemini.Response{
+		Status: r.Status,
+		Meta:   r.Meta,
+		Body:   ioutil.NopCloser(strings.NewReader(string(r.Body))),
+	}
+}
+
+// A Writer appends records to an archive.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter returns a Writer which writes records to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes a single record.
+func (w *Writer) Write(rec *Record) error {
+	block := strconv.Itoa(rec.Status) + " " + rec.Meta + "\r\n" + string(rec.Body)
+
+	id, err := newRecordID()
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString(warcVersion + "\r\n")
+	b.WriteString("WARC-Type: response\r\n")
+	b.WriteString("WARC-Record-ID: " + id + "\r\n")
+	b.WriteString("WARC-Date: " + rec.Time.UTC().Format(time.RFC3339Nano) + "\r\n")
+	b.WriteString("WARC-Target-URI: " + rec.URL + "\r\n")
+	b.WriteString("Content-Type: " + geminiRecordType + "\r\n")
+	if rec.Certificate != nil {
+		b.WriteString(certificateField + ": " + base64.StdEncoding.EncodeToString(rec.Certificate.Raw) + "\r\n")
+	}
+	if rec.Duration > 0 {
+		b.WriteString(durationField + ": " + rec.Duration.String() + "\r\n")
+	}
+	b.WriteString("Content-Length: " + strconv.Itoa(len(block)) + "\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(block)
+	b.WriteString("\r\n\r\n")
+
+	_, err = io.WriteString(w.w, b.String())
+	return err
+}
+
+func newRecordID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+
+	// Version 4, variant 1.
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+// A Reader reads records from an archive.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a Reader which reads records from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next Gemini response record, skipping any other records
+// in the archive. It returns io.EOF at the end of the archive.
+func (r *Reader) Next() (*Record, error) {
+	for {
+		fields, block, err := r.readRecord()
+		if err != nil {
+			return nil, err
+		}
+
+		if fields["WARC-Type"] != "response" || fields["Content-Type"] != geminiRecordType {
+			continue
+		}
+
+		return parseRecord(fields, block)
+	}
+}
+
+func (r *Reader) readRecord() (map[string]string, []byte, error) {
+	line, err := r.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil, nil, io.EOF
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !strings.HasPrefix(line, "WARC/") {
+		return nil, nil, ErrMalformed
+	}
+
+	fields := make(map[string]string)
+	for {
+		line, err := r.r.ReadString('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		split := strings.SplitN(line, ":", 2)
+		if len(split) != 2 {
+			return nil, nil, ErrMalformed
+		}
+		fields[split[0]] = strings.TrimSpace(split[1])
+	}
+
+	length, err := strconv.ParseInt(fields["Content-Length"], 10, 64)
+	if err != nil || length < 0 {
+		return nil, nil, ErrMalformed
+	}
+	if length > MaxBodySize+gemini.MaxMetaLength+8 {
+		return nil, nil, ErrBodyTooLarge
+	}
+
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r.r, block); err != nil {
+		return nil, nil, err
+	}
+
+	var trailer [4]byte
+	if _, err := io.ReadFull(r.r, trailer[:]); err != nil || string(trailer[:]) != "\r\n\r\n" {
+		return nil, nil, ErrMalformed
+	}
+
+	return fields, block, nil
+}
+
+func parseRecord(fields map[string]string, block []byte) (*Record, error) {
+	t, err := time.Parse(time.RFC3339Nano, fields["WARC-Date"])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	header := string(block)
+	i := strings.Index(header, "\r\n")
+	if i < 0 {
+		return nil, ErrMalformed
+	}
+	header = header[:i]
+
+	split := strings.SplitN(header, " ", 2)
+	if len(split) != 2 {
+		return nil, ErrMalformed
+	}
+
+	status, err := strconv.Atoi(split[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	rec := &Record{
+		URL:    fields["WARC-Target-URI"],
+		Time:   t,
+		Status: status,
+		Meta:   split[1],
+		Body:   block[i+2:],
+	}
+
+	if raw := fields[durationField]; raw != "" {
+		rec.Duration, err = time.ParseDuration(raw)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+	}
+
+	if raw := fields[certificateField]; raw != "" {
+		der, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+
+		rec.Certificate, err = x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rec, nil
+}
@@ -0,0 +1,149 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// An Index holds archived records in memory, grouped by URL, so they can be
+// looked up and replayed. The zero value is ready to use.
+type Index struct {
+	mu      sync.RWMutex
+	records map[string][]*Record
+}
+
+// indexKey normalizes rawURL so equivalent URLs share snapshots.
+func indexKey(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	u.Fragment = ""
+	if u.Scheme == "gemini" || u.Scheme == "" {
+		if err := gemini.NormalizeURL(u); err != nil {
+			return rawURL
+		}
+	}
+
+	return u.String()
+}
+
+// Add adds rec to the index.
+func (x *Index) Add(rec *Record) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.records == nil {
+		x.records = make(map[string][]*Record)
+	}
+
+	key := indexKey(rec.URL)
+	records := append(x.records[key], rec)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	x.records[key] = records
+}
+
+// Load adds every record in an archive to the index.
+func (x *Index) Load(r io.Reader) error {
+	reader := NewReader(r)
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		x.Add(rec)
+	}
+}
+
+// URLs returns the archived URLs, sorted.
+func (x *Index) URLs() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	urls := make([]string, 0, len(x.records))
+	for u := range x.records {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	return urls
+}
+
+// Snapshots returns the times rawURL was archived, oldest first.
+func (x *Index) Snapshots(rawURL string) []time.Time {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	records := x.records[indexKey(rawURL)]
+	times := make([]time.Time, len(records))
+	for i, rec := range records {
+		times[i] = rec.Time
+	}
+
+	return times
+}
+
+// Lookup returns the snapshot of rawURL closest to at, or nil if it was never
+// archived. A zero at returns the latest snapshot.
+func (x *Index) Lookup(rawURL string, at time.Time) *Record {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	records := x.records[indexKey(rawURL)]
+	if len(records) == 0 {
+		return nil
+	}
+
+	if at.IsZero() {
+		return records[len(records)-1]
+	}
+
+	// Find the first snapshot after at, then pick whichever of it and the
+	// one before is closer.
+	i := sort.Search(len(records), func(i int) bool {
+		return records[i].Time.After(at)
+	})
+	if i == len(records) {
+		return records[i-1]
+	}
+	if i > 0 && at.Sub(records[i-1].Time) <= records[i].Time.Sub(at) {
+		return records[i-1]
+	}
+
+	return records[i]
+}
+
+// Replay returns a Handler which answers requests with the latest archived
+// response for the request URL, as the original server sent it. URLs which
+// were never archived get a 51.
+func Replay(x *Index) gemini.Handler {
+	return gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		rec := x.Lookup(r.URL.String(), time.Time{})
+		if rec == nil {
+			w.WriteStatus(gemini.StatusNotFound, "Not archived")
+			return
+		}
+
+		writeRecord(w, rec)
+	})
+}
+
+func writeRecord(w gemini.ResponseWriter, rec *Record) {
+	w.WriteStatus(rec.Status, rec.Meta)
+	if rec.Status >= gemini.StatusSuccess && rec.Status < gemini.StatusRedirect {
+		_, _ = w.Write(rec.Body)
+	}
+}
@@ -0,0 +1,125 @@
+package archive
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// Recorder is a middleware which captures every transaction handled by a
+// server into an archive, for debugging or for replaying against a new build
+// with cmd/replay. The responses are passed through unchanged.
+//
+//	rec := &archive.Recorder{Writer: archive.NewWriter(f)}
+//	server.Handler = rec.Middleware(mux)
+//
+// Responses with bodies larger than MaxBodySize aren't recorded.
+type Recorder struct {
+	Writer *Writer
+
+	// Skip, if set, is called for each request, and requests for which it
+	// returns true aren't recorded.
+	Skip func(r *gemini.Request) bool
+
+	// OnError, if set, is called with errors from writing records. They are
+	// otherwise ignored, so a full disk doesn't take the server down.
+	OnError func(err error)
+
+	mu sync.Mutex
+}
+
+// Middleware wraps next with the recorder. It can be passed to Router.Use.
+func (rc *Recorder) Middleware(next gemini.Handler) gemini.Handler {
+	return gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		if rc.Skip != nil && rc.Skip(r) {
+			next.ServeGemini(ctx, w, r)
+			return
+		}
+
+		defaultMeta := "text/gemini"
+		if srv := gemini.CtxServer(ctx); srv != nil && srv.DefaultMeta != "" {
+			defaultMeta = srv.DefaultMeta
+		}
+
+		start := time.Now()
+		tw := &teeWriter{ResponseWriter: w, defaultMeta: defaultMeta}
+		next.ServeGemini(ctx, tw, r)
+
+		if tw.tooLarge {
+			return
+		}
+
+		status, meta := tw.status, tw.meta
+		if status == 0 {
+			// Nothing was written, so the server will reply with a not
+			// found error.
+			status, meta = gemini.StatusNotFound, "not found"
+		}
+
+		rc.write(&Record{
+			URL:      r.URL.String(),
+			Time:     start.UTC(),
+			Status:   status,
+			Meta:     meta,
+			Body:     tw.body,
+			Duration: time.Since(start),
+		})
+	})
+}
+
+func (rc *Recorder) write(rec *Record) {
+	rc.mu.Lock()
+	err := rc.Writer.Write(rec)
+	rc.mu.Unlock()
+
+	if err != nil && rc.OnError != nil {
+		rc.OnError(err)
+	}
+}
+
+// teeWriter passes a response through while keeping a copy of it.
+type teeWriter struct {
+	gemini.ResponseWriter
+
+	defaultMeta string
+
+	status   int
+	meta     string
+	body     []byte
+	tooLarge bool
+}
+
+func (w *teeWriter) WriteStatus(statusCode int, meta string) {
+	if w.status == 0 {
+		w.status = statusCode
+		w.meta = meta
+	}
+	w.ResponseWriter.WriteStatus(statusCode, meta)
+}
+
+func (w *teeWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = gemini.StatusSuccess
+		w.meta = w.defaultMeta
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+
+	if !w.tooLarge {
+		if int64(len(w.body)+n) > MaxBodySize {
+			w.tooLarge = true
+			w.body = nil
+		} else {
+			w.body = append(w.body, data[:n]...)
+		}
+	}
+
+	return n, err
+}
+
+// Flush implements gemini.Flusher.
+func (w *teeWriter) Flush() error {
+	return gemini.Flush(w.ResponseWriter)
+}
@@ -0,0 +1,191 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// TimestampFormat is the format of the timestamps in Wayback paths.
+const TimestampFormat = "20060102150405"
+
+// timestampPadding fills in the missing parts of shortened timestamps, so
+// "2021" means the start of 2021.
+const timestampPadding = "00000101000000"
+
+// Wayback serves archived snapshots of other capsules, similar to the Wayback
+// Machine. Snapshots are addressed by time and URL, without the scheme:
+//
+//	/archive/20210102150405/example.com/gemlog/
+//
+// The timestamp may be shortened, such as to "2021", and the closest snapshot
+// is served, redirecting to its exact timestamp. Links in archived gemtext are
+// rewritten to point back into the archive, so readers stay within it. The
+// prefix itself lists every archived URL.
+//
+//	mux.Handle("/archive/*path", &archive.Wayback{Index: idx})
+type Wayback struct {
+	Index *Index
+
+	// Prefix is the path the Wayback is mounted at. If empty, "/archive/" is
+	// used.
+	Prefix string
+}
+
+func (wb *Wayback) prefix() string {
+	prefix := wb.Prefix
+	if prefix == "" {
+		prefix = "/archive/"
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// URL returns the path of the snapshot of rawURL at t. rawURL is assumed to
+// be a gemini URL.
+func (wb *Wayback) URL(t time.Time, rawURL string) string {
+	u, err := url.Parse(indexKey(rawURL))
+	if err != nil {
+		return rawURL
+	}
+
+	target := u.Host + u.EscapedPath()
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+
+	return wb.prefix() + t.UTC().Format(TimestampFormat) + "/" + target
+}
+
+// ServeGemini implements gemini.Handler.
+func (wb *Wayback) ServeGemini(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(wb.prefix(), "/"))
+	rest = strings.TrimPrefix(rest, "/")
+
+	if rest == "" {
+		wb.serveIndex(w)
+		return
+	}
+
+	split := strings.SplitN(rest, "/", 2)
+	at, err := parseTimestamp(split[0])
+	if err != nil || len(split) != 2 || split[1] == "" {
+		w.WriteStatus(gemini.StatusBadRequest, "Expected a timestamp followed by a URL")
+		return
+	}
+
+	// Clients and path cleaning may have mangled an included scheme, so
+	// it is optional.
+	target := split[1]
+	target = strings.TrimPrefix(target, "gemini:")
+	target = "gemini://" + strings.TrimLeft(target, "/")
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	rec := wb.Index.Lookup(target, at)
+	if rec == nil {
+		w.WriteStatus(gemini.StatusNotFound, "Not archived")
+		return
+	}
+
+	if split[0] != rec.Time.UTC().Format(TimestampFormat) {
+		w.WriteStatus(gemini.StatusRedirect, wb.URL(rec.Time, rec.URL))
+		return
+	}
+
+	base, err := url.Parse(rec.URL)
+	if err != nil {
+		w.WriteStatus(gemini.StatusCGIError, "Invalid archived URL")
+		return
+	}
+
+	switch {
+	case rec.Status >= gemini.StatusRedirect && rec.Status < gemini.StatusTemporaryFailure:
+		w.WriteStatus(rec.Status, wb.rewrite(rec.Time, base, rec.Meta))
+
+	case rec.Response().IsGemtext():
+		w.WriteStatus(rec.Status, rec.Meta)
+		_, _ = w.Write([]byte(wb.rewriteGemtext(rec.Time, base, string(rec.Body))))
+
+	default:
+		writeRecord(w, rec)
+	}
+}
+
+func (wb *Wayback) serveIndex(w gemini.ResponseWriter) {
+	w.WriteStatus(gemini.StatusSuccess, "text/gemini")
+
+	fmt.Fprintf(w, "# Archive\n\n")
+	for _, u := range wb.Index.URLs() {
+		snapshots := wb.Index.Snapshots(u)
+		if len(snapshots) == 0 {
+			continue
+		}
+
+		latest := snapshots[len(snapshots)-1]
+		fmt.Fprintf(w, "=> %s %s (%d snapshots, latest %s)\n",
+			wb.URL(latest, u), u, len(snapshots), latest.UTC().Format("2006-01-02"))
+	}
+}
+
+// rewrite returns the archive path for the link ref on the page at base.
+// Links to other protocols are returned unchanged.
+func (wb *Wayback) rewrite(t time.Time, base *url.URL, ref string) string {
+	u, err := base.Parse(ref)
+	if err != nil || u.Scheme != "gemini" || u.Host == "" {
+		return ref
+	}
+
+	u.Fragment = ""
+	return wb.URL(t, u.String())
+}
+
+func (wb *Wayback) rewriteGemtext(t time.Time, base *url.URL, body string) string {
+	lines := strings.SplitAfter(body, "\n")
+
+	preformatted := false
+	for i, line := range lines {
+		if strings.HasPrefix(line, "```") {
+			preformatted = !preformatted
+			continue
+		}
+
+		if preformatted {
+			continue
+		}
+
+		link, ok := gemini.ParseLink(line)
+		if !ok {
+			continue
+		}
+
+		rewritten := "=> " + wb.rewrite(t, base, link.URL)
+		if link.Label != "" {
+			rewritten += " " + link.Label
+		}
+		if strings.HasSuffix(line, "\n") {
+			rewritten += "\n"
+		}
+
+		lines[i] = rewritten
+	}
+
+	return strings.Join(lines, "")
+}
+
+// parseTimestamp parses a timestamp in TimestampFormat, which may be
+// shortened.
+func parseTimestamp(s string) (time.Time, error) {
+	if len(s) < 4 || len(s) > len(TimestampFormat) {
+		return time.Time{}, fmt.Errorf("archive: invalid timestamp %q", s)
+	}
+
+	return time.Parse(TimestampFormat, s+timestampPadding[len(s):])
+}
@@ -0,0 +1,144 @@
+package gemini
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ZipFS returns a read-only FileSystem backed by the entries of a zip archive.
+// Directories which don't have their own entries in the archive are
+// synthesized from the paths of the files in them. Files are only decompressed
+// when they are opened.
+func ZipFS(r *zip.Reader) (FileSystem, error) {
+	fs := newMemFS()
+
+	for _, zf := range r.File {
+		zf := zf
+		info := zf.FileInfo()
+
+		var node *memNode
+		if info.IsDir() {
+			node = newMemDir(info.Name(), info.ModTime())
+		} else {
+			node = &memNode{
+				mode:    info.Mode(),
+				modTime: info.ModTime(),
+				size:    info.Size(),
+				open: func() ([]byte, error) {
+					rc, err := zf.Open()
+					if err != nil {
+						return nil, err
+					}
+					defer rc.Close()
+
+					return ioutil.ReadAll(rc)
+				},
+			}
+		}
+
+		if err := fs.add(zf.Name, node); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// TarGzFS returns a read-only FileSystem backed by a gzipped tar archive. As tar
+// archives don't support random access, the whole archive is read into memory.
+// Only directories and regular files are supported; other entries are skipped.
+func TarGzFS(r io.Reader) (FileSystem, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	fs := newMemFS()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var node *memNode
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			node = newMemDir(hdr.Name, hdr.ModTime)
+		case tar.TypeReg, tar.TypeRegA:
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+
+			node = &memNode{
+				mode:    os.FileMode(hdr.Mode).Perm(),
+				modTime: hdr.ModTime,
+				size:    int64(len(data)),
+				open:    func() ([]byte, error) { return data, nil },
+			}
+		default:
+			continue
+		}
+
+		if err := fs.add(hdr.Name, node); err != nil {
+			return nil, err
+		}
+	}
+
+	return fs, nil
+}
+
+// OpenArchive opens a .zip, .tar.gz or .tgz file as a FileSystem. The returned
+// io.Closer must be closed once the FileSystem is no longer needed.
+func OpenArchive(name string) (FileSystem, io.Closer, error) {
+	lower := strings.ToLower(name)
+
+	if filepath.Ext(lower) == ".zip" {
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fs, err := ZipFS(&zr.Reader)
+		if err != nil {
+			zr.Close()
+			return nil, nil, err
+		}
+
+		return fs, zr, nil
+	}
+
+	if strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+
+		fs, err := TarGzFS(f)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return fs, nopCloser{}, nil
+	}
+
+	return nil, nil, &os.PathError{Op: "open", Path: name, Err: errors.New("unknown archive format")}
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
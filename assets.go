@@ -0,0 +1,165 @@
+package gemini
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// assetHashLength is the number of hex characters of the content hash used in
+// asset paths.
+const assetHashLength = 16
+
+// Assets serves files from a FileSystem under paths which include a hash of
+// their content, such as /assets/3f2a9c0e1b7d4a65/image.png. Because the path
+// changes whenever the content does, proxies and caches layered on top of
+// Gemini can cache assets forever.
+//
+// Pages should link to assets with URL, which is also usable from templates:
+//
+//	assets := gemini.NewAssets(gemini.Dir("/srv/assets"), "/assets/")
+//	mux.Handle("/assets/*path", assets)
+//	tmpl := template.New("page").Funcs(assets.FuncMap())
+//
+//	=> {{ asset "image.png" }} A picture
+type Assets struct {
+	root   FileSystem
+	prefix string
+
+	lock   sync.RWMutex
+	hashes map[string]assetHash
+}
+
+type assetHash struct {
+	hash    string
+	size    int64
+	modTime time.Time
+}
+
+// NewAssets returns Assets serving files from root, mounted at prefix.
+func NewAssets(root FileSystem, prefix string) *Assets {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &Assets{
+		root:   root,
+		prefix: prefix,
+		hashes: make(map[string]assetHash),
+	}
+}
+
+// URL returns the hashed path for the asset name, which is relative to the
+// root of the FileSystem. It returns an error if the file can't be read.
+func (a *Assets) URL(name string) (string, error) {
+	name = strings.TrimPrefix(cleanPath(name), "/")
+
+	hash, err := a.hash(name)
+	if err != nil {
+		return "", err
+	}
+
+	u := &url.URL{Path: a.prefix + hash + "/" + name}
+	return u.EscapedPath(), nil
+}
+
+// FuncMap returns template functions for linking to assets, for use with
+// text/template or html/template. It contains "asset", which is URL.
+func (a *Assets) FuncMap() map[string]interface{} {
+	return map[string]interface{}{
+		"asset": a.URL,
+	}
+}
+
+// hash returns the content hash of name, only rereading the file if its size
+// or modification time have changed.
+func (a *Assets) hash(name string) (string, error) {
+	f, err := a.root.Open("/" + name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if info.IsDir() {
+		return "", errors.New("asset is a directory")
+	}
+
+	a.lock.RLock()
+	cached, ok := a.hashes[name]
+	a.lock.RUnlock()
+
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.hash, nil
+	}
+
+	h := sha256.New()
+	if _, err := copyBuffer(h, f); err != nil {
+		return "", err
+	}
+
+	cached = assetHash{
+		hash:    hex.EncodeToString(h.Sum(nil))[:assetHashLength],
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}
+
+	a.lock.Lock()
+	a.hashes[name] = cached
+	a.lock.Unlock()
+
+	return cached.hash, nil
+}
+
+// ServeGemini implements Handler. Requests with an outdated hash are
+// redirected to the current version of the asset, so they are never served
+// content which doesn't match the hash.
+func (a *Assets) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
+	p := strings.TrimPrefix(cleanPath(r.URL.Path), a.prefix)
+
+	split := strings.SplitN(p, "/", 2)
+	if len(split) != 2 || len(split[0]) != assetHashLength || split[1] == "" {
+		NotFound(ctx, r, w)
+		return
+	}
+
+	name := split[1]
+
+	hash, err := a.hash(name)
+	if err != nil {
+		NotFound(ctx, r, w)
+		return
+	}
+
+	if hash != split[0] {
+		target, _ := a.URL(name)
+		w.WriteStatus(StatusRedirect, target)
+		return
+	}
+
+	f, err := a.root.Open("/" + name)
+	if err != nil {
+		NotFound(ctx, r, w)
+		return
+	}
+	defer f.Close()
+
+	mimeType := mime.TypeByExtension(path.Ext(name))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	w.WriteStatus(StatusSuccess, mimeType)
+	_, _ = copyBuffer(w, f)
+}
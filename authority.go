@@ -0,0 +1,100 @@
+package gemini
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// CertificateHosts returns the DNS names and IP addresses certs are valid
+// for, including wildcard names. It is meant for Server.AllowedHosts, so a
+// server on the default port only answers for the hosts it has certificates
+// for:
+//
+//	hosts, err := gemini.CertificateHosts(cert)
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	server.AllowedHosts = hosts
+//
+// Certificates without any subject alternative names contribute their common
+// name instead.
+func CertificateHosts(certs ...tls.Certificate) ([]string, error) {
+	var hosts []string
+	for _, cert := range certs {
+		leaf := cert.Leaf
+		if leaf == nil {
+			if len(cert.Certificate) == 0 {
+				continue
+			}
+
+			var err error
+			leaf, err = x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		hosts = append(hosts, leaf.DNSNames...)
+		for _, ip := range leaf.IPAddresses {
+			hosts = append(hosts, ip.String())
+		}
+
+		if len(leaf.DNSNames) == 0 && len(leaf.IPAddresses) == 0 && leaf.Subject.CommonName != "" {
+			hosts = append(hosts, leaf.Subject.CommonName)
+		}
+	}
+
+	return hosts, nil
+}
+
+// hostAllowed reports whether the Server answers requests for u, given its
+// AllowedHosts.
+func (s *Server) hostAllowed(u *url.URL) bool {
+	if len(s.AllowedHosts) == 0 {
+		return true
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "1965"
+	}
+
+	for _, allowed := range s.AllowedHosts {
+		pattern, allowedPort, err := net.SplitHostPort(allowed)
+		if err != nil {
+			pattern, allowedPort = allowed, "1965"
+		}
+
+		if port == allowedPort && matchHost(pattern, u.Hostname()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchHost reports whether host matches pattern, which may be a wildcard
+// like "*.example.com" matching a single label.
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.Equal(net.ParseIP(pattern))
+	}
+
+	if pattern == host {
+		return true
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		if i := strings.IndexByte(host, '.'); i > 0 {
+			return host[i:] == pattern[1:]
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,321 @@
+package autocert
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// This file contains a minimal ACME (RFC 8555) client, only implementing what
+// is needed to obtain certificates with DNS-01 challenges.
+
+type directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+	Meta       struct {
+		TermsOfService string `json:"termsOfService"`
+	} `json:"meta"`
+}
+
+type order struct {
+	Status         string   `json:"status"`
+	Authorizations []string `json:"authorizations"`
+	Finalize       string   `json:"finalize"`
+	Certificate    string   `json:"certificate"`
+}
+
+type authorization struct {
+	Status     string `json:"status"`
+	Identifier struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"identifier"`
+	Wildcard   bool        `json:"wildcard"`
+	Challenges []challenge `json:"challenges"`
+}
+
+type challenge struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	Status string `json:"status"`
+}
+
+// ProblemError is returned when the ACME server responds with an error
+// document, as described in RFC 7807.
+type ProblemError struct {
+	Type       string `json:"type"`
+	Detail     string `json:"detail"`
+	StatusCode int    `json:"-"`
+}
+
+func (e *ProblemError) Error() string {
+	return fmt.Sprintf("acme: %d %s: %s", e.StatusCode, e.Type, e.Detail)
+}
+
+// client talks to a single ACME server using a single account key.
+type client struct {
+	http *http.Client
+	key  *ecdsa.PrivateKey
+	dir  directory
+
+	// kid is the account URL, which is used to identify the account once it
+	// has been registered.
+	kid   string
+	nonce string
+}
+
+func newClient(ctx context.Context, hc *http.Client, dirURL string, key *ecdsa.PrivateKey) (*client, error) {
+	c := &client{http: hc, key: key}
+
+	resp, err := c.get(ctx, dirURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&c.dir); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *client) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.http.Do(req.WithContext(ctx))
+}
+
+// register creates the account, or looks up the existing account for the key.
+// The caller must have accepted the CA's terms of service.
+func (c *client) register(ctx context.Context, email string) error {
+	payload := map[string]interface{}{
+		"termsOfServiceAgreed": true,
+	}
+	if email != "" {
+		payload["contact"] = []string{"mailto:" + email}
+	}
+
+	resp, err := c.post(ctx, c.dir.NewAccount, payload, nil)
+	if err != nil {
+		return err
+	}
+
+	c.kid = resp.Header.Get("Location")
+	if c.kid == "" {
+		return errors.New("acme: account response is missing a location")
+	}
+
+	return nil
+}
+
+func (c *client) newOrder(ctx context.Context, hosts []string) (*order, string, error) {
+	var identifiers []map[string]string
+	for _, host := range hosts {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": host})
+	}
+
+	var o order
+	resp, err := c.post(ctx, c.dir.NewOrder, map[string]interface{}{"identifiers": identifiers}, &o)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &o, resp.Header.Get("Location"), nil
+}
+
+// poll fetches url with POST-as-GET into v until done returns true, waiting
+// between attempts.
+func (c *client) poll(ctx context.Context, url string, v interface{}, done func() (bool, error)) error {
+	for {
+		if _, err := c.post(ctx, url, nil, v); err != nil {
+			return err
+		}
+
+		ok, err := done()
+		if err != nil || ok {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// keyAuthorization returns the DNS-01 TXT record value for a challenge token.
+func (c *client) keyAuthorization(token string) string {
+	jwk := c.jwk()
+	thumb := sha256.Sum256([]byte(fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s","y":"%s"}`, jwk["crv"], jwk["kty"], jwk["x"], jwk["y"])))
+	keyAuth := token + "." + b64(thumb[:])
+
+	digest := sha256.Sum256([]byte(keyAuth))
+	return b64(digest[:])
+}
+
+// post sends a JWS signed request. A nil payload sends a POST-as-GET request.
+// If v is a *[]byte, the raw response body is stored in it, otherwise if v is
+// not nil the body is decoded into it as JSON.
+func (c *client) post(ctx context.Context, url string, payload interface{}, v interface{}) (*http.Response, error) {
+	// A bad nonce is the only error which is expected to succeed on retry.
+	resp, body, err := c.postOnce(ctx, url, payload)
+	if perr, ok := err.(*ProblemError); ok && perr.Type == "urn:ietf:params:acme:error:badNonce" {
+		resp, body, err = c.postOnce(ctx, url, payload)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if v != nil {
+		if raw, ok := v.(*[]byte); ok {
+			*raw = body
+		} else if err := json.Unmarshal(body, v); err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (c *client) postOnce(ctx context.Context, url string, payload interface{}) (*http.Response, []byte, error) {
+	if c.nonce == "" {
+		if err := c.fetchNonce(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	body, err := c.sign(url, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.nonce = ""
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	c.nonce = resp.Header.Get("Replay-Nonce")
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		perr := &ProblemError{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(data, perr)
+		return nil, nil, perr
+	}
+
+	return resp, data, nil
+}
+
+func (c *client) fetchNonce(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodHead, c.dir.NewNonce, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	if c.nonce == "" {
+		return errors.New("acme: server did not return a nonce")
+	}
+
+	return nil
+}
+
+// sign builds a flattened JWS using ES256, identifying the account by kid if it
+// has been registered or by the public key otherwise.
+func (c *client) sign(url string, payload interface{}) ([]byte, error) {
+	protected := map[string]interface{}{
+		"alg":   "ES256",
+		"nonce": c.nonce,
+		"url":   url,
+	}
+	if c.kid != "" {
+		protected["kid"] = c.kid
+	} else {
+		protected["jwk"] = c.jwk()
+	}
+
+	protectedJSON, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+
+	var payloadB64 string
+	if payload != nil {
+		payloadJSON, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		payloadB64 = b64(payloadJSON)
+	}
+
+	signingInput := b64(protectedJSON) + "." + payloadB64
+	digest := sha256.Sum256([]byte(signingInput))
+
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// ES256 signatures are the fixed size concatenation of r and s.
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return json.Marshal(map[string]string{
+		"protected": b64(protectedJSON),
+		"payload":   payloadB64,
+		"signature": b64(sig),
+	})
+}
+
+func (c *client) jwk() map[string]string {
+	return map[string]string{
+		"crv": "P-256",
+		"kty": "EC",
+		"x":   b64(padded(c.key.X, 32)),
+		"y":   b64(padded(c.key.Y, 32)),
+	}
+}
+
+func padded(n *big.Int, size int) []byte {
+	return n.FillBytes(make([]byte, size))
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
@@ -0,0 +1,551 @@
+// Package autocert obtains and renews certificates for Gemini servers from an
+// ACME certificate authority, such as Let's Encrypt.
+//
+// Because Gemini servers generally don't run anything on ports 80 or 443, only
+// the DNS-01 challenge type is supported. Publishing the challenge records is
+// left to a DNSProvider, which usually talks to the API of a DNS host.
+//
+//	m := &autocert.Manager{
+//		Prompt: autocert.AcceptTOS,
+//		Hosts:  []string{"example.com"},
+//		Email:  "admin@example.com",
+//		DNS:    provider,
+//		Cache:  autocert.DirCache("/var/lib/gemini/certs"),
+//	}
+//	go m.Run(ctx)
+//
+//	server := gemini.Server{
+//		TLS:     &tls.Config{GetCertificate: m.GetCertificate},
+//		Handler: mux,
+//	}
+package autocert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LetsEncryptURL is the directory URL of Let's Encrypt's production CA.
+const LetsEncryptURL = "https://acme-v02.api.letsencrypt.org/directory"
+
+// ErrCacheMiss is returned by a Cache when there is no data for a key.
+var ErrCacheMiss = errors.New("autocert: cache miss")
+
+// AcceptTOS is a Manager.Prompt which always accepts the CA's terms of
+// service. Using it means agreeing to the terms of the CA in
+// Manager.DirectoryURL.
+func AcceptTOS(tosURL string) bool { return true }
+
+// A DNSProvider publishes the TXT records used for DNS-01 challenges.
+type DNSProvider interface {
+	// Present creates a TXT record for name with the given value. name is a
+	// fully qualified domain name, like "_acme-challenge.example.com". It
+	// should only return once the record is visible to the CA, or the
+	// Manager's PropagationDelay should be set.
+	Present(ctx context.Context, name, value string) error
+
+	// CleanUp removes the record created by Present.
+	CleanUp(ctx context.Context, name, value string) error
+}
+
+// A Cache stores account keys and certificates between runs.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// DirCache implements Cache using a directory on the local filesystem.
+type DirCache string
+
+// Get implements Cache.
+func (d DirCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(string(d), key))
+	if os.IsNotExist(err) {
+		return nil, ErrCacheMiss
+	}
+	return data, err
+}
+
+// Put implements Cache. Files are only readable by the current user, as they
+// contain private keys.
+func (d DirCache) Put(ctx context.Context, key string, data []byte) error {
+	if err := os.MkdirAll(string(d), 0700); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(string(d), key+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(string(d), key))
+}
+
+// Manager obtains and renews certificates for a set of hosts. Its
+// GetCertificate method can be used directly as tls.Config.GetCertificate.
+type Manager struct {
+	// Prompt is called with the URL of the CA's terms of service before an
+	// account is registered, and must return true to agree to them. If it
+	// is nil or returns false, no certificates are obtained. AcceptTOS
+	// accepts any terms.
+	Prompt func(tosURL string) bool
+
+	// Hosts is the list of hostnames certificates will be obtained for. Each
+	// host gets its own certificate.
+	Hosts []string
+
+	// DNS publishes the challenge records. It is required.
+	DNS DNSProvider
+
+	// Cache stores the account key and certificates. If nil, they are only
+	// kept in memory, which will quickly run into CA rate limits.
+	Cache Cache
+
+	// Email is an optional contact address for the ACME account.
+	Email string
+
+	// DirectoryURL is the ACME directory. If empty, LetsEncryptURL is used.
+	DirectoryURL string
+
+	// RenewBefore is how long before expiry certificates are renewed. If
+	// zero, 30 days is used.
+	RenewBefore time.Duration
+
+	// PropagationDelay is how long to wait after DNS.Present returns before
+	// asking the CA to validate the challenge.
+	PropagationDelay time.Duration
+
+	// HTTPClient is used to talk to the CA. If nil, http.DefaultClient is
+	// used.
+	HTTPClient *http.Client
+
+	lock  sync.Mutex
+	certs map[string]*tls.Certificate
+
+	// obtaining holds a channel for each host with a certificate request in
+	// flight, which is closed once it is done.
+	obtaining map[string]chan struct{}
+
+	// failures records the last failed request for each host, so they aren't
+	// retried on every handshake.
+	failures map[string]failure
+}
+
+// A failure is a failed attempt to obtain a certificate.
+type failure struct {
+	err   error
+	count int
+	retry time.Time
+}
+
+// Failed requests are retried after minRetryDelay, doubling with each
+// further failure up to maxRetryDelay, which keeps the Manager well within
+// CA rate limits.
+const (
+	minRetryDelay = time.Minute
+	maxRetryDelay = time.Hour
+)
+
+// GetCertificate returns the certificate for the host requested by the client,
+// obtaining one first if needed. Obtaining a certificate with DNS-01 can take a
+// while, so it is better to call Run ahead of time.
+//
+// Concurrent handshakes for a host share a single request to the CA. If it
+// fails, they all get its error, as do later handshakes until it is retried,
+// a minute later at first and backing off to once an hour.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if host == "" {
+		if len(m.Hosts) == 0 {
+			return nil, errors.New("autocert: no hosts configured")
+		}
+		host = m.Hosts[0]
+	}
+
+	if !m.allowed(host) {
+		return nil, fmt.Errorf("autocert: host %q not configured", host)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	return m.certificate(ctx, host, false)
+}
+
+// Run makes sure every host has a valid certificate, then checks for
+// certificates which need renewing twice a day until ctx is cancelled. Errors
+// are retried on the next check.
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(12 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		for _, host := range m.Hosts {
+			_, _ = m.certificate(ctx, host, true)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) allowed(host string) bool {
+	for _, h := range m.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// certificate returns a valid certificate for host. If renew is true, a
+// certificate which is close to expiry is replaced even though it could still
+// be used.
+func (m *Manager) certificate(ctx context.Context, host string, renew bool) (*tls.Certificate, error) {
+	checkedCache := false
+
+	for {
+		m.lock.Lock()
+		if m.certs == nil {
+			m.certs = make(map[string]*tls.Certificate)
+			m.obtaining = make(map[string]chan struct{})
+			m.failures = make(map[string]failure)
+		}
+
+		cert := m.certs[host]
+
+		// The cache may be slow, so it is read without holding the lock.
+		if cert == nil && !checkedCache {
+			m.lock.Unlock()
+
+			checkedCache = true
+			if cached := m.loadCached(ctx, host); cached != nil {
+				m.lock.Lock()
+				if m.certs[host] == nil {
+					m.certs[host] = cached
+				}
+				m.lock.Unlock()
+			}
+			continue
+		}
+
+		valid := cert != nil && time.Now().Before(cert.Leaf.NotAfter)
+		if valid && !(renew && m.needsRenewal(cert)) {
+			m.lock.Unlock()
+			return cert, nil
+		}
+
+		// If another goroutine is already obtaining a certificate, wait for it
+		// and try again. If it failed, its error is returned below.
+		if wait, ok := m.obtaining[host]; ok {
+			m.lock.Unlock()
+
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		if f, ok := m.failures[host]; ok && time.Now().Before(f.retry) {
+			m.lock.Unlock()
+
+			// If renewal failed, keep using the old certificate until it
+			// expires.
+			if valid {
+				return cert, nil
+			}
+			return nil, f.err
+		}
+
+		done := make(chan struct{})
+		m.obtaining[host] = done
+		m.lock.Unlock()
+
+		newCert, err := m.obtain(ctx, host)
+
+		m.lock.Lock()
+		if err == nil {
+			m.certs[host] = newCert
+			delete(m.failures, host)
+		} else {
+			m.recordFailure(host, err)
+		}
+		delete(m.obtaining, host)
+		close(done)
+		m.lock.Unlock()
+
+		if err != nil && valid {
+			return cert, nil
+		}
+
+		return newCert, err
+	}
+}
+
+// recordFailure records that obtaining a certificate for host failed with
+// err. m.lock must be held.
+func (m *Manager) recordFailure(host string, err error) {
+	f := m.failures[host]
+	f.err = err
+	f.count++
+
+	delay := maxRetryDelay
+	if f.count <= 6 {
+		delay = minRetryDelay << uint(f.count-1)
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+	f.retry = time.Now().Add(delay)
+
+	m.failures[host] = f
+}
+
+func (m *Manager) needsRenewal(cert *tls.Certificate) bool {
+	renewBefore := m.RenewBefore
+	if renewBefore == 0 {
+		renewBefore = 30 * 24 * time.Hour
+	}
+
+	return time.Now().Add(renewBefore).After(cert.Leaf.NotAfter)
+}
+
+func (m *Manager) loadCached(ctx context.Context, host string) *tls.Certificate {
+	if m.Cache == nil {
+		return nil
+	}
+
+	data, err := m.Cache.Get(ctx, host+".pem")
+	if err != nil {
+		return nil
+	}
+
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+
+	return &cert
+}
+
+// accountKey loads the account key from the cache, generating and storing a
+// new one if there isn't one yet.
+func (m *Manager) accountKey(ctx context.Context) (*ecdsa.PrivateKey, error) {
+	if m.Cache != nil {
+		data, err := m.Cache.Get(ctx, "acme_account.key")
+		if err == nil {
+			block, _ := pem.Decode(data)
+			if block == nil {
+				return nil, errors.New("autocert: invalid cached account key")
+			}
+			return x509.ParseECPrivateKey(block.Bytes)
+		}
+		if err != ErrCacheMiss {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Cache != nil {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err := m.Cache.Put(ctx, "acme_account.key", data); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// obtain goes through the whole ACME flow to get a new certificate for host.
+func (m *Manager) obtain(ctx context.Context, host string) (*tls.Certificate, error) {
+	if m.DNS == nil {
+		return nil, errors.New("autocert: no DNS provider configured")
+	}
+
+	accountKey, err := m.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hc := m.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	dirURL := m.DirectoryURL
+	if dirURL == "" {
+		dirURL = LetsEncryptURL
+	}
+
+	c, err := newClient(ctx, hc, dirURL, accountKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Prompt == nil || !m.Prompt(c.dir.Meta.TermsOfService) {
+		return nil, errors.New("autocert: terms of service not accepted")
+	}
+
+	if err := c.register(ctx, m.Email); err != nil {
+		return nil, err
+	}
+
+	o, orderURL, err := c.newOrder(ctx, []string{host})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, authzURL := range o.Authorizations {
+		if err := m.authorize(ctx, c, authzURL); err != nil {
+			return nil, err
+		}
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: host},
+		DNSNames: []string{host},
+	}, certKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := c.post(ctx, o.Finalize, map[string]string{"csr": b64(csr)}, o); err != nil {
+		return nil, err
+	}
+
+	err = c.poll(ctx, orderURL, o, func() (bool, error) {
+		switch o.Status {
+		case "valid":
+			return true, nil
+		case "invalid":
+			return false, fmt.Errorf("autocert: order for %s is invalid", host)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var chainPEM []byte
+	if _, err := c.post(ctx, o.Certificate, nil, &chainPEM); err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(certKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	cert, err := tls.X509KeyPair(chainPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Cache != nil {
+		data := append(append([]byte(nil), chainPEM...), keyPEM...)
+		if err := m.Cache.Put(ctx, host+".pem", data); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cert, nil
+}
+
+// authorize completes the DNS-01 challenge for a single authorization.
+func (m *Manager) authorize(ctx context.Context, c *client, authzURL string) error {
+	var authz authorization
+	if _, err := c.post(ctx, authzURL, nil, &authz); err != nil {
+		return err
+	}
+
+	if authz.Status == "valid" {
+		return nil
+	}
+
+	var chal *challenge
+	for i := range authz.Challenges {
+		if authz.Challenges[i].Type == "dns-01" {
+			chal = &authz.Challenges[i]
+			break
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("autocert: no dns-01 challenge offered for %s", authz.Identifier.Value)
+	}
+
+	name := "_acme-challenge." + authz.Identifier.Value + "."
+	value := c.keyAuthorization(chal.Token)
+
+	if err := m.DNS.Present(ctx, name, value); err != nil {
+		return err
+	}
+	defer func() { _ = m.DNS.CleanUp(ctx, name, value) }()
+
+	if m.PropagationDelay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.PropagationDelay):
+		}
+	}
+
+	// Tell the CA the challenge is ready to be validated.
+	if _, err := c.post(ctx, chal.URL, map[string]interface{}{}, nil); err != nil {
+		return err
+	}
+
+	return c.poll(ctx, authzURL, &authz, func() (bool, error) {
+		switch authz.Status {
+		case "valid":
+			return true, nil
+		case "invalid", "deactivated", "expired", "revoked":
+			return false, fmt.Errorf("autocert: authorization for %s is %s", authz.Identifier.Value, authz.Status)
+		}
+		return false, nil
+	})
+}
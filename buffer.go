@@ -0,0 +1,33 @@
+package gemini
+
+import (
+	"io"
+	"sync"
+)
+
+// CopyBufferSize is the size of the buffers used to copy bodies in
+// FileServer, RelayBody and Response.WriteTo. The buffers are pooled, so
+// servers sending many large files don't need a new buffer for each one.
+//
+// CopyBufferSize must not be changed while a Server or Client is in use.
+var CopyBufferSize = 32 << 10
+
+var copyBufferPool sync.Pool
+
+// copyBuffer is io.Copy using a buffer from the pool.
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	size := CopyBufferSize
+	if size <= 0 {
+		size = 32 << 10
+	}
+
+	bufp, ok := copyBufferPool.Get().(*[]byte)
+	if !ok || len(*bufp) != size {
+		// Buffers from before CopyBufferSize was changed are dropped.
+		buf := make([]byte, size)
+		bufp = &buf
+	}
+	defer copyBufferPool.Put(bufp)
+
+	return io.CopyBuffer(dst, src, *bufp)
+}
@@ -0,0 +1,447 @@
+package gemini
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A CachedResponse is a successful response stored by ResponseCache.
+type CachedResponse struct {
+	// Meta is the meta the handler sent. It is empty if the handler wrote
+	// the body without calling WriteStatus, in which case the default meta
+	// applies when it is replayed.
+	Meta string
+	Body []byte
+
+	Stored time.Time
+}
+
+// A CacheStore holds responses for ResponseCache. Implementations must be safe
+// for concurrent use.
+type CacheStore interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, resp *CachedResponse)
+}
+
+// MemoryCache is a CacheStore which keeps responses in memory, evicting the
+// least recently used ones when it is full. The zero value is ready to use.
+type MemoryCache struct {
+	// MaxBytes is the total size of the bodies which will be kept. If zero,
+	// 64MB is used.
+	MaxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key  string
+	resp *CachedResponse
+}
+
+// Get implements CacheStore.
+func (c *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*memoryCacheEntry).resp, true
+}
+
+// Set implements CacheStore.
+func (c *MemoryCache) Set(key string, resp *CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	c.entries[key] = c.lru.PushFront(&memoryCacheEntry{key: key, resp: resp})
+	c.size += entrySize(key, resp)
+
+	maxBytes := c.MaxBytes
+	if maxBytes == 0 {
+		maxBytes = 64 << 20
+	}
+
+	for c.size > maxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *MemoryCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*memoryCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entrySize(entry.key, entry.resp)
+}
+
+func entrySize(key string, resp *CachedResponse) int64 {
+	return int64(len(key) + len(resp.Meta) + len(resp.Body))
+}
+
+// DiskCache is a CacheStore which keeps responses as files in a directory.
+// Expired files are not removed automatically.
+type DiskCache string
+
+func (d DiskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(string(d), hex.EncodeToString(sum[:]))
+}
+
+// Get implements CacheStore.
+func (d DiskCache) Get(key string) (*CachedResponse, bool) {
+	name := d.path(key)
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, false
+	}
+
+	// The first line is the meta, and everything after it is the body.
+	reader := bufio.NewReader(bytes.NewReader(data))
+	meta, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, false
+	}
+
+	return &CachedResponse{
+		Meta:   strings.TrimSuffix(meta, "\n"),
+		Body:   data[len(meta):],
+		Stored: info.ModTime(),
+	}, true
+}
+
+// Set implements CacheStore. Errors are ignored, as the response can always
+// be generated again.
+func (d DiskCache) Set(key string, resp *CachedResponse) {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return
+	}
+
+	name := d.path(key)
+	tmp, err := ioutil.TempFile(string(d), ".tmp-")
+	if err != nil {
+		return
+	}
+
+	_, err = tmp.WriteString(resp.Meta + "\n")
+	if err == nil {
+		_, err = tmp.Write(resp.Body)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), resp.Stored, resp.Stored)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+// ResponseCache caches the successful responses of the handlers it wraps,
+// which is useful for expensive dynamically generated pages. Requests with a
+// client certificate are never cached, as their responses are likely to be
+// personalized.
+//
+//	cache := &gemini.ResponseCache{TTL: 5 * time.Minute}
+//	mux.Route("/feeds", func(r gemini.Router) {
+//		r.Use(cache.Middleware)
+//	})
+type ResponseCache struct {
+	// Store holds the cached responses. If nil, a MemoryCache is used.
+	Store CacheStore
+
+	// TTL is how long a response is replayed for. If zero, one minute is
+	// used.
+	TTL time.Duration
+
+	// MaxEntrySize is the largest body which will be cached. If zero, 1MB is
+	// used.
+	MaxEntrySize int
+
+	// RefreshAfter, if non-zero and less than TTL, enables stale while
+	// revalidate. Responses older than RefreshAfter are still served from the
+	// cache, but the handler is also run in the background to refresh them,
+	// so clients don't have to wait on expensive pages.
+	RefreshAfter time.Duration
+
+	// Key returns the cache key for a request. If nil, the URL is used.
+	Key func(r *Request) string
+
+	storeOnce sync.Once
+	store     CacheStore
+
+	// refreshing holds the keys with a background refresh in progress.
+	refreshLock sync.Mutex
+	refreshing  map[string]bool
+}
+
+// Middleware wraps next with the cache. It can be passed to Router.Use.
+func (c *ResponseCache) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		if r.Identity != nil || r.Titan != nil {
+			next.ServeGemini(ctx, w, r)
+			return
+		}
+
+		key := c.key(r)
+		if cached, ok := c.getStore().Get(key); ok {
+			age := time.Since(cached.Stored)
+			if age < c.ttl() {
+				cached.replay(w)
+
+				if c.RefreshAfter > 0 && age >= c.RefreshAfter {
+					c.refresh(ctx, key, next, r)
+				}
+				return
+			}
+		}
+
+		c.generate(ctx, key, next, w, r)
+	})
+}
+
+// ClientMiddleware caches the successful responses a Client receives, so it
+// can be passed to Client.Use. Cached responses are returned without
+// contacting the server, and have no TLS state. Bodies larger than
+// MaxEntrySize are passed through without being cached. RefreshAfter is
+// ignored.
+//
+// Responses aren't keyed by the Client's identity, so a cache shouldn't be
+// shared by Clients with different identities.
+func (c *ResponseCache) ClientMiddleware(next RoundTripper) RoundTripper {
+	return RoundTripperFunc(func(ctx context.Context, r *Request) (*Response, error) {
+		if r.Titan != nil {
+			return next.RoundTrip(ctx, r)
+		}
+
+		key := c.key(r)
+		if cached, ok := c.getStore().Get(key); ok && time.Since(cached.Stored) < c.ttl() {
+			return NewResponseBody(StatusSuccess, cached.Meta, bytes.NewReader(cached.Body))
+		}
+
+		resp, err := next.RoundTrip(ctx, r)
+		if err != nil || !resp.IsSuccess() {
+			return resp, err
+		}
+
+		max := c.maxEntrySize()
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(max)+1))
+		if err != nil {
+			_ = resp.Body.Close()
+			return nil, err
+		}
+
+		if len(body) > max {
+			resp.Body = &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(body), resp.Body), Closer: resp.Body}
+			return resp, nil
+		}
+
+		_ = resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		c.getStore().Set(key, &CachedResponse{
+			Meta:   resp.Meta,
+			Body:   body,
+			Stored: time.Now(),
+		})
+
+		return resp, nil
+	})
+}
+
+// multiReadCloser reads from Reader and closes Closer.
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// generate runs next, storing the response if it can be cached.
+func (c *ResponseCache) generate(ctx context.Context, key string, next Handler, w ResponseWriter, r *Request) {
+	rec := &cacheRecorder{ResponseWriter: w, maxSize: c.maxEntrySize()}
+	next.ServeGemini(ctx, rec, r)
+
+	if rec.cacheable() {
+		c.getStore().Set(key, &CachedResponse{
+			Meta:   rec.meta,
+			Body:   rec.body.Bytes(),
+			Stored: time.Now(),
+		})
+	}
+}
+
+// refresh regenerates the response for key in the background, unless a
+// refresh for it is already running.
+func (c *ResponseCache) refresh(ctx context.Context, key string, next Handler, r *Request) {
+	c.refreshLock.Lock()
+	if c.refreshing[key] {
+		c.refreshLock.Unlock()
+		return
+	}
+	if c.refreshing == nil {
+		c.refreshing = make(map[string]bool)
+	}
+	c.refreshing[key] = true
+	c.refreshLock.Unlock()
+
+	// The handler keeps the request's context values, but not its
+	// cancellation, as the connection is about to be closed.
+	ctx = detachedContext{ctx}
+
+	go func() {
+		defer func() {
+			c.refreshLock.Lock()
+			delete(c.refreshing, key)
+			c.refreshLock.Unlock()
+		}()
+
+		c.generate(ctx, key, next, &discardResponseWriter{}, r)
+	}()
+}
+
+func (c *ResponseCache) getStore() CacheStore {
+	c.storeOnce.Do(func() {
+		c.store = c.Store
+		if c.store == nil {
+			c.store = &MemoryCache{}
+		}
+	})
+
+	return c.store
+}
+
+func (c *ResponseCache) key(r *Request) string {
+	if c.Key != nil {
+		return c.Key(r)
+	}
+	return r.URL.String()
+}
+
+func (c *ResponseCache) ttl() time.Duration {
+	if c.TTL == 0 {
+		return time.Minute
+	}
+	return c.TTL
+}
+
+func (c *ResponseCache) maxEntrySize() int {
+	if c.MaxEntrySize == 0 {
+		return 1 << 20
+	}
+	return c.MaxEntrySize
+}
+
+func (cr *CachedResponse) replay(w ResponseWriter) {
+	if cr.Meta != "" {
+		w.WriteStatus(StatusSuccess, cr.Meta)
+	}
+	_, _ = w.Write(cr.Body)
+}
+
+// cacheRecorder passes a response through while keeping a copy of it.
+type cacheRecorder struct {
+	ResponseWriter
+
+	maxSize int
+
+	status   int
+	meta     string
+	body     bytes.Buffer
+	tooLarge bool
+	failed   bool
+}
+
+func (w *cacheRecorder) WriteStatus(statusCode int, meta string) {
+	if w.status == 0 {
+		w.status = statusCode
+		w.meta = meta
+	}
+	w.ResponseWriter.WriteStatus(statusCode, meta)
+}
+
+func (w *cacheRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		// The status is written implicitly by the underlying writer, so the
+		// meta is left empty to let that happen again on replay.
+		w.status = StatusSuccess
+	}
+
+	n, err := w.ResponseWriter.Write(data)
+	if err != nil {
+		w.failed = true
+	}
+
+	if !w.tooLarge {
+		if w.body.Len()+n > w.maxSize {
+			w.tooLarge = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(data[:n])
+		}
+	}
+
+	return n, err
+}
+
+// Flush implements Flusher.
+func (w *cacheRecorder) Flush() error {
+	return Flush(w.ResponseWriter)
+}
+
+func (w *cacheRecorder) cacheable() bool {
+	return w.status == StatusSuccess && !w.tooLarge && !w.failed
+}
+
+// discardResponseWriter is a ResponseWriter for background refreshes, where
+// there is no client.
+type discardResponseWriter struct{}
+
+func (discardResponseWriter) Write(data []byte) (int, error) { return len(data), nil }
+
+func (discardResponseWriter) WriteStatus(statusCode int, meta string) {}
+
+// detachedContext keeps the values of a context while dropping its deadline
+// and cancellation.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,109 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"time"
+)
+
+// A CertificateMonitor periodically checks certificates for upcoming expiry.
+// Long-lived self-signed certificates are common in Geminispace, and them
+// silently expiring is a classic cause of outages.
+type CertificateMonitor struct {
+	// TLS is the config whose Certificates are checked. This is generally the
+	// same config as Server.TLS.
+	TLS *tls.Config
+
+	// Extra holds additional certificates to check. Because a tls.Config's
+	// ClientCAs pool can't be listed, any client CA certificates should be
+	// added here.
+	Extra []*x509.Certificate
+
+	// Threshold is how long before expiry OnExpiring is called. If zero, 30
+	// days is used.
+	Threshold time.Duration
+
+	// Interval is how often Run checks the certificates. If zero, they are
+	// checked once a day.
+	Interval time.Duration
+
+	// OnExpiring is called for every certificate which expires within
+	// Threshold, including those which have already expired, in which case
+	// remaining is negative. If nil, a warning is logged with the log package.
+	OnExpiring func(cert *x509.Certificate, remaining time.Duration)
+}
+
+// Check checks all certificates once.
+func (m *CertificateMonitor) Check() {
+	threshold := m.Threshold
+	if threshold == 0 {
+		threshold = 30 * 24 * time.Hour
+	}
+
+	onExpiring := m.OnExpiring
+	if onExpiring == nil {
+		onExpiring = logExpiringCertificate
+	}
+
+	now := time.Now()
+	for _, cert := range m.certificates() {
+		remaining := cert.NotAfter.Sub(now)
+		if remaining < threshold {
+			onExpiring(cert, remaining)
+		}
+	}
+}
+
+// Run checks all certificates immediately and then every Interval until ctx is
+// cancelled.
+func (m *CertificateMonitor) Run(ctx context.Context) {
+	interval := m.Interval
+	if interval == 0 {
+		interval = 24 * time.Hour
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.Check()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// certificates returns every certificate in every chain in the config, along
+// with the extra certificates. Chains which fail to parse are skipped, as the
+// TLS stack will already refuse to use them.
+func (m *CertificateMonitor) certificates() []*x509.Certificate {
+	var ret []*x509.Certificate
+
+	if m.TLS != nil {
+		for _, chain := range m.TLS.Certificates {
+			for _, der := range chain.Certificate {
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					continue
+				}
+				ret = append(ret, cert)
+			}
+		}
+	}
+
+	return append(ret, m.Extra...)
+}
+
+func logExpiringCertificate(cert *x509.Certificate, remaining time.Duration) {
+	if remaining < 0 {
+		log.Printf("gemini: certificate %q expired at %s", cert.Subject.CommonName, cert.NotAfter)
+		return
+	}
+
+	log.Printf("gemini: certificate %q expires in %s, at %s", cert.Subject.CommonName, remaining.Round(time.Hour), cert.NotAfter)
+}
@@ -0,0 +1,146 @@
+package gemini
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// CGI is a Handler which runs an executable for each request, using the
+// conventions common to Gemini servers. Request details are passed in
+// environment variables such as GEMINI_URL, PATH_INFO and QUERY_STRING, with
+// TLS_CLIENT_HASH and REMOTE_USER set if the client presented a certificate.
+// The executable must write a complete Gemini response, including the header,
+// to stdout.
+//
+//	mux.Handle("/cgi/search/*path", gemini.StripPrefix("/cgi/search", &gemini.CGI{
+//		Path: "/srv/cgi/search",
+//		Root: "/cgi/search",
+//	}))
+type CGI struct {
+	// Path is the path of the executable.
+	Path string
+
+	// Args are passed to the executable.
+	Args []string
+
+	// Dir is the working directory of the executable. If empty, the
+	// directory of Path is used.
+	Dir string
+
+	// Env holds extra environment variables, in the form "key=value".
+	Env []string
+
+	// Root is the path the handler is mounted at, which is sent as
+	// SCRIPT_NAME. The request path, which generally has Root stripped, is
+	// sent as PATH_INFO.
+	Root string
+
+	// Timeout limits how long the executable may run. If zero, there is no
+	// limit.
+	Timeout time.Duration
+}
+
+// ServeGemini implements Handler.
+func (c *CGI) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Dir = c.Dir
+	if cmd.Dir == "" {
+		cmd.Dir = filepath.Dir(c.Path)
+	}
+	cmd.Env = append(c.env(ctx, r), c.Env...)
+	cmd.Stderr = os.Stderr
+
+	if r.Body != nil {
+		cmd.Stdin = r.Body
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		w.WriteStatus(StatusCGIError, "CGI error")
+		return
+	}
+
+	if err := cmd.Start(); err != nil {
+		w.WriteStatus(StatusCGIError, "CGI error")
+		return
+	}
+	defer func() {
+		// Closing stdout first makes sure a script which is still writing
+		// won't block forever if the output isn't read to the end.
+		_ = stdout.Close()
+		_ = cmd.Wait()
+	}()
+
+	reader := getBufioReader(stdout)
+	defer putBufioReader(reader)
+
+	status, meta, err := readResponseHeader(reader)
+	if err == nil {
+		err = validateHeader(status, meta)
+	}
+	if err != nil {
+		w.WriteStatus(StatusCGIError, "CGI error")
+		return
+	}
+
+	w.WriteStatus(status, meta)
+	if status >= StatusSuccess && status < StatusRedirect {
+		_, _ = copyBuffer(w, reader)
+	}
+}
+
+func (c *CGI) env(ctx context.Context, r *Request) []string {
+	env := []string{
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SERVER_PROTOCOL=GEMINI",
+		"SERVER_SOFTWARE=gopkg.in/gemini.v0",
+		"GEMINI_URL=" + r.URL.String(),
+		"SCRIPT_NAME=" + c.Root,
+		"PATH_INFO=" + r.URL.Path,
+		"QUERY_STRING=" + r.URL.RawQuery,
+		"SERVER_NAME=" + r.ServerName,
+		"PATH=" + os.Getenv("PATH"),
+	}
+
+	if addr := CtxLocalAddr(ctx); addr != nil {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			env = append(env, "SERVER_PORT="+port)
+		}
+	}
+
+	if addr := CtxRemoteAddr(ctx); addr != nil {
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			env = append(env, "REMOTE_ADDR="+host, "REMOTE_HOST="+host)
+		}
+	}
+
+	if r.Identity != nil {
+		env = append(env,
+			"AUTH_TYPE=CERTIFICATE",
+			"REMOTE_USER="+r.Identity.Subject.CommonName,
+			"TLS_CLIENT_HASH="+Fingerprint(r.Identity),
+			"TLS_CLIENT_SUBJECT="+r.Identity.Subject.String(),
+		)
+	}
+
+	if r.Titan != nil {
+		env = append(env,
+			"CONTENT_TYPE="+r.Titan.MIME,
+			"CONTENT_LENGTH="+strconv.FormatInt(r.Titan.Size, 10),
+			"TITAN_TOKEN="+r.Titan.Token,
+		)
+	}
+
+	return env
+}
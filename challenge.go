@@ -0,0 +1,196 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ChallengeQuestion is a question asked by Challenge, along with the
+// answers it accepts. Answers are compared case-insensitively, ignoring
+// surrounding whitespace.
+type ChallengeQuestion struct {
+	Prompt  string
+	Answers []string
+}
+
+// Challenge is a middleware which asks clients a question before letting
+// them through to the next handler, to deter scripted abuse of expensive
+// endpoints like search or uploads. Once a client has answered correctly, it
+// isn't asked again until Valid has passed.
+//
+// The question is asked with gemini.StatusInput on the requested URL, and
+// after a correct answer the client is redirected to the URL without the
+// query. The request which answers the question is never passed on, so
+// endpoints which take input will prompt again afterwards.
+//
+// The zero value asks simple arithmetic questions and is ready to use.
+type Challenge struct {
+	// Questions are the questions to pick from. If empty, an arithmetic
+	// question is generated for each challenge.
+	Questions []ChallengeQuestion
+
+	// Key returns the key a challenge is recorded against. Requests for
+	// which it returns an empty string are always let through. If nil,
+	// QuotaByClient is used, so clients with a certificate are remembered
+	// even if their IP changes.
+	Key func(ctx context.Context, r *Request) string
+
+	// Valid is how long a correct answer is remembered. If zero, 24 hours is
+	// used.
+	Valid time.Duration
+
+	// Timeout is how long a client has to answer a question. If zero, 10
+	// minutes is used.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	passed  map[string]time.Time
+	pending map[string]pendingChallenge
+	checks  int
+}
+
+type pendingChallenge struct {
+	question ChallengeQuestion
+	expires  time.Time
+}
+
+func (c *Challenge) valid() time.Duration {
+	if c.Valid > 0 {
+		return c.Valid
+	}
+	return 24 * time.Hour
+}
+
+func (c *Challenge) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return 10 * time.Minute
+}
+
+// Middleware returns a handler which only calls next for clients which have
+// answered a question.
+func (c *Challenge) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		keyFunc := c.Key
+		if keyFunc == nil {
+			keyFunc = QuotaByClient
+		}
+
+		key := keyFunc(ctx, r)
+		if key == "" || c.hasPassed(key) {
+			next.ServeGemini(ctx, w, r)
+			return
+		}
+
+		question, ok := c.pendingQuestion(key)
+		if !ok || r.URL.RawQuery == "" {
+			w.WriteStatus(StatusInput, c.ask(key).Prompt)
+			return
+		}
+
+		answer, err := url.QueryUnescape(r.URL.RawQuery)
+		if err != nil || !question.accepts(answer) {
+			w.WriteStatus(StatusInput, "Wrong answer. "+c.ask(key).Prompt)
+			return
+		}
+
+		c.pass(key)
+
+		target := *r.URL
+		target.RawQuery = ""
+		w.WriteStatus(StatusRedirect, target.String())
+	})
+}
+
+func (q ChallengeQuestion) accepts(answer string) bool {
+	answer = strings.TrimSpace(answer)
+	for _, a := range q.Answers {
+		if strings.EqualFold(answer, strings.TrimSpace(a)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Challenge) hasPassed(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweepLocked()
+
+	expires, ok := c.passed[key]
+	return ok && time.Now().Before(expires)
+}
+
+func (c *Challenge) pendingQuestion(key string) (ChallengeQuestion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.pending[key]
+	if !ok || time.Now().After(p.expires) {
+		return ChallengeQuestion{}, false
+	}
+	return p.question, true
+}
+
+// ask picks a new question for key, replacing any which was pending.
+func (c *Challenge) ask(key string) ChallengeQuestion {
+	var q ChallengeQuestion
+	if len(c.Questions) > 0 {
+		q = c.Questions[rand.Intn(len(c.Questions))]
+	} else {
+		a, b := rand.Intn(10)+1, rand.Intn(10)+1
+		q = ChallengeQuestion{
+			Prompt:  fmt.Sprintf("To continue, what is %d plus %d?", a, b),
+			Answers: []string{fmt.Sprint(a + b)},
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending == nil {
+		c.pending = make(map[string]pendingChallenge)
+	}
+	c.pending[key] = pendingChallenge{question: q, expires: time.Now().Add(c.timeout())}
+
+	return q
+}
+
+func (c *Challenge) pass(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.passed == nil {
+		c.passed = make(map[string]time.Time)
+	}
+	c.passed[key] = time.Now().Add(c.valid())
+	delete(c.pending, key)
+}
+
+// sweepLocked occasionally discards expired entries, so the maps don't grow
+// without bound.
+func (c *Challenge) sweepLocked() {
+	c.checks++
+	if c.checks%1024 != 0 {
+		return
+	}
+
+	now := time.Now()
+	for key, expires := range c.passed {
+		if now.After(expires) {
+			delete(c.passed, key)
+		}
+	}
+	for key, p := range c.pending {
+		if now.After(p.expires) {
+			delete(c.pending, key)
+		}
+	}
+}
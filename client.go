@@ -4,7 +4,11 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"net"
 	"net/url"
+	"strings"
+	"time"
 )
 
 func defaultCheckRedirect(req *Request, via []*Request) error {
@@ -18,6 +22,38 @@ func defaultCheckRedirect(req *Request, via []*Request) error {
 	return nil
 }
 
+// A RoundTripper sends a single request and returns its response, without
+// following redirects. It is used by the Client to support schemes other than
+// gemini.
+type RoundTripper interface {
+	RoundTrip(ctx context.Context, r *Request) (*Response, error)
+}
+
+// RoundTripperFunc adapts a function to work as a RoundTripper.
+type RoundTripperFunc func(ctx context.Context, r *Request) (*Response, error)
+
+// RoundTrip implements RoundTripper.
+func (f RoundTripperFunc) RoundTrip(ctx context.Context, r *Request) (*Response, error) {
+	return f(ctx, r)
+}
+
+// A SchemeRedirectPolicy controls how the Client handles redirects to URLs
+// which don't use the gemini scheme.
+type SchemeRedirectPolicy int
+
+const (
+	// SchemeRedirectError returns the redirect response along with
+	// ErrUnknownProtocol. This is the default.
+	SchemeRedirectError SchemeRedirectPolicy = iota
+
+	// SchemeRedirectReturn returns the redirect response without an error,
+	// leaving it up to the caller to decide what to do with the Meta.
+	SchemeRedirectReturn
+
+	// SchemeRedirectFollow follows the redirect using Client.SchemeHandler.
+	SchemeRedirectFollow
+)
+
 // Client is a Gemini client. Its zero value (also stored in DefaultClient) is a
 // usable client.
 //
@@ -38,6 +74,140 @@ type Client struct {
 	// Identity is the client's identity certificate. It will be sent to the
 	// server to authenticate.
 	Identity *tls.Certificate
+
+	// Timeout specifies a time limit for each request made by this Client, up
+	// to the response status being received. A Timeout of zero means no
+	// timeout.
+	Timeout time.Duration
+
+	// TOFU, if set, is used to verify server certificates with trust on first
+	// use. If TOFU is nil, server certificates are not verified at all.
+	TOFU TOFUStore
+
+	// OnTOFUEvent, if set, is called when a TOFU certificate changes, is
+	// replaced after expiring, or is close to expiring. It is called from the
+	// goroutine making the request, so it should not block.
+	OnTOFUEvent func(event TOFUEvent)
+
+	// TOFUExpiryWarning is how long before a known certificate expires
+	// TOFUCertificateExpiring events are sent. If zero, 14 days is used.
+	TOFUExpiryWarning time.Duration
+
+	// DANE enables validating server certificates against TLSA records from
+	// DNSSEC signed zones. Certificates which pass DANE validation are stored
+	// in TOFU, replacing any previously known certificate.
+	DANE DANEMode
+
+	// PrepareRequest, if set, is called before every request is sent,
+	// including requests made to follow redirects. It may modify the request,
+	// for example to rewrite the URL or set ServerName. If it returns an
+	// error, the request is not sent and the error is returned.
+	PrepareRequest func(*Request) error
+
+	// SchemeRedirect controls what happens when a server redirects to a URL
+	// with a scheme other than gemini. In all cases, the body of the redirect
+	// response has already been closed.
+	SchemeRedirect SchemeRedirectPolicy
+
+	// PreserveQuery makes the Client re-apply the query of a request to the
+	// target of a redirect from it, if the target doesn't have a query of
+	// its own. Otherwise, as with normal URL resolution, the query is
+	// dropped. Targets ending with an empty query, such as "page?", never
+	// get the query.
+	PreserveQuery bool
+
+	// SchemeHandler is used to follow redirects to other schemes when
+	// SchemeRedirect is SchemeRedirectFollow. CheckRedirect is consulted
+	// before it is called. If SchemeHandler is nil, ErrUnknownProtocol is
+	// returned instead.
+	SchemeHandler func(ctx context.Context, r *Request) (*Response, error)
+
+	// AllowHost, if set, is called with every address a hostname resolves to
+	// before connecting. Addresses for which it returns an error are skipped,
+	// and if no addresses remain the error is returned. DenyPrivateAddresses
+	// is a good default for server-side fetchers.
+	AllowHost func(host string, ip net.IP) error
+
+	// OnResponse, if set, is called with every response the Client receives,
+	// including redirects which are then followed. It is intended for
+	// updating a UI, so it must not read or close the body.
+	OnResponse func(resp *Response)
+
+	// OnRedirect, if set, is called when the Client is about to follow a
+	// redirect from resp to next, after CheckRedirect has allowed it.
+	OnRedirect func(resp *Response, next *Request)
+
+	// OnTLSStateChange, if set, is called after every TLS handshake, once the
+	// server certificate has been verified, with the state of the new
+	// connection. This can be used to keep something like a padlock icon up
+	// to date while following redirects across hosts.
+	OnTLSStateChange func(r *Request, state *tls.ConnectionState)
+
+	// schemes holds the RoundTrippers added with RegisterScheme.
+	schemes map[string]RoundTripper
+
+	// middlewares holds the ClientMiddleware added with Use.
+	middlewares []ClientMiddleware
+
+	// sessions is lazily created by sessionCache and is used to resume TLS
+	// sessions, most notably those set up by PreDial.
+	sessions tls.ClientSessionCache
+}
+
+// Clone returns a shallow copy of c. Hooks and stores, such as TOFU, are shared
+// with the original Client.
+func (c *Client) Clone() *Client {
+	c2 := *c
+
+	// TLS sessions may be tied to the Identity, so they aren't shared.
+	c2.sessions = nil
+
+	c2.middlewares = append([]ClientMiddleware(nil), c.middlewares...)
+
+	c2.schemes = make(map[string]RoundTripper, len(c.schemes))
+	for scheme, rt := range c.schemes {
+		c2.schemes[scheme] = rt
+	}
+
+	return &c2
+}
+
+// RegisterScheme registers rt to handle requests for URLs with the given
+// scheme, both when requested directly and when redirected to. Requests for
+// unregistered schemes other than gemini are sent to the server as Gemini
+// proxy requests.
+//
+// RegisterScheme must not be called concurrently with requests using c.
+func (c *Client) RegisterScheme(scheme string, rt RoundTripper) {
+	if scheme == "gemini" {
+		panic("gemini: cannot replace the gemini scheme")
+	}
+
+	if c.schemes == nil {
+		c.schemes = make(map[string]RoundTripper)
+	}
+	c.schemes[scheme] = rt
+}
+
+// roundTrip sends a single request through the Client's middleware.
+func (c *Client) roundTrip(ctx context.Context, r *Request) (*Response, error) {
+	var rt RoundTripper = RoundTripperFunc(c.send)
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		rt = c.middlewares[i](rt)
+	}
+
+	return rt.RoundTrip(ctx, r)
+}
+
+// send sends a single request, using a registered RoundTripper if there is
+// one for the request's scheme. Requests with an Addr are always sent over
+// Gemini.
+func (c *Client) send(ctx context.Context, r *Request) (*Response, error) {
+	if rt := c.schemes[r.URL.Scheme]; rt != nil && r.Addr == "" {
+		return rt.RoundTrip(ctx, r)
+	}
+
+	return c.doRequest(ctx, r)
 }
 
 // checkRedirect calls either the user's configured CheckRedirect function, or
@@ -84,18 +254,41 @@ func (c *Client) Do(req *Request) (*Response, error) {
 // DoContext sends a Gemini request and returns a Gemini response, following
 // policy (such as redirects, auth) as configured on the client.
 //
+// If both a Response and an error are returned, the Response's Body has already
+// been discarded and closed.
+//
 // The context is only used up to the response status. The response body needs
 // to be handled separately.
 func (c *Client) DoContext(ctx context.Context, r *Request) (*Response, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
 	var reqs []*Request
 
 	for {
-		resp, err := c.doRequest(ctx, r)
+		if c.PrepareRequest != nil {
+			if err := c.PrepareRequest(r); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := c.roundTrip(ctx, r)
 		if err != nil {
 			return nil, err
 		}
 
+		resp.Request = r
+		resp.Via = append([]*Request(nil), reqs...)
+
+		if c.OnResponse != nil {
+			c.OnResponse(resp)
+		}
+
 		if resp.statusIsUnknown() {
+			_ = resp.Discard()
 			return resp, ErrUnknownStatus
 		}
 
@@ -106,25 +299,43 @@ func (c *Client) DoContext(ctx context.Context, r *Request) (*Response, error) {
 
 		// Close the body because we're done with it, otherwise these might end
 		// up leaking. Thankfully, there is no connection keepalive, so we can
-		// safely close it.
-		err = resp.Body.Close()
-		if err != nil {
-			return nil, err
-		}
+		// safely close it. From this point on, any response returned alongside
+		// an error has a closed body. Errors are ignored because the body of a
+		// redirect isn't meaningful.
+		_ = resp.Discard()
 
 		// Add the current request to the request chain before making a new
 		// request.
 		reqs = append(reqs, r)
 
-		ref, err := url.Parse(resp.Meta)
+		target, err := resolveRedirect(r.URL, resp.Meta, c.PreserveQuery)
 		if err != nil {
-			return nil, err
+			return resp, err
 		}
 
-		r = NewRequestURL(r.URL.ResolveReference(ref))
+		next := NewRequestURL(target)
+		next.HeaderTimeout = r.HeaderTimeout
+		next.TotalTimeout = r.TotalTimeout
+
+		// Redirects from a proxied request go through the same proxy.
+		if r.Addr != "" {
+			next.Addr = r.Addr
+			next.ServerName = r.ServerName
+		}
+		r = next
+
+		// If this isn't a gemini URL and we don't have a registered scheme for
+		// it, what we do depends on the policy.
+		if r.URL.Scheme != "gemini" && r.Addr == "" && c.schemes[r.URL.Scheme] == nil {
+			switch c.SchemeRedirect {
+			case SchemeRedirectReturn:
+				return resp, nil
+			case SchemeRedirectFollow:
+				if c.SchemeHandler != nil {
+					return c.followScheme(ctx, r, reqs, resp)
+				}
+			}
 
-		// If this isn't a gemini URL, return the raw resp.
-		if r.URL.Scheme != "gemini" {
 			return resp, ErrUnknownProtocol
 		}
 
@@ -134,36 +345,104 @@ func (c *Client) DoContext(ctx context.Context, r *Request) (*Response, error) {
 		if err != nil {
 			return resp, err
 		}
+
+		if c.OnRedirect != nil {
+			c.OnRedirect(resp, r)
+		}
 	}
 }
 
-func (c *Client) doRequest(ctx context.Context, r *Request) (*Response, error) {
-	hostname := r.URL.Hostname()
-	port := r.URL.Port()
-	if port == "" {
-		port = "1965"
+// resolveRedirect returns the URL a redirect from base with the given meta
+// points to. Whitespace around the meta is ignored, and fragments are dropped
+// as they are never sent in requests. If preserveQuery is set, the query of
+// base is kept when the target has none.
+func resolveRedirect(base *url.URL, meta string, preserveQuery bool) (*url.URL, error) {
+	meta = strings.TrimSpace(meta)
+	if meta == "" {
+		return nil, fmt.Errorf("%w: empty target", ErrInvalidRedirect)
+	}
+
+	ref, err := url.Parse(meta)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+	}
+
+	u := base.ResolveReference(ref)
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if preserveQuery && u.RawQuery == "" && !u.ForceQuery {
+		u.RawQuery = base.RawQuery
+	}
+
+	if u.Scheme == "gemini" {
+		if err := NormalizeURL(u); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+		}
+	}
+
+	return u, nil
+}
+
+// followScheme follows a redirect to a non-gemini URL using the SchemeHandler.
+// prev is the redirect response, which is returned if the redirect policy
+// rejects this request.
+func (c *Client) followScheme(ctx context.Context, r *Request, via []*Request, prev *Response) (*Response, error) {
+	err := c.checkRedirect(r, via)
+	if err != nil {
+		return prev, err
 	}
 
-	// TODO: this needs to be better. Unfortunately the spec allows/recommends
-	// that people not set up letsencrypt or something similar, so we will need
-	// to handle that another way. The generally accepted method is TOFU (trust
-	// on first use).
-	dialer := &tls.Dialer{
-		Config: &tls.Config{
-			MinVersion:         tls.VersionTLS12,
-			InsecureSkipVerify: true,
-		},
+	if c.OnRedirect != nil {
+		c.OnRedirect(prev, r)
 	}
 
-	if c.Identity != nil {
-		dialer.Config.Certificates = []tls.Certificate{*c.Identity}
+	resp, err := c.SchemeHandler(ctx, r)
+	if err != nil {
+		return nil, err
 	}
 
-	rawConn, err := dialer.DialContext(ctx, "tcp", hostname+":"+port)
+	resp.Request = r
+	resp.Via = append([]*Request(nil), via...)
+
+	if c.OnResponse != nil {
+		c.OnResponse(resp)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) doRequest(ctx context.Context, r *Request) (*Response, error) {
+	var deadline time.Time
+	if r.TotalTimeout > 0 {
+		deadline = time.Now().Add(r.TotalTimeout)
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	if r.HeaderTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.HeaderTimeout)
+		defer cancel()
+	}
+
+	conn, err := c.dial(ctx, r)
 	if err != nil {
 		return nil, err
 	}
-	conn := rawConn.(*tls.Conn)
+
+	state := conn.ConnectionState()
+	if c.OnTLSStateChange != nil {
+		c.OnTLSStateChange(r, &state)
+	}
+
+	// The context stops applying once the header has been read, so the total
+	// timeout is enforced on the body with a deadline on the connection.
+	if !deadline.IsZero() {
+		_ = conn.SetDeadline(deadline)
+	}
 
 	type retVal struct {
 		resp *Response
@@ -213,6 +492,9 @@ func (c *Client) doRequest(ctx context.Context, r *Request) (*Response, error) {
 		*/
 
 		resp, err := ReadResponse(conn)
+		if resp != nil {
+			resp.TLS = &state
+		}
 		retChan <- retVal{resp, err}
 	}()
 
@@ -222,7 +504,7 @@ func (c *Client) doRequest(ctx context.Context, r *Request) (*Response, error) {
 	// prevent leaking the reader goroutine.
 	if ret.resp == nil {
 		// Yes, an error is being ignored here, but it's by design.
-		_ = rawConn.Close()
+		_ = conn.Close()
 	}
 
 	return ret.resp, ret.err
@@ -0,0 +1,182 @@
+package gemini_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gopkg.in/gemini.v0"
+)
+
+// unreadBody is sent after redirects and unknown statuses. It is too large to
+// be buffered by the connection, so the handler only returns once the client
+// has closed it.
+var unreadBody = bytes.Repeat([]byte("x"), 4<<20)
+
+func redirectHandler() gemini.Handler {
+	return gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		switch {
+		case r.URL.Path == "/ok":
+			w.WriteStatus(gemini.StatusSuccess, "text/plain")
+			_, _ = w.Write([]byte("ok"))
+			return
+		case r.URL.Path == "/unknown":
+			w.WriteStatus(99, "unknown")
+		case r.URL.Path == "/empty":
+			w.WriteStatus(gemini.StatusRedirect, "")
+		case r.URL.Path == "/loop":
+			w.WriteStatus(gemini.StatusRedirect, "/loop")
+		case strings.HasPrefix(r.URL.Path, "/redirect/"):
+			w.WriteStatus(gemini.StatusRedirect, "/ok")
+		}
+
+		_, _ = w.Write(unreadBody)
+	})
+}
+
+func TestClientClosesBodies(t *testing.T) {
+	rejectRedirects := func(req *gemini.Request, via []*gemini.Request) error {
+		return errors.New("redirects are not allowed")
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		checkRedirect func(*gemini.Request, []*gemini.Request) error
+		wantErr       bool
+	}{
+		{name: "followed redirect", path: "/redirect/1"},
+		{name: "rejected redirect", path: "/redirect/1", checkRedirect: rejectRedirects, wantErr: true},
+		{name: "too many redirects", path: "/loop", wantErr: true},
+		{name: "invalid redirect", path: "/empty", wantErr: true},
+		{name: "unknown status", path: "/unknown", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+
+			server := &gemini.Server{Handler: redirectHandler()}
+			addr, counter, _ := startServer(t, server)
+
+			client := &gemini.Client{CheckRedirect: tt.checkRedirect}
+			resp, err := client.Get("gemini://" + addr + tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil {
+				_ = resp.Body.Close()
+			}
+
+			counter.waitClosed(t)
+			_ = server.Close()
+			checkGoroutines(t, before)
+		})
+	}
+}
+
+func TestResponseDiscard(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	server := &gemini.Server{Handler: gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		w.WriteStatus(gemini.StatusSuccess, "application/octet-stream")
+		_, _ = w.Write(unreadBody)
+	})}
+	addr, counter, _ := startServer(t, server)
+
+	resp, err := gemini.Get("gemini://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resp.Discard(); err != nil {
+		t.Fatal(err)
+	}
+
+	counter.waitClosed(t)
+	_ = server.Close()
+	checkGoroutines(t, before)
+}
+
+// followRedirect requests start from a server which redirects it to meta,
+// and returns the URL the Client was redirected to, as the server received
+// it.
+func followRedirect(t *testing.T, start, meta string) (*url.URL, error) {
+	t.Helper()
+
+	targets := make(chan *url.URL, 1)
+	server := &gemini.Server{Handler: gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		if r.URL.RequestURI() == start {
+			w.WriteStatus(gemini.StatusPermanentRedirect, meta)
+			return
+		}
+
+		targets <- r.URL
+		w.WriteStatus(gemini.StatusSuccess, "text/plain")
+	})}
+	addr, _, _ := startServer(t, server)
+
+	resp, err := gemini.Get("gemini://" + addr + start)
+	if err != nil {
+		return nil, err
+	}
+	_ = resp.Body.Close()
+
+	if resp.Status != gemini.StatusSuccess {
+		t.Fatalf("redirect to %q got %d %s", meta, resp.Status, resp.Meta)
+	}
+
+	return <-targets, nil
+}
+
+func checkRedirect(t *testing.T, start, meta, want string) {
+	t.Helper()
+
+	target, err := followRedirect(t, start, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if target.Fragment != "" {
+		t.Errorf("redirect to %q requested fragment %q", meta, target.Fragment)
+	}
+	if got := target.RequestURI(); got != want {
+		t.Errorf("redirect from %s to %q requested %s, want %s", start, meta, got, want)
+	}
+}
+
+func TestRedirectRelativeWithQuery(t *testing.T) {
+	checkRedirect(t, "/dir/start", "target?a=1&b=2", "/dir/target?a=1&b=2")
+}
+
+func TestRedirectParentPath(t *testing.T) {
+	checkRedirect(t, "/a/b/c/start", "../../target", "/a/target")
+}
+
+func TestRedirectAboveRoot(t *testing.T) {
+	checkRedirect(t, "/dir/start", "../../../target", "/target")
+}
+
+func TestRedirectQueryOnly(t *testing.T) {
+	checkRedirect(t, "/page", "?page=2", "/page?page=2")
+}
+
+func TestRedirectWhitespace(t *testing.T) {
+	checkRedirect(t, "/dir/start", " target \t", "/dir/target")
+}
+
+func TestRedirectFragment(t *testing.T) {
+	checkRedirect(t, "/dir/start", "target#section", "/dir/target")
+}
+
+func TestRedirectEmpty(t *testing.T) {
+	for _, meta := range []string{"", " \t"} {
+		_, err := followRedirect(t, "/start", meta)
+		if !errors.Is(err, gemini.ErrInvalidRedirect) {
+			t.Errorf("redirect to %q returned %v, want ErrInvalidRedirect", meta, err)
+		}
+	}
+}
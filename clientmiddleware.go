@@ -0,0 +1,273 @@
+package gemini
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A ClientMiddleware wraps the RoundTripper a Client sends requests with, to
+// change or observe every request and response. Middleware is added with
+// Client.Use and runs for each request the Client makes, including those made
+// to follow redirects and those for registered schemes.
+//
+// Ready-made middleware includes LogRoundTrips, VerifyTOFU,
+// ResponseCache.ClientMiddleware, RateLimit.ClientMiddleware and
+// Robots.ClientMiddleware:
+//
+//	client := &gemini.Client{}
+//	client.Use(
+//		gemini.LogRoundTrips(nil),
+//		(&gemini.Robots{Agents: []string{gemini.UserAgentIndexer}}).ClientMiddleware,
+//		(&gemini.RateLimit{Rate: 1, Burst: 5}).ClientMiddleware,
+//	)
+type ClientMiddleware func(next RoundTripper) RoundTripper
+
+// Use appends middlewares to the Client. The first middleware is the
+// outermost, so it sees requests first and responses last.
+//
+// Use must not be called concurrently with requests using c.
+func (c *Client) Use(middlewares ...ClientMiddleware) {
+	c.middlewares = append(c.middlewares, middlewares...)
+}
+
+// LogRoundTrips returns a ClientMiddleware which logs each request and its
+// response or error to l. If l is nil, the log package's standard logger is
+// used.
+func LogRoundTrips(l *log.Logger) ClientMiddleware {
+	logf := log.Printf
+	if l != nil {
+		logf = l.Printf
+	}
+
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, r *Request) (*Response, error) {
+			logf("--> %s", r.URL)
+
+			start := time.Now()
+			resp, err := next.RoundTrip(ctx, r)
+			elapsed := time.Since(start).Round(time.Millisecond)
+
+			if err != nil {
+				logf("<-- %v (%v)", err, elapsed)
+			} else {
+				logf("<-- %d %s (%v)", resp.Status, resp.Meta, elapsed)
+			}
+
+			return resp, err
+		})
+	}
+}
+
+// VerifyTOFU returns a ClientMiddleware which checks server certificates
+// against store with trust on first use, returning ErrCertificateChanged for
+// responses from servers whose certificate has changed. It works like
+// Client.TOFU, except that the certificate is only checked once the request
+// has been sent, so Client.TOFU is better for requests which contain
+// anything secret. Responses without TLS state, such as those from
+// registered schemes, aren't checked.
+func VerifyTOFU(store TOFUStore) ClientMiddleware {
+	verifier := &Client{TOFU: store}
+
+	return func(next RoundTripper) RoundTripper {
+		return RoundTripperFunc(func(ctx context.Context, r *Request) (*Response, error) {
+			resp, err := next.RoundTrip(ctx, r)
+			if err != nil || resp.TLS == nil {
+				return resp, err
+			}
+
+			if len(resp.TLS.PeerCertificates) == 0 {
+				_ = resp.Discard()
+				return nil, errors.New("server did not present a certificate")
+			}
+
+			hostname, port, err := r.connectAddr()
+			if err == nil {
+				err = verifier.verifyTOFU(net.JoinHostPort(hostname, port), resp.TLS.PeerCertificates[0])
+			}
+			if err != nil {
+				_ = resp.Discard()
+				return nil, err
+			}
+
+			return resp, nil
+		})
+	}
+}
+
+// maxRobotsSize is the most of a robots.txt file Robots reads.
+const maxRobotsSize = 64 << 10
+
+// Robots makes a Client follow the robots.txt companion spec, by fetching
+// each host's robots.txt and refusing requests it disallows with
+// ErrDisallowedByRobots. Hosts without a robots.txt allow everything.
+type Robots struct {
+	// Agents are the virtual user agents the Client acts as, such as
+	// UserAgentIndexer. Rules for "*" always apply.
+	Agents []string
+
+	// TTL is how long each host's robots.txt is kept before it is fetched
+	// again. If zero, one hour is used.
+	TTL time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*robotsRules
+}
+
+type robotsRules struct {
+	allow    []string
+	disallow []string
+	fetched  time.Time
+}
+
+// ClientMiddleware returns next wrapped with the robots.txt checks. It can be
+// passed to Client.Use.
+func (rb *Robots) ClientMiddleware(next RoundTripper) RoundTripper {
+	return RoundTripperFunc(func(ctx context.Context, r *Request) (*Response, error) {
+		if r.URL.Path == "/robots.txt" {
+			return next.RoundTrip(ctx, r)
+		}
+
+		rules := rb.rules(ctx, next, r)
+		if !rules.allowed(r.URL.EscapedPath()) {
+			return nil, fmt.Errorf("%w: %s", ErrDisallowedByRobots, r.URL)
+		}
+
+		return next.RoundTrip(ctx, r)
+	})
+}
+
+func (rb *Robots) ttl() time.Duration {
+	if rb.TTL > 0 {
+		return rb.TTL
+	}
+	return time.Hour
+}
+
+// rules returns the rules for the host of r, fetching them with next if they
+// aren't known.
+func (rb *Robots) rules(ctx context.Context, next RoundTripper, r *Request) *robotsRules {
+	key := r.URL.Scheme + "://" + r.URL.Host
+
+	rb.mu.Lock()
+	rules, ok := rb.hosts[key]
+	rb.mu.Unlock()
+
+	if ok && time.Since(rules.fetched) < rb.ttl() {
+		return rules
+	}
+
+	req := NewRequestURL(&url.URL{Scheme: r.URL.Scheme, Host: r.URL.Host, Path: "/robots.txt"})
+	req.Addr = r.Addr
+	req.ServerName = r.ServerName
+	req.HeaderTimeout = r.HeaderTimeout
+	req.TotalTimeout = r.TotalTimeout
+
+	resp, err := next.RoundTrip(ctx, req)
+	if err != nil {
+		// The host may just be down, so don't remember anything.
+		return &robotsRules{}
+	}
+	defer resp.Discard()
+
+	rules = &robotsRules{fetched: time.Now()}
+	if resp.IsSuccess() {
+		rules.allow, rules.disallow = parseRobots(io.LimitReader(resp.Body, maxRobotsSize), rb.Agents)
+	}
+
+	rb.mu.Lock()
+	if rb.hosts == nil {
+		rb.hosts = make(map[string]*robotsRules)
+	}
+	rb.hosts[key] = rules
+	rb.mu.Unlock()
+
+	return rules
+}
+
+// parseRobots returns the Allow and Disallow paths from the groups of a
+// robots.txt file which apply to "*" or any of agents.
+func parseRobots(r io.Reader, agents []string) (allow, disallow []string) {
+	applies := false
+	inAgents := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			continue
+		}
+
+		field := strings.ToLower(strings.TrimSpace(line[:colon]))
+		value := strings.TrimSpace(line[colon+1:])
+
+		switch field {
+		case "user-agent":
+			// Consecutive User-agent lines share a group.
+			if !inAgents {
+				applies = false
+			}
+			inAgents = true
+
+			if value == "*" || containsFold(agents, value) {
+				applies = true
+			}
+
+		case "allow", "disallow":
+			inAgents = false
+			if !applies || value == "" {
+				continue
+			}
+
+			if field == "allow" {
+				allow = append(allow, value)
+			} else {
+				disallow = append(disallow, value)
+			}
+		}
+	}
+
+	return allow, disallow
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether path may be requested. The longest matching rule
+// wins, with Allow winning ties.
+func (rules *robotsRules) allowed(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+
+	longest := func(prefixes []string) int {
+		n := -1
+		for _, p := range prefixes {
+			if strings.HasPrefix(path, p) && len(p) > n {
+				n = len(p)
+			}
+		}
+		return n
+	}
+
+	return longest(rules.allow) >= longest(rules.disallow)
+}
@@ -0,0 +1,109 @@
+// Command replay re-issues the requests in an archive, such as one captured
+// with archive.Recorder, against a server and reports any responses which
+// differ from the archived ones.
+//
+//	replay -addr localhost:1965 capture.warc
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"gopkg.in/gemini.v0"
+	"gopkg.in/gemini.v0/archive"
+)
+
+var addr = flag.String("addr", "", "host:port to send requests to, instead of the hosts in the archive")
+var timeout = flag.Duration("timeout", 30*time.Second, "timeout for each request")
+var compareBody = flag.Bool("body", true, "compare response bodies as well as headers")
+
+func main() {
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: replay [flags] archive.warc")
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		panic(err.Error())
+	}
+	defer f.Close()
+
+	client := &gemini.Client{
+		Timeout: *timeout,
+		CheckRedirect: func(req *gemini.Request, via []*gemini.Request) error {
+			return errNoRedirects
+		},
+	}
+
+	var total, failed int
+
+	r := archive.NewReader(f)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+
+		total++
+		if diff := replay(client, rec); diff != "" {
+			failed++
+			fmt.Printf("%s: %s\n", rec.URL, diff)
+		}
+	}
+
+	fmt.Printf("%d requests, %d differences\n", total, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+var errNoRedirects = errors.New("redirects are compared, not followed")
+
+// replay sends the request from rec and describes how the response differs,
+// or returns an empty string if it matches.
+func replay(client *gemini.Client, rec *archive.Record) string {
+	req, err := gemini.NewRequest(rec.URL)
+	if err != nil {
+		return err.Error()
+	}
+	if *addr != "" {
+		req.Addr = *addr
+	}
+
+	resp, err := client.Do(req)
+	if resp == nil {
+		return err.Error()
+	}
+	defer resp.Body.Close()
+
+	if resp.Status != rec.Status || resp.Meta != rec.Meta {
+		return fmt.Sprintf("got %d %s, archived %d %s", resp.Status, resp.Meta, rec.Status, rec.Meta)
+	}
+
+	if !*compareBody || !resp.IsSuccess() {
+		return ""
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err.Error()
+	}
+
+	if !bytes.Equal(body, rec.Body) {
+		return fmt.Sprintf("body differs: got %d bytes, archived %d bytes", len(body), len(rec.Body))
+	}
+
+	return ""
+}
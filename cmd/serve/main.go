@@ -1,19 +1,22 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"mime"
+	"os"
 
 	"gopkg.in/gemini.v0"
+	"gopkg.in/gemini.v0/archive"
 )
 
 var identityCertFile = flag.String("identity-cert", "", "identity cert file to use for requests")
 var identityKeyFile = flag.String("identity-key", "", "identity key file to use for requests")
+var archiveFile = flag.String("archive", "", "zip or tar.gz archive to serve under /files instead of the current directory")
+var routesFile = flag.String("routes", "", "JSON route table to serve instead of the default routes")
+var captureFile = flag.String("capture", "", "archive file to append every transaction to, for use with cmd/replay")
 
 func printRequest(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
 	params := gemini.CtxParams(ctx)
@@ -25,20 +28,23 @@ func printRequest(ctx context.Context, w gemini.ResponseWriter, r *gemini.Reques
 	fmt.Fprintf(w, "Hello %s!\n", params[0])
 
 	if r.Identity != nil {
-		hash := sha256.New()
-		_, _ = hash.Write(r.Identity.Raw)
-		fingerprint := hash.Sum(nil)
-
-		var buf bytes.Buffer
-		for i, f := range fingerprint {
-			if i > 0 {
-				fmt.Fprintf(&buf, ":")
-			}
-			fmt.Fprintf(&buf, "%02X", f)
-		}
+		fmt.Fprintf(w, "\nFingerprint: %s\n", gemini.Fingerprint(r.Identity))
+	}
+}
 
-		fmt.Fprintf(w, "\nFingerprint: %s\n", buf.String())
+func loadRoutes(name string) (*gemini.ServeMux, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	routes, err := gemini.LoadRoutes(f)
+	if err != nil {
+		return nil, err
 	}
+
+	return gemini.NewRouteMux(routes)
 }
 
 func main() {
@@ -49,14 +55,48 @@ func main() {
 	_ = mime.AddExtensionType(".md", "text/markdown")
 	_ = mime.AddExtensionType(".go", "text/plain")
 
-	mux := gemini.NewServeMux()
+	var handler gemini.Handler
+	if *routesFile != "" {
+		mux, err := loadRoutes(*routesFile)
+		if err != nil {
+			panic(err.Error())
+		}
+
+		handler = mux
+	} else {
+		mux := gemini.NewServeMux()
+
+		mux.Handle("/hello/:world", gemini.HandlerFunc(printRequest))
+		var files gemini.FileSystem = gemini.Dir(".")
+		if *archiveFile != "" {
+			fs, closer, err := gemini.OpenArchive(*archiveFile)
+			if err != nil {
+				panic(err.Error())
+			}
+			defer closer.Close()
+
+			files = fs
+		}
+
+		mux.Handle("/files/:rest", gemini.StripPrefix("/files", gemini.FileServer(files)))
+
+		handler = mux
+	}
 
-	mux.Handle("/hello/:world", gemini.HandlerFunc(printRequest))
-	mux.Handle("/files/:rest", gemini.StripPrefix("/files", gemini.FileServer(gemini.Dir("."))))
+	if *captureFile != "" {
+		f, err := os.OpenFile(*captureFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			panic(err.Error())
+		}
+		defer f.Close()
+
+		rec := &archive.Recorder{Writer: archive.NewWriter(f)}
+		handler = rec.Middleware(handler)
+	}
 
 	server := gemini.Server{
 		TLS:     &tls.Config{},
-		Handler: mux,
+		Handler: handler,
 	}
 
 	if *identityCertFile != "" && *identityKeyFile != "" {
@@ -67,7 +107,18 @@ func main() {
 		server.TLS.Certificates = []tls.Certificate{cert}
 	}
 
-	err := server.ListenAndServe()
+	listeners, err := gemini.ListenersFromEnv()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// Under systemd socket activation, serve the passed sockets rather than
+	// binding our own.
+	if len(listeners) > 0 {
+		err = server.ServeAll(listeners...)
+	} else {
+		err = server.ListenAndServe()
+	}
 	if err != nil {
 		panic(err.Error())
 	}
@@ -0,0 +1,41 @@
+package gemini
+
+import "net"
+
+// A ConnState represents the state of a client connection to a Server. It is
+// used by the optional Server.ConnState hook.
+type ConnState int
+
+const (
+	// StateNew is a connection which has just been accepted. The TLS
+	// handshake hasn't happened yet.
+	StateNew ConnState = iota
+
+	// StateActive is a connection which has sent its request. It moves to
+	// StateActive before the request is handled.
+	StateActive
+
+	// StateHijacked is a connection which was taken over by its handler. It
+	// is terminal, so StateClosed is never reported for it.
+	StateHijacked
+
+	// StateClosed is a closed connection. It is terminal.
+	StateClosed
+)
+
+var connStateNames = map[ConnState]string{
+	StateNew:      "new",
+	StateActive:   "active",
+	StateHijacked: "hijacked",
+	StateClosed:   "closed",
+}
+
+func (c ConnState) String() string {
+	return connStateNames[c]
+}
+
+func (s *Server) setState(c net.Conn, state ConnState) {
+	if hook := s.ConnState; hook != nil {
+		hook(c, state)
+	}
+}
@@ -1,11 +1,24 @@
 package gemini
 
-import "context"
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
 
 type contextKey string
 
 const (
-	ctxKeyParams contextKey = "params"
+	ctxKeyParams      contextKey = "params"
+	ctxKeyNamedParams contextKey = "named-params"
+	ctxKeyRemoteAddr  contextKey = "remote-addr"
+	ctxKeyLocalAddr   contextKey = "local-addr"
+	ctxKeyTLS         contextKey = "tls"
+	ctxKeyServer      contextKey = "server"
+	ctxKeyStartTime   contextKey = "start-time"
+	ctxKeyLanguage    contextKey = "language"
+	ctxKeyProfile     contextKey = "profile"
 )
 
 // CtxWithParams overwrites the params stored in the request context. This is
@@ -23,3 +36,81 @@ func CtxParams(ctx context.Context) Params {
 
 	return val.(Params)
 }
+
+// CtxWithParam returns a copy of ctx with the named param set to value. This is
+// generally only useful for internal code and middleware.
+func CtxWithParam(ctx context.Context, name, value string) context.Context {
+	old, _ := ctx.Value(ctxKeyNamedParams).(map[string]string)
+
+	named := make(map[string]string, len(old)+1)
+	for k, v := range old {
+		named[k] = v
+	}
+	named[name] = value
+
+	return context.WithValue(ctx, ctxKeyNamedParams, named)
+}
+
+// CtxParam allows you to extract a named URL param from a request context.
+// Currently only catch-all patterns record named params, so a pattern of
+// "/files/*filepath" will store the rest of the path under "filepath". The
+// legacy "/:rest" catch-all is stored under "rest".
+func CtxParam(ctx context.Context, name string) string {
+	named, _ := ctx.Value(ctxKeyNamedParams).(map[string]string)
+	return named[name]
+}
+
+// CtxRemoteAddr returns the address of the client which made the request, or
+// nil if the context didn't come from a Server.
+func CtxRemoteAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(ctxKeyRemoteAddr).(net.Addr)
+	return addr
+}
+
+// CtxLocalAddr returns the local address the request was accepted on, or nil
+// if the context didn't come from a Server.
+func CtxLocalAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(ctxKeyLocalAddr).(net.Addr)
+	return addr
+}
+
+// CtxTLS returns the state of the TLS connection the request was made on, or
+// nil if the context didn't come from a Server.
+func CtxTLS(ctx context.Context) *tls.ConnectionState {
+	state, _ := ctx.Value(ctxKeyTLS).(*tls.ConnectionState)
+	return state
+}
+
+// CtxServer returns the Server handling the request, or nil if the context
+// didn't come from a Server.
+func CtxServer(ctx context.Context) *Server {
+	srv, _ := ctx.Value(ctxKeyServer).(*Server)
+	return srv
+}
+
+// CtxStartTime returns the time the Server started handling the request, or
+// the zero time if the context didn't come from a Server.
+func CtxStartTime(ctx context.Context) time.Time {
+	start, _ := ctx.Value(ctxKeyStartTime).(time.Time)
+	return start
+}
+
+// CtxLanguage returns the language of the subtree handling the request, as
+// recorded by Languages, or an empty string if there isn't one.
+func CtxLanguage(ctx context.Context) string {
+	lang, _ := ctx.Value(ctxKeyLanguage).(string)
+	return lang
+}
+
+// CtxWithProfile returns a copy of ctx with profile stored in it. This is
+// generally only useful for middleware like Registration.
+func CtxWithProfile(ctx context.Context, profile *Profile) context.Context {
+	return context.WithValue(ctx, ctxKeyProfile, profile)
+}
+
+// CtxProfile returns the profile of the registered client which made the
+// request, or nil if there isn't one. See Registration.
+func CtxProfile(ctx context.Context) *Profile {
+	profile, _ := ctx.Value(ctxKeyProfile).(*Profile)
+	return profile
+}
@@ -0,0 +1,406 @@
+package gemini
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// A DANEMode controls whether the Client validates server certificates against
+// TLSA records, as described by RFC 6698.
+//
+// TLSA records are only trusted if the system resolver marks the answer as
+// authenticated with DNSSEC, so this should only be enabled when the resolver
+// is trusted, such as a validating resolver running on the same host.
+type DANEMode int
+
+const (
+	// DANEOff disables DANE validation. This is the default.
+	DANEOff DANEMode = iota
+
+	// DANEOpportunistic validates certificates against TLSA records if a host
+	// has any, and otherwise falls back to TOFU. Records which can't be looked
+	// up, such as when the resolver fails or doesn't validate DNSSEC, are
+	// treated as missing, so only a mismatch with authenticated records
+	// refuses the connection.
+	DANEOpportunistic
+
+	// DANERequired refuses to connect to hosts without valid TLSA records,
+	// including when they can't be looked up or aren't authenticated.
+	DANERequired
+)
+
+// TLSA record fields, as defined by RFC 6698.
+const (
+	tlsaUsagePKIXTA = 0
+	tlsaUsagePKIXEE = 1
+	tlsaUsageDANETA = 2
+	tlsaUsageDANEEE = 3
+
+	tlsaSelectorCert = 0
+	tlsaSelectorSPKI = 1
+
+	tlsaMatchExact  = 0
+	tlsaMatchSHA256 = 1
+	tlsaMatchSHA512 = 2
+
+	dnsTypeTLSA = 52
+	dnsTypeOPT  = 41
+	dnsClassIN  = 1
+)
+
+type tlsaRecord struct {
+	usage    uint8
+	selector uint8
+	matching uint8
+	data     []byte
+}
+
+// verifyDANE checks the presented certificate chain against the TLSA records
+// for hostname and port, and reports whether it was validated by them. With
+// DANEOpportunistic, it returns false without an error if there are no usable
+// records, so the caller can fall back to TOFU.
+func verifyDANE(ctx context.Context, mode DANEMode, hostname, port string, chain []*x509.Certificate) (bool, error) {
+	// IP literals can't have TLSA records.
+	var records []tlsaRecord
+	var err error
+	if net.ParseIP(hostname) == nil {
+		records, err = lookupTLSA(ctx, "_"+port+"._tcp."+strings.TrimSuffix(hostname, ".")+".")
+	}
+	if err == nil && len(records) == 0 {
+		err = ErrDANENoRecords
+	}
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, ctxErr
+		}
+		if mode == DANEOpportunistic {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if len(chain) == 0 {
+		return false, errors.New("server did not present a certificate")
+	}
+
+	for _, record := range records {
+		if record.matches(hostname, chain) {
+			return true, nil
+		}
+	}
+
+	return false, ErrDANEMismatch
+}
+
+func (t tlsaRecord) matches(hostname string, chain []*x509.Certificate) bool {
+	leaf := chain[0]
+
+	switch t.usage {
+	case tlsaUsageDANEEE:
+		return t.matchesCert(leaf)
+
+	case tlsaUsageDANETA:
+		for _, ca := range chain[1:] {
+			if !t.matchesCert(ca) {
+				continue
+			}
+
+			roots := x509.NewCertPool()
+			roots.AddCert(ca)
+			if verifyChain(hostname, chain, roots) {
+				return true
+			}
+		}
+
+	case tlsaUsagePKIXEE:
+		return t.matchesCert(leaf) && verifyChain(hostname, chain, nil)
+
+	case tlsaUsagePKIXTA:
+		if !verifyChain(hostname, chain, nil) {
+			return false
+		}
+		for _, ca := range chain[1:] {
+			if t.matchesCert(ca) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (t tlsaRecord) matchesCert(cert *x509.Certificate) bool {
+	var data []byte
+	switch t.selector {
+	case tlsaSelectorCert:
+		data = cert.Raw
+	case tlsaSelectorSPKI:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false
+	}
+
+	switch t.matching {
+	case tlsaMatchExact:
+	case tlsaMatchSHA256:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case tlsaMatchSHA512:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return false
+	}
+
+	return bytes.Equal(data, t.data)
+}
+
+// verifyChain does normal PKIX validation of chain. A nil roots uses the
+// system roots.
+func verifyChain(hostname string, chain []*x509.Certificate, roots *x509.CertPool) bool {
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		DNSName:       hostname,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+
+	return err == nil
+}
+
+// lookupTLSA queries the system resolver for TLSA records. The query asks for
+// DNSSEC validation and the answer is rejected unless the resolver sets the
+// authenticated data bit.
+func lookupTLSA(ctx context.Context, name string) ([]tlsaRecord, error) {
+	query, id, err := buildTLSAQuery(name)
+	if err != nil {
+		return nil, err
+	}
+
+	server := tlsaNameserver()
+
+	var dialer net.Dialer
+	resp, err := exchangeDNS(ctx, &dialer, "udp", server, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the answer was truncated, retry over TCP.
+	if len(resp) > 2 && resp[2]&0x02 != 0 {
+		resp, err = exchangeDNS(ctx, &dialer, "tcp", server, query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return parseTLSAResponse(resp, id)
+}
+
+// tlsaNameserver returns the address TLSA queries are sent to. It is a
+// variable so tests can use a fake resolver.
+var tlsaNameserver = systemNameserver
+
+// systemNameserver returns the first nameserver in /etc/resolv.conf, falling
+// back to a local resolver.
+func systemNameserver() string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err == nil {
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+
+	return "127.0.0.1:53"
+}
+
+func exchangeDNS(ctx context.Context, dialer *net.Dialer, network, server string, query []byte) ([]byte, error) {
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(5 * time.Second)
+	}
+	_ = conn.SetDeadline(deadline)
+
+	if network == "tcp" {
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(query)))
+		if _, err := conn.Write(append(length[:], query...)); err != nil {
+			return nil, err
+		}
+
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+
+		resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+		_, err = io.ReadFull(conn, resp)
+		return resp, err
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	resp := make([]byte, 4096)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp[:n], nil
+}
+
+func buildTLSAQuery(name string) ([]byte, uint16, error) {
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	var buf bytes.Buffer
+
+	// Header: recursion desired and authenticated data set, one question and
+	// one additional record for EDNS0.
+	header := []uint16{id, 0x0120, 1, 0, 0, 1}
+	for _, v := range header {
+		_ = binary.Write(&buf, binary.BigEndian, v)
+	}
+
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, 0, fmt.Errorf("invalid DNS name %q", name)
+		}
+		buf.WriteByte(byte(len(label)))
+		buf.WriteString(label)
+	}
+	buf.WriteByte(0)
+	_ = binary.Write(&buf, binary.BigEndian, []uint16{dnsTypeTLSA, dnsClassIN})
+
+	// OPT record with a 4096 byte payload size and the DNSSEC OK bit set.
+	buf.WriteByte(0)
+	_ = binary.Write(&buf, binary.BigEndian, []uint16{dnsTypeOPT, 4096, 0, 0x8000, 0})
+
+	return buf.Bytes(), id, nil
+}
+
+func parseTLSAResponse(msg []byte, id uint16) ([]tlsaRecord, error) {
+	if len(msg) < 12 {
+		return nil, errors.New("short DNS response")
+	}
+
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, errors.New("DNS response ID mismatch")
+	}
+
+	flags := binary.BigEndian.Uint16(msg[2:])
+	rcode := flags & 0x000f
+
+	// NXDOMAIN just means there are no records.
+	if rcode == 3 {
+		return nil, nil
+	}
+	if rcode != 0 {
+		return nil, fmt.Errorf("DNS query failed with rcode %d", rcode)
+	}
+
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	ancount := int(binary.BigEndian.Uint16(msg[6:]))
+
+	if ancount > 0 && flags&0x0020 == 0 {
+		return nil, ErrDANEInsecure
+	}
+
+	offset := 12
+	var err error
+	for i := 0; i < qdcount; i++ {
+		offset, err = skipDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += 4
+	}
+
+	var records []tlsaRecord
+	for i := 0; i < ancount; i++ {
+		offset, err = skipDNSName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		if offset+10 > len(msg) {
+			return nil, errors.New("short DNS response")
+		}
+
+		rrtype := binary.BigEndian.Uint16(msg[offset:])
+		rdlength := int(binary.BigEndian.Uint16(msg[offset+8:]))
+		offset += 10
+
+		if offset+rdlength > len(msg) {
+			return nil, errors.New("short DNS response")
+		}
+		rdata := msg[offset : offset+rdlength]
+		offset += rdlength
+
+		// Other types, such as CNAMEs and signatures, may be mixed in.
+		if rrtype != dnsTypeTLSA || len(rdata) < 3 {
+			continue
+		}
+
+		records = append(records, tlsaRecord{
+			usage:    rdata[0],
+			selector: rdata[1],
+			matching: rdata[2],
+			data:     append([]byte(nil), rdata[3:]...),
+		})
+	}
+
+	return records, nil
+}
+
+// skipDNSName returns the offset just after the name starting at offset,
+// handling compression pointers.
+func skipDNSName(msg []byte, offset int) (int, error) {
+	for {
+		if offset >= len(msg) {
+			return 0, errors.New("short DNS response")
+		}
+
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			return offset + 2, nil
+		default:
+			offset += length + 1
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package gemini
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a self-signed certificate for localhost.
+func newTestCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// fakeTLSA describes the reply of a fake resolver to TLSA queries.
+type fakeTLSA struct {
+	rcode         uint16
+	authenticated bool
+
+	// records are the TLSA records in the answer, as RDATA.
+	records [][]byte
+}
+
+// serveFakeTLSA answers TLSA queries with reply until the test ends, and
+// makes lookupTLSA use it.
+func serveFakeTLSA(t *testing.T, reply fakeTLSA) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if resp := reply.answer(buf[:n]); resp != nil {
+				_, _ = conn.WriteTo(resp, addr)
+			}
+		}
+	}()
+
+	useNameserver(t, conn.LocalAddr().String())
+}
+
+func useNameserver(t *testing.T, addr string) {
+	saved := tlsaNameserver
+	tlsaNameserver = func() string { return addr }
+	t.Cleanup(func() { tlsaNameserver = saved })
+}
+
+func (f fakeTLSA) answer(query []byte) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+
+	questionEnd, err := skipDNSName(query, 12)
+	if err != nil || questionEnd+4 > len(query) {
+		return nil
+	}
+	questionEnd += 4
+
+	flags := uint16(0x8180) | f.rcode
+	if f.authenticated {
+		flags |= 0x0020
+	}
+
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(f.records)))
+	resp = append(resp, query[12:questionEnd]...)
+
+	for _, rdata := range f.records {
+		var rr [12]byte
+		binary.BigEndian.PutUint16(rr[0:], 0xc00c)
+		binary.BigEndian.PutUint16(rr[2:], dnsTypeTLSA)
+		binary.BigEndian.PutUint16(rr[4:], dnsClassIN)
+		binary.BigEndian.PutUint32(rr[6:], 300)
+		binary.BigEndian.PutUint16(rr[10:], uint16(len(rdata)))
+		resp = append(resp, rr[:]...)
+		resp = append(resp, rdata...)
+	}
+
+	return resp
+}
+
+// tlsaFor returns DANE-EE TLSA RDATA matching the SHA-256 of cert's key.
+func tlsaFor(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return append([]byte{tlsaUsageDANEEE, tlsaSelectorSPKI, tlsaMatchSHA256}, sum[:]...)
+}
+
+func TestVerifyDANE(t *testing.T) {
+	cert := newTestCertificate(t).Leaf
+	other := newTestCertificate(t).Leaf
+	chain := []*x509.Certificate{cert}
+
+	tests := []struct {
+		name     string
+		hostname string
+		reply    *fakeTLSA
+
+		// The results for DANEOpportunistic and DANERequired. A nil error
+		// with want false means the Client falls back to TOFU.
+		opportunistic    bool
+		opportunisticErr error
+		required         bool
+		requiredErr      error
+	}{
+		{
+			name:     "matching records",
+			hostname: "localhost",
+			reply:    &fakeTLSA{authenticated: true, records: [][]byte{tlsaFor(cert)}},
+
+			opportunistic: true,
+			required:      true,
+		},
+		{
+			name:     "mismatched records",
+			hostname: "localhost",
+			reply:    &fakeTLSA{authenticated: true, records: [][]byte{tlsaFor(other)}},
+
+			opportunisticErr: ErrDANEMismatch,
+			requiredErr:      ErrDANEMismatch,
+		},
+		{
+			name:        "no records",
+			hostname:    "localhost",
+			reply:       &fakeTLSA{rcode: 3},
+			requiredErr: ErrDANENoRecords,
+		},
+		{
+			name:        "unauthenticated records",
+			hostname:    "localhost",
+			reply:       &fakeTLSA{records: [][]byte{tlsaFor(cert)}},
+			requiredErr: ErrDANEInsecure,
+		},
+		{
+			name:        "server failure",
+			hostname:    "localhost",
+			reply:       &fakeTLSA{rcode: 2},
+			requiredErr: errAny,
+		},
+		{
+			name:        "unreachable resolver",
+			hostname:    "localhost",
+			requiredErr: errAny,
+		},
+		{
+			name:        "IPv6 literal",
+			hostname:    "::1",
+			reply:       &fakeTLSA{authenticated: true, records: [][]byte{tlsaFor(other)}},
+			requiredErr: ErrDANENoRecords,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.reply != nil {
+				serveFakeTLSA(t, *tt.reply)
+			} else {
+				useNameserver(t, closedUDPAddr(t))
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			ok, err := verifyDANE(ctx, DANEOpportunistic, tt.hostname, "1965", chain)
+			checkDANEResult(t, "DANEOpportunistic", ok, err, tt.opportunistic, tt.opportunisticErr)
+
+			ok, err = verifyDANE(ctx, DANERequired, tt.hostname, "1965", chain)
+			checkDANEResult(t, "DANERequired", ok, err, tt.required, tt.requiredErr)
+		})
+	}
+}
+
+// errAny matches any non-nil error in checkDANEResult.
+var errAny = errors.New("any error")
+
+func checkDANEResult(t *testing.T, mode string, ok bool, err error, wantOK bool, wantErr error) {
+	t.Helper()
+
+	switch {
+	case wantErr == errAny && err == nil:
+		t.Errorf("%s: got no error, want one", mode)
+	case wantErr != errAny && !errors.Is(err, wantErr):
+		t.Errorf("%s: got error %v, want %v", mode, err, wantErr)
+	case ok != wantOK:
+		t.Errorf("%s: got validated %v, want %v", mode, ok, wantOK)
+	}
+}
+
+// closedUDPAddr returns a loopback address nothing is listening on.
+func closedUDPAddr(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	return addr
+}
+
+// TestClientDANEFallback checks that a resolver failure only stops requests
+// with DANERequired, and that DANEOpportunistic falls back to TOFU.
+func TestClientDANEFallback(t *testing.T) {
+	serveFakeTLSA(t, fakeTLSA{rcode: 2})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &Server{
+		Handler: HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+			w.WriteStatus(StatusSuccess, "text/plain")
+		}),
+		TLS:      &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}},
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	target := "gemini://localhost:" + port + "/"
+
+	store := &MemoryTOFUStore{}
+	client := &Client{DANE: DANEOpportunistic, TOFU: store}
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatalf("DANEOpportunistic: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if _, ok := store.Lookup("localhost:" + port); !ok {
+		t.Error("DANEOpportunistic didn't fall back to TOFU")
+	}
+
+	client = &Client{DANE: DANERequired, TOFU: &MemoryTOFUStore{}}
+	if resp, err := client.Get(target); err == nil {
+		_ = resp.Body.Close()
+		t.Error("DANERequired connected without TLSA records")
+	}
+}
@@ -0,0 +1,422 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// privateNetworks are the address ranges rejected by DenyPrivateAddresses.
+// NAT64 prefixes are included, as they can be used to reach any IPv4 address,
+// including private ones.
+var privateNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"64:ff9b::/96",
+	"64:ff9b:1::/48",
+	"fc00::/7",
+)
+
+// sixToFourNetwork is the prefix of 6to4 addresses, which embed an IPv4
+// address.
+var sixToFourNetwork = mustParseCIDRs("2002::/16")[0]
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var ret []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err.Error())
+		}
+		ret = append(ret, network)
+	}
+	return ret
+}
+
+// DenyPrivateAddresses is a function suitable for use as Client.AllowHost. It
+// rejects loopback, link-local, multicast, unspecified and private addresses,
+// which makes it harder to abuse server-side fetchers, like proxies or feed
+// aggregators, to reach internal services.
+func DenyPrivateAddresses(host string, ip net.IP) error {
+	if isPrivateAddress(ip) {
+		return fmt.Errorf("gemini: address %s for %s is not allowed", ip, host)
+	}
+
+	return nil
+}
+
+// isPrivateAddress reports whether ip is rejected by DenyPrivateAddresses.
+// IPv4 addresses written as IPv4-mapped or 6to4 IPv6 addresses are checked
+// as the IPv4 address they contain.
+func isPrivateAddress(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	} else if len(ip) == net.IPv6len && sixToFourNetwork.Contains(ip) && isPrivateAddress(ip[2:6]) {
+		return true
+	}
+
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dial opens a TLS connection to the server for r, which is r.Addr if set and
+// the host in the URL otherwise, and verifies it against the Client's TOFU
+// store if one is configured.
+func (c *Client) dial(ctx context.Context, r *Request) (*tls.Conn, error) {
+	hostname, port, err := r.connectAddr()
+	if err != nil {
+		return nil, err
+	}
+
+	// Unfortunately the spec allows/recommends that people not set up
+	// letsencrypt or something similar, so we can't rely on the normal
+	// certificate verification. Instead, the generally accepted method is TOFU
+	// (trust on first use), which is handled below if the Client has a store.
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true,
+		ServerName:         hostname,
+	}
+
+	if c.Identity != nil {
+		config.Certificates = []tls.Certificate{*c.Identity}
+	}
+
+	if r.ServerName != "" {
+		config.ServerName = r.ServerName
+	}
+
+	config.ClientSessionCache = c.sessionCache()
+
+	addr := net.JoinHostPort(hostname, port)
+
+	ips, err := c.resolve(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialParallel(ctx, hostname, port, ips, config)
+	if err != nil {
+		return nil, err
+	}
+
+	state := conn.ConnectionState()
+
+	// Certificates validated with DANE are trusted even if they changed, so
+	// the TOFU store is updated rather than checked.
+	daneVerified := false
+	if c.DANE != DANEOff {
+		daneVerified, err = verifyDANE(ctx, c.DANE, hostname, port, state.PeerCertificates)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if c.TOFU != nil {
+		if len(state.PeerCertificates) == 0 {
+			_ = conn.Close()
+			return nil, errors.New("server did not present a certificate")
+		}
+
+		cert := state.PeerCertificates[0]
+		if daneVerified {
+			err = c.TOFU.Store(addr, KnownHost{Fingerprint: Fingerprint(cert), Expires: cert.NotAfter})
+		} else {
+			err = c.verifyTOFU(addr, cert)
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// sessionCacheLock guards lazily creating Client.sessions, as the zero Client
+// has to be usable.
+var sessionCacheLock sync.Mutex
+
+func (c *Client) sessionCache() tls.ClientSessionCache {
+	sessionCacheLock.Lock()
+	defer sessionCacheLock.Unlock()
+
+	if c.sessions == nil {
+		c.sessions = tls.NewLRUClientSessionCache(0)
+	}
+
+	return c.sessions
+}
+
+// PreDial connects to host ahead of time, resolving it, performing the TLS
+// handshake and storing the session so the next request to host can resume it.
+// host may optionally include a port. Even though Gemini uses a new connection
+// for every request, this trims latency for interactive clients, which can call
+// PreDial when a link is hovered or a page is likely to be visited next.
+//
+// Because no request is sent, servers will generally log an error for the
+// connection.
+func (c *Client) PreDial(ctx context.Context, host string) error {
+	r, err := NewRequest("gemini://" + host + "/")
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.dial(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// With TLS 1.3, session tickets are sent after the handshake, so we need
+	// to attempt a read for them to be processed. This is expected to time
+	// out.
+	_ = conn.SetReadDeadline(time.Now().Add(preDialTicketWait))
+	var buf [1]byte
+	_, _ = conn.Read(buf[:])
+
+	return nil
+}
+
+// preDialTicketWait is how long PreDial waits for session tickets.
+const preDialTicketWait = 100 * time.Millisecond
+
+// resolve looks up the addresses for hostname, dropping any which are rejected
+// by AllowHost. Checking after resolution, rather than on the hostname, means
+// DNS can't be used to sneak past the policy.
+func (c *Client) resolve(ctx context.Context, hostname string) ([]net.IP, error) {
+	ips, err := lookupIPs(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.AllowHost == nil {
+		return ips, nil
+	}
+
+	var allowed []net.IP
+	var firstErr error
+	for _, ip := range ips {
+		if err := c.AllowHost(hostname, ip); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		allowed = append(allowed, ip)
+	}
+
+	if len(allowed) == 0 {
+		if firstErr == nil {
+			firstErr = fmt.Errorf("gemini: no addresses found for %s", hostname)
+		}
+		return nil, firstErr
+	}
+
+	return allowed, nil
+}
+
+// fallbackDelay is how long to wait for the preferred address family before
+// also trying the other one, as recommended by RFC 6555.
+const fallbackDelay = 300 * time.Millisecond
+
+// familyCacheTTL is how long to remember which address family worked for a
+// host.
+const familyCacheTTL = 10 * time.Minute
+
+type familyEntry struct {
+	ipv4    bool
+	expires time.Time
+}
+
+// familyCache remembers which address family last connected successfully for
+// each host, so dual-stack hosts with broken IPv6 only pay the fallback delay
+// once. It is shared between all Clients because it describes the network
+// rather than any particular Client's configuration.
+var familyCache = struct {
+	sync.Mutex
+	entries map[string]familyEntry
+}{entries: make(map[string]familyEntry)}
+
+func preferIPv4(hostname string) bool {
+	familyCache.Lock()
+	defer familyCache.Unlock()
+
+	entry, ok := familyCache.entries[hostname]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(entry.expires) {
+		delete(familyCache.entries, hostname)
+		return false
+	}
+
+	return entry.ipv4
+}
+
+func rememberFamily(hostname string, ipv4 bool) {
+	familyCache.Lock()
+	defer familyCache.Unlock()
+
+	familyCache.entries[hostname] = familyEntry{
+		ipv4:    ipv4,
+		expires: time.Now().Add(familyCacheTTL),
+	}
+}
+
+// dialParallel connects to one of ips, racing the two address families as
+// described by RFC 6555. IPv6 is preferred unless IPv4 was the last family
+// which worked for this host.
+func dialParallel(ctx context.Context, hostname, port string, ips []net.IP, config *tls.Config) (*tls.Conn, error) {
+	var primary, fallback []net.IP
+	primaryIsIPv4 := preferIPv4(hostname)
+	for _, ip := range ips {
+		if (ip.To4() != nil) == primaryIsIPv4 {
+			primary = append(primary, ip)
+		} else {
+			fallback = append(fallback, ip)
+		}
+	}
+
+	if len(primary) == 0 {
+		primary, fallback = fallback, nil
+		primaryIsIPv4 = !primaryIsIPv4
+	}
+
+	if len(fallback) == 0 {
+		conn, err := dialSerial(ctx, primary, port, config)
+		if err == nil {
+			rememberFamily(hostname, primaryIsIPv4)
+		}
+		return conn, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn *tls.Conn
+		err  error
+		ipv4 bool
+	}
+
+	// This is buffered so the dialing goroutines never block, even after
+	// we've stopped listening.
+	results := make(chan result, 2)
+	start := func(ips []net.IP, ipv4 bool) {
+		go func() {
+			conn, err := dialSerial(ctx, ips, port, config)
+			results <- result{conn, err, ipv4}
+		}()
+	}
+
+	start(primary, primaryIsIPv4)
+	pending := 1
+
+	timer := time.NewTimer(fallbackDelay)
+	defer timer.Stop()
+	fallbackStarted := false
+
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				start(fallback, !primaryIsIPv4)
+				pending++
+			}
+
+		case res := <-results:
+			pending--
+
+			if res.err == nil {
+				rememberFamily(hostname, res.ipv4)
+
+				// Any other connection which succeeds after this one would be
+				// leaked, so make sure it gets closed.
+				if pending > 0 {
+					go func() {
+						if late := <-results; late.conn != nil {
+							_ = late.conn.Close()
+						}
+					}()
+				}
+
+				return res.conn, nil
+			}
+
+			if firstErr == nil {
+				firstErr = res.err
+			}
+
+			if !fallbackStarted {
+				fallbackStarted = true
+				start(fallback, !primaryIsIPv4)
+				pending++
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// dialSerial tries each of ips in order, returning the first connection which
+// succeeds.
+func dialSerial(ctx context.Context, ips []net.IP, port string, config *tls.Config) (*tls.Conn, error) {
+	dialer := &tls.Dialer{Config: config}
+
+	var err error
+	for _, ip := range ips {
+		var rawConn net.Conn
+		rawConn, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return rawConn.(*tls.Conn), nil
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, err
+}
+
+// lookupIPs resolves hostname, which may also be an IP literal.
+func lookupIPs(ctx context.Context, hostname string) ([]net.IP, error) {
+	if ip := net.ParseIP(hostname); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,447 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EditableCapsule serves a directory over gemini:// and lets authorized users
+// replace pages in it by uploading them with titan://. Uploads are visible
+// immediately, and the previous version of each file is kept as a backup,
+// which can be listed and restored with HistoryHandler.
+//
+// Uploading to a path ending in a slash replaces its index.gmi, and an empty
+// upload deletes the file. Successful uploads are redirected to the gemini://
+// URL of the page.
+//
+//	capsule := &gemini.EditableCapsule{
+//		Dir:     "/srv/capsule",
+//		Editors: []string{"AB:CD:..."},
+//	}
+//	mux.Handle("/*path", capsule)
+type EditableCapsule struct {
+	// Dir is the directory which is served and edited.
+	Dir string
+
+	// Editors lists the fingerprints, as returned by Fingerprint, of the
+	// client certificates allowed to upload.
+	Editors []string
+
+	// Authorize, if set, is called instead of checking Editors.
+	Authorize func(ctx context.Context, r *Request) bool
+
+	// MaxSize is the largest upload accepted, in bytes. If zero, 1 MiB is
+	// used.
+	MaxSize int64
+
+	// BackupDir is where previous versions of files are kept. If empty, a
+	// directory next to Dir with ".versions" appended is used, so backups
+	// are never served.
+	BackupDir string
+
+	// pending holds restores from HistoryHandler which are waiting to be
+	// confirmed, keyed by restoreKey.
+	mu      sync.Mutex
+	pending map[string]pendingRestore
+}
+
+// restoreConfirmTimeout is how long a restore waits to be confirmed.
+const restoreConfirmTimeout = 5 * time.Minute
+
+type pendingRestore struct {
+	id      string
+	expires time.Time
+}
+
+func (e *EditableCapsule) backupDir() string {
+	if e.BackupDir != "" {
+		return e.BackupDir
+	}
+	return filepath.Clean(e.Dir) + ".versions"
+}
+
+func (e *EditableCapsule) maxSize() int64 {
+	if e.MaxSize > 0 {
+		return e.MaxSize
+	}
+	return 1 << 20
+}
+
+// ServeGemini implements Handler.
+func (e *EditableCapsule) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
+	if r.Titan == nil {
+		FileServer(Dir(e.Dir)).ServeGemini(ctx, w, r)
+		return
+	}
+
+	if !e.checkEditor(ctx, w, r) {
+		return
+	}
+
+	if r.Titan.Size > e.maxSize() {
+		w.WriteStatus(StatusBadRequest, "upload too large")
+		return
+	}
+
+	name := cleanPath(r.URL.Path)
+	if strings.HasSuffix(name, "/") {
+		name += "index.gmi"
+	}
+
+	var err error
+	if r.Titan.Size == 0 {
+		err = e.remove(name)
+	} else {
+		err = e.write(name, r.Body, r.Titan.Size)
+	}
+	if err != nil {
+		w.WriteStatus(StatusTemporaryFailure, "unable to save upload")
+		return
+	}
+
+	target := &url.URL{Scheme: "gemini", Host: r.URL.Host, Path: cleanPath(r.URL.Path)}
+	w.WriteStatus(StatusRedirect, target.String())
+}
+
+// checkEditor reports whether r is from an editor, replying to it if not.
+func (e *EditableCapsule) checkEditor(ctx context.Context, w ResponseWriter, r *Request) bool {
+	if r.Identity == nil && e.Authorize == nil {
+		w.WriteStatus(StatusCertificateRequired, "client certificate required")
+		return false
+	}
+
+	if !e.authorized(ctx, r) {
+		w.WriteStatus(StatusCertificateNotAuthorized, "not allowed to edit")
+		return false
+	}
+
+	return true
+}
+
+func (e *EditableCapsule) authorized(ctx context.Context, r *Request) bool {
+	if e.Authorize != nil {
+		return e.Authorize(ctx, r)
+	}
+
+	fingerprint := Fingerprint(r.Identity)
+	for _, editor := range e.Editors {
+		if strings.EqualFold(editor, fingerprint) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// localPath converts a cleaned URL path into a path under dir.
+func localPath(dir, name string) string {
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+// write replaces name with size bytes from body, backing up the old version.
+func (e *EditableCapsule) write(name string, body io.Reader, size int64) error {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(body, data); err != nil {
+		return err
+	}
+
+	if err := e.backup(name); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(localPath(e.Dir, name), data)
+}
+
+// remove deletes name, backing up the old version.
+func (e *EditableCapsule) remove(name string) error {
+	if err := e.backup(name); err != nil {
+		return err
+	}
+
+	err := os.Remove(localPath(e.Dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// backup copies the current version of name, if there is one, into the
+// backup directory, named after the current time.
+func (e *EditableCapsule) backup(name string) error {
+	data, err := ioutil.ReadFile(localPath(e.Dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	version := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return writeFileAtomic(filepath.Join(localPath(e.backupDir(), name), version), data)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place,
+// so readers never see a partial file.
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), ".upload-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+// A Version is a previous version of a file in an EditableCapsule.
+type Version struct {
+	// ID identifies the version. It is only meaningful to the capsule.
+	ID string
+
+	// Time is when the version was replaced.
+	Time time.Time
+
+	Size int64
+}
+
+// Versions returns the previous versions of the file at the URL path name,
+// newest first.
+func (e *EditableCapsule) Versions(name string) ([]Version, error) {
+	infos, err := ioutil.ReadDir(localPath(e.backupDir(), name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for _, info := range infos {
+		nanos, err := strconv.ParseInt(info.Name(), 10, 64)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		versions = append(versions, Version{
+			ID:   info.Name(),
+			Time: time.Unix(0, nanos),
+			Size: info.Size(),
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Time.After(versions[j].Time)
+	})
+
+	return versions, nil
+}
+
+// ReadVersion returns the content of a previous version of name.
+func (e *EditableCapsule) ReadVersion(name, id string) ([]byte, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, os.ErrNotExist
+	}
+
+	return ioutil.ReadFile(filepath.Join(localPath(e.backupDir(), name), id))
+}
+
+// Restore replaces name with a previous version. The current version is
+// backed up first, so restores can be undone.
+func (e *EditableCapsule) Restore(name, id string) error {
+	data, err := e.ReadVersion(name, id)
+	if err != nil {
+		return err
+	}
+
+	if err := e.backup(name); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(localPath(e.Dir, name), data)
+}
+
+// HistoryHandler returns a Handler which shows the version history of the
+// file at the request path, which generally has a prefix stripped:
+//
+//	mux.Handle("/history/*path", gemini.StripPrefix("/history", capsule.HistoryHandler()))
+//
+// The history page links to each version, with a query of its ID, and to a
+// restore link, with a query of "restore-" followed by its ID. Restoring
+// requires the same authorization as uploading. The restore link only asks
+// for input, and the version is restored once the client answers "yes", so
+// crawlers and clients following links can't change anything.
+func (e *EditableCapsule) HistoryHandler() Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		name := cleanPath(r.URL.Path)
+		if strings.HasSuffix(name, "/") {
+			name += "index.gmi"
+		}
+
+		query, err := url.QueryUnescape(r.URL.RawQuery)
+		if err != nil {
+			w.WriteStatus(StatusBadRequest, "invalid query")
+			return
+		}
+
+		switch {
+		case query == "":
+			e.serveHistory(w, name)
+
+		case strings.HasPrefix(query, "restore-"):
+			if !e.checkEditor(ctx, w, r) {
+				return
+			}
+
+			id := strings.TrimPrefix(query, "restore-")
+			nanos, err := strconv.ParseInt(id, 10, 64)
+			if err == nil {
+				_, err = os.Stat(filepath.Join(localPath(e.backupDir(), name), id))
+			}
+			if err != nil {
+				NotFound(ctx, r, w)
+				return
+			}
+
+			e.setPending(restoreKey(r, name), id)
+
+			replaced := time.Unix(0, nanos).UTC().Format("2006-01-02 15:04:05 MST")
+			w.WriteStatus(StatusInput, fmt.Sprintf("Type yes to restore %s to the version from %s", path.Base(name), replaced))
+
+		case strings.EqualFold(query, "yes"):
+			if !e.checkEditor(ctx, w, r) {
+				return
+			}
+
+			id, ok := e.takePending(restoreKey(r, name))
+			if !ok {
+				w.WriteStatus(StatusBadRequest, "no restore to confirm")
+				return
+			}
+
+			if err := e.Restore(name, id); err != nil {
+				if os.IsNotExist(err) {
+					NotFound(ctx, r, w)
+					return
+				}
+
+				w.WriteStatus(StatusTemporaryFailure, "unable to restore version")
+				return
+			}
+
+			w.WriteStatus(StatusRedirect, "?")
+
+		default:
+			data, err := e.ReadVersion(name, query)
+			if err != nil {
+				NotFound(ctx, r, w)
+				return
+			}
+
+			mimeType := mime.TypeByExtension(path.Ext(name))
+			if mimeType == "" {
+				mimeType = "application/octet-stream"
+			}
+
+			w.WriteStatus(StatusSuccess, mimeType)
+			_, _ = w.Write(data)
+		}
+	})
+}
+
+// restoreKey identifies restores of name by the client which sent r.
+func restoreKey(r *Request, name string) string {
+	client := r.RemoteAddr
+	if r.Identity != nil {
+		client = Fingerprint(r.Identity)
+	} else if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+
+	return client + "\x00" + name
+}
+
+// setPending records a restore of version id waiting to be confirmed,
+// dropping any which have expired.
+func (e *EditableCapsule) setPending(key, id string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	for k, p := range e.pending {
+		if now.After(p.expires) {
+			delete(e.pending, k)
+		}
+	}
+
+	if e.pending == nil {
+		e.pending = make(map[string]pendingRestore)
+	}
+	e.pending[key] = pendingRestore{id: id, expires: now.Add(restoreConfirmTimeout)}
+}
+
+// takePending returns and forgets the restore waiting to be confirmed for
+// key, if it hasn't expired.
+func (e *EditableCapsule) takePending(key string) (string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	p, ok := e.pending[key]
+	delete(e.pending, key)
+
+	if !ok || time.Now().After(p.expires) {
+		return "", false
+	}
+
+	return p.id, true
+}
+
+func (e *EditableCapsule) serveHistory(w ResponseWriter, name string) {
+	versions, err := e.Versions(name)
+	if err != nil {
+		w.WriteStatus(StatusTemporaryFailure, "unable to list versions")
+		return
+	}
+
+	w.WriteStatus(StatusSuccess, "text/gemini")
+	fmt.Fprintf(w, "# History of %s\n\n", name)
+
+	if len(versions) == 0 {
+		fmt.Fprintf(w, "There are no previous versions.\n")
+		return
+	}
+
+	for _, v := range versions {
+		fmt.Fprintf(w, "=> ?%s %s (%d bytes)\n", v.ID, v.Time.UTC().Format("2006-01-02 15:04:05 MST"), v.Size)
+		fmt.Fprintf(w, "=> ?restore-%s Restore this version\n", v.ID)
+	}
+}
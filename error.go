@@ -6,4 +6,68 @@ var (
 	ErrUnknownProtocol = errors.New("unknown protocol")
 	ErrUnknownStatus   = errors.New("unknown status")
 	ErrAbortHandler    = errors.New("aborted handler")
+
+	// ErrServerClosed is returned by the Server's Serve and ListenAndServe
+	// methods after a call to Shutdown or Close.
+	ErrServerClosed = errors.New("server closed")
+
+	// ErrInvalidRequest is wrapped by the errors returned from
+	// ReadRequestStrict when a request line is rejected.
+	ErrInvalidRequest = errors.New("invalid request")
+
+	// ErrRequestTooLong is returned when reading a request whose URL is
+	// longer than the limit, which is MaxURLLength unless the Server sets
+	// MaxURLLength.
+	ErrRequestTooLong = errors.New("request URL too long")
+
+	// ErrHijacked is returned when using a ResponseWriter whose connection
+	// has been hijacked. ErrNotHijackable is returned by Hijack for
+	// ResponseWriters which don't support it, and ErrHijackBeforeStatus
+	// when it is called before the status has been written.
+	ErrHijacked           = errors.New("connection has been hijacked")
+	ErrNotHijackable      = errors.New("response writer cannot be hijacked")
+	ErrHijackBeforeStatus = errors.New("hijack before status was written")
+
+	// ErrInvalidRedirect and ErrOpenRedirect are wrapped by the errors
+	// returned from RedirectPolicy.Check. ErrInvalidRedirect is also wrapped
+	// by the errors the Client returns for redirects it can't follow.
+	ErrInvalidRedirect = errors.New("invalid redirect")
+	ErrOpenRedirect    = errors.New("open redirect")
+
+	// ErrInvalidProxyHeader is wrapped by the errors logged when a Server
+	// with ProxyProtocol set reads a connection without a valid PROXY
+	// protocol header.
+	ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+	// ErrInvalidURL is wrapped by the errors returned from ParseGeminiURL and
+	// NormalizeURL.
+	ErrInvalidURL = errors.New("invalid gemini URL")
+
+	// ErrCertificateChanged is returned by the Client when a server presents a
+	// different certificate than the one recorded in its TOFUStore, before the
+	// recorded one has expired.
+	ErrCertificateChanged = errors.New("server certificate changed")
+
+	// These errors are returned by the Client when DANE validation is enabled.
+	// ErrDANEInsecure means the resolver did not authenticate the TLSA
+	// records with DNSSEC.
+	ErrDANEMismatch  = errors.New("server certificate does not match TLSA records")
+	ErrDANENoRecords = errors.New("no TLSA records for host")
+	ErrDANEInsecure  = errors.New("TLSA records are not authenticated")
+
+	// ErrDisallowedByRobots is returned by a Client using Robots for
+	// requests the host's robots.txt doesn't allow.
+	ErrDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+	// ErrBodyTooLarge and ErrRelayTimeout are returned by RelayBody when the
+	// upstream body exceeds the configured limits.
+	ErrBodyTooLarge = errors.New("body too large")
+	ErrRelayTimeout = errors.New("relay timed out")
+
+	// These errors are returned when building, writing or reading a Response
+	// which wouldn't be valid on the wire.
+	ErrInvalidStatus  = errors.New("invalid status")
+	ErrInvalidMeta    = errors.New("invalid meta")
+	ErrMetaTooLong    = errors.New("meta too long")
+	ErrBodyNotAllowed = errors.New("body not allowed for status")
 )
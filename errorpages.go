@@ -0,0 +1,131 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// ErrorPages is a middleware which renders the meta of error responses from
+// templates, so every part of a capsule words its errors the same way. Gemini
+// error responses have no body, so each template produces the single line
+// which is sent as the meta.
+//
+//	pages := &gemini.ErrorPages{Templates: map[int]*template.Template{
+//		gemini.StatusNotFound: template.Must(template.New("51").Parse(
+//			"Nothing at {{.Path}}, try the index at gemini://{{.Host}}/")),
+//	}}
+//	server := &gemini.Server{Handler: mux, ErrorPages: pages}
+//
+// Set on a Server, the pages also cover requests which no handler replied
+// to. Requests which the Server rejects before they are read, such as
+// malformed ones, keep their fixed meta. For only part of a ServeMux, pass
+// Middleware to Router.Use.
+type ErrorPages struct {
+	// Templates are the templates for each status. They are executed with an
+	// ErrorPage.
+	Templates map[int]*template.Template
+
+	// Default, if set, is used for failure statuses, 40 and above, which
+	// have no template of their own.
+	Default *template.Template
+}
+
+// ErrorPage is the data the ErrorPages templates are executed with.
+type ErrorPage struct {
+	// Status and Meta are what the handler replied with.
+	Status int
+	Meta   string
+
+	// URL is the requested URL, and Host and Path are parts of it.
+	URL  *url.URL
+	Host string
+	Path string
+
+	Request *Request
+}
+
+// Middleware returns next wrapped with the error pages. It can be passed to
+// Router.Use.
+func (p *ErrorPages) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		next.ServeGemini(ctx, &errorPageWriter{ResponseWriter: w, pages: p, r: r}, r)
+	})
+}
+
+func (p *ErrorPages) template(status int) *template.Template {
+	if t, ok := p.Templates[status]; ok {
+		return t
+	}
+
+	if status >= StatusTemporaryFailure {
+		return p.Default
+	}
+
+	return nil
+}
+
+// render returns the meta for a response with the given status, or meta
+// itself if there is no template or it fails.
+func (p *ErrorPages) render(r *Request, status int, meta string) string {
+	t := p.template(status)
+	if t == nil {
+		return meta
+	}
+
+	data := &ErrorPage{
+		Status:  status,
+		Meta:    meta,
+		URL:     r.URL,
+		Host:    r.URL.Hostname(),
+		Path:    r.URL.Path,
+		Request: r,
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return meta
+	}
+
+	return cleanMeta(b.String())
+}
+
+// cleanMeta makes rendered text usable as a meta, by joining its lines and
+// cutting it to MaxMetaLength.
+func cleanMeta(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= MaxMetaLength {
+		return s
+	}
+
+	s = s[:MaxMetaLength]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+
+	return s
+}
+
+type errorPageWriter struct {
+	ResponseWriter
+
+	pages *ErrorPages
+	r     *Request
+}
+
+func (w *errorPageWriter) WriteStatus(statusCode int, meta string) {
+	w.ResponseWriter.WriteStatus(statusCode, w.pages.render(w.r, statusCode, meta))
+}
+
+// Flush implements Flusher.
+func (w *errorPageWriter) Flush() error {
+	return Flush(w.ResponseWriter)
+}
+
+// Hijack implements Hijacker.
+func (w *errorPageWriter) Hijack() (*tls.Conn, error) {
+	return Hijack(w.ResponseWriter)
+}
@@ -1,9 +1,13 @@
 package gemini
 
 import (
+	"bytes"
+	"container/list"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"io"
+	"io/ioutil"
 	"mime"
 	"net/url"
 	"os"
@@ -11,6 +15,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 )
 
 // A Dir implements FileSystem using the native file system restricted to a
@@ -74,6 +79,11 @@ type FileSystem interface {
 
 type fileHandler struct {
 	root FileSystem
+
+	// cache holds rendered directory listings and converted documents. It is
+	// nil for a plain FileServer.
+	cache      *fileCache
+	converters map[string]Converter
 }
 
 // FileServer returns a handler that serves HTTP requests with the contents of
@@ -86,10 +96,116 @@ type fileHandler struct {
 // Once go 1.16 is released, this will most likely be dropped in favor of the
 // built-in FS interfaces.
 func FileServer(root FileSystem) Handler {
-	return &fileHandler{root}
+	return &fileHandler{root: root}
+}
+
+// CachingFileServer is like FileServer, but it keeps the directory listings
+// it renders, keyed by a hash of the directory's entries, and only renders them
+// again once the entries change. Hashing the entries rather than relying on
+// modification times means this works with any FileSystem, including MemFS
+// and archives, whose directories may not have meaningful modification times.
+//
+// Rendered listings and documents are kept up to a total of 16MB, after which
+// the least recently used are dropped.
+func CachingFileServer(root FileSystem) Handler {
+	return ConvertingFileServer(root, nil)
+}
+
+// A Converter renders a file as a different document for
+// ConvertingFileServer, such as Markdown as gemtext. It reads the file named
+// name from src, writes the document to dst and returns the meta to serve it
+// with. If meta is empty, text/gemini is used.
+type Converter func(name string, src io.Reader, dst io.Writer) (meta string, err error)
+
+// ConvertingFileServer is like CachingFileServer, but files with an extension
+// in converters, such as ".md", are served as the document their Converter
+// renders rather than as they are. Files are still read for every request, but
+// they are only converted again once the hash of their content changes.
+//
+//	mux.Handle("/*path", gemini.ConvertingFileServer(gemini.Dir("/srv/capsule"), map[string]gemini.Converter{
+//		".md": markdownToGemtext,
+//	}))
+func ConvertingFileServer(root FileSystem, converters map[string]Converter) Handler {
+	return &fileHandler{
+		root:       root,
+		cache:      newFileCache(),
+		converters: converters,
+	}
+}
+
+// maxFileCacheBytes is the total size of the bodies a fileCache keeps.
+const maxFileCacheBytes = 16 << 20
+
+// cachedFile is a rendered directory listing or converted document, along with
+// the hash of what it was rendered from.
+type cachedFile struct {
+	name string
+	sum  [sha256.Size]byte
+	meta string
+	body []byte
+}
+
+// fileCache stores rendered files keyed by path, evicting the least recently
+// used once they add up to maxFileCacheBytes. A nil *fileCache stores nothing.
+type fileCache struct {
+	lock    sync.Mutex
+	size    int
+	lru     list.List
+	entries map[string]*list.Element
+}
+
+func newFileCache() *fileCache {
+	return &fileCache{entries: make(map[string]*list.Element)}
+}
+
+// get returns the entry for name, if there is one and it was rendered from
+// content with the hash sum.
+func (c *fileCache) get(name string, sum [sha256.Size]byte) *cachedFile {
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem := c.entries[name]
+	if elem == nil || elem.Value.(*cachedFile).sum != sum {
+		return nil
+	}
+
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedFile)
+}
+
+// put stores meta and body for name, which were rendered from content with
+// the hash sum.
+func (c *fileCache) put(name string, sum [sha256.Size]byte, meta string, body []byte) {
+	if c == nil || len(body) > maxFileCacheBytes {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem := c.entries[name]; elem != nil {
+		c.remove(elem)
+	}
+
+	c.entries[name] = c.lru.PushFront(&cachedFile{name: name, sum: sum, meta: meta, body: body})
+	c.size += len(body)
+
+	for c.size > maxFileCacheBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
 }
 
-func (f *fileHandler) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
+func (c *fileCache) remove(elem *list.Element) {
+	cached := c.lru.Remove(elem).(*cachedFile)
+	delete(c.entries, cached.name)
+	c.size -= len(cached.body)
+}
+
+func (h *fileHandler) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
 	upath := r.URL.Path
 
 	if !strings.HasPrefix(upath, "/") {
@@ -97,14 +213,14 @@ func (f *fileHandler) ServeGemini(ctx context.Context, w ResponseWriter, r *Requ
 		r.URL.Path = upath
 	}
 
-	serveFile(ctx, w, r, f.root, cleanPath(upath))
+	h.serveFile(ctx, w, r, cleanPath(upath))
 }
 
 // name is '/'-separated, not filepath.Separator.
-func serveFile(ctx context.Context, w ResponseWriter, r *Request, fs FileSystem, name string) {
+func (h *fileHandler) serveFile(ctx context.Context, w ResponseWriter, r *Request, name string) {
 	const indexPage = "/index.gmi"
 
-	f, err := fs.Open(name)
+	f, err := h.root.Open(name)
 	if err != nil {
 		w.WriteStatus(StatusPermanentFailure, err.Error())
 		return
@@ -135,7 +251,7 @@ func serveFile(ctx context.Context, w ResponseWriter, r *Request, fs FileSystem,
 	if d.IsDir() {
 		// use contents of index.gmi for directory, if present
 		index := strings.TrimSuffix(name, "/") + indexPage
-		ff, err := fs.Open(index)
+		ff, err := h.root.Open(index)
 		if err == nil {
 			dd, err := ff.Stat()
 			if err == nil {
@@ -165,15 +281,21 @@ func serveFile(ctx context.Context, w ResponseWriter, r *Request, fs FileSystem,
 			return entries[i].IsDir()
 		})
 
-		for _, entry := range entries {
-			w.Write([]byte("=> "))
-			w.Write([]byte(url.PathEscape(entry.Name())))
-			if entry.IsDir() {
-				w.Write([]byte("/"))
-			}
-			w.Write([]byte("\n"))
+		sum := listingHash(entries)
+		if cached := h.cache.get(name, sum); cached != nil {
+			_, _ = w.Write(cached.body)
+			return
 		}
 
+		body := dirListing(entries)
+		h.cache.put(name, sum, "", body)
+
+		_, _ = w.Write(body)
+		return
+	}
+
+	if convert := h.converters[path.Ext(d.Name())]; convert != nil {
+		h.serveConverted(ctx, w, name, f, convert)
 		return
 	}
 
@@ -183,5 +305,68 @@ func serveFile(ctx context.Context, w ResponseWriter, r *Request, fs FileSystem,
 	}
 
 	w.WriteStatus(StatusSuccess, mimeType)
-	_, _ = io.Copy(w, f)
+	_, _ = copyBuffer(w, f)
+}
+
+// serveConverted serves the document convert renders from f, using the cached
+// one if the content of f hasn't changed.
+func (h *fileHandler) serveConverted(ctx context.Context, w ResponseWriter, name string, f File, convert Converter) {
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		w.WriteStatus(StatusPermanentFailure, err.Error())
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	cached := h.cache.get(name, sum)
+	if cached == nil {
+		var buf bytes.Buffer
+		meta, err := convert(name, bytes.NewReader(data), &buf)
+		if err != nil {
+			ctxLogf(ctx, "gemini: converting %s: %v", name, err)
+			w.WriteStatus(StatusTemporaryFailure, "conversion failed")
+			return
+		}
+
+		if meta == "" {
+			meta = "text/gemini"
+		}
+
+		cached = &cachedFile{meta: meta, body: buf.Bytes()}
+		h.cache.put(name, sum, meta, cached.body)
+	}
+
+	w.WriteStatus(StatusSuccess, cached.meta)
+	_, _ = w.Write(cached.body)
+}
+
+// listingHash returns a hash of everything dirListing renders from entries.
+func listingHash(entries []os.FileInfo) [sha256.Size]byte {
+	h := sha256.New()
+	for _, entry := range entries {
+		io.WriteString(h, entry.Name())
+		if entry.IsDir() {
+			h.Write([]byte{'/', 0})
+		} else {
+			h.Write([]byte{0})
+		}
+	}
+
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// dirListing renders sorted directory entries as gemtext links.
+func dirListing(entries []os.FileInfo) []byte {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		buf.WriteString("=> ")
+		buf.WriteString(url.PathEscape(entry.Name()))
+		if entry.IsDir() {
+			buf.WriteString("/")
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
 }
@@ -0,0 +1,85 @@
+package gemini
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+)
+
+// Footer is a middleware which appends a footer to text/gemini responses with
+// a link back to the capsule index, when the page was generated and which
+// server generated it. Other responses, including binary files, are left
+// alone.
+//
+// It is generally used on the parts of a ServeMux which should have a footer:
+//
+//	footer := &gemini.Footer{Server: "example.com"}
+//	mux.Route("/gemlog", func(r gemini.Router) {
+//		r.Use(footer.Middleware)
+//	})
+type Footer struct {
+	// Index is the link back to the capsule index. If empty, "/" is used.
+	Index string
+
+	// IndexLabel is the label of the index link. If empty, "Back to the
+	// index" is used.
+	IndexLabel string
+
+	// Server identifies the server. If empty, the host of the request is
+	// used.
+	Server string
+
+	// TimeFormat is the layout used for the generation time. If empty,
+	// "2006-01-02 15:04 MST" is used. Times are always in UTC.
+	TimeFormat string
+
+	// Skip, if set, is called for each request, and no footer is added if it
+	// returns true. This can be used to disable the footer for individual
+	// routes.
+	Skip func(r *Request) bool
+}
+
+// Middleware returns next wrapped with the footer.
+func (f *Footer) Middleware(next Handler) Handler {
+	return Transform(func(ctx context.Context, r *Request, meta string, body io.Writer) io.WriteCloser {
+		if !isGemtextMeta(meta) || (f.Skip != nil && f.Skip(r)) {
+			return nil
+		}
+
+		return &wrapWriter{w: body, footer: f.text(r)}
+	})(next)
+}
+
+func (f *Footer) text(r *Request) string {
+	index := f.Index
+	if index == "" {
+		index = "/"
+	}
+
+	label := f.IndexLabel
+	if label == "" {
+		label = "Back to the index"
+	}
+
+	server := f.Server
+	if server == "" {
+		server = r.URL.Hostname()
+	}
+
+	layout := f.TimeFormat
+	if layout == "" {
+		layout = "2006-01-02 15:04 MST"
+	}
+
+	var b strings.Builder
+	b.WriteString("\n")
+	b.WriteString("=> " + index + " " + label + "\n")
+	b.WriteString("Generated " + time.Now().UTC().Format(layout))
+	if server != "" {
+		b.WriteString(" by " + server)
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
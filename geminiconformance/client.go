@@ -0,0 +1,317 @@
+package geminiconformance
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/gemini.v0"
+	"gopkg.in/gemini.v0/geminitest"
+)
+
+// A DoFunc is the client under test. It requests rawURL and returns the
+// final response, after following any redirects, with its body unread.
+type DoFunc func(ctx context.Context, rawURL string) (*gemini.Response, error)
+
+// GeminiClient adapts a gemini.Client to a DoFunc. The Client must accept
+// self-signed certificates.
+func GeminiClient(c *gemini.Client) DoFunc {
+	return c.GetContext
+}
+
+// A ClientCase is a set of canned raw responses and a check of how the
+// client handles them.
+type ClientCase struct {
+	Name string
+
+	// Responses maps paths to the raw bytes sent in reply to requests for
+	// them. A path with a query only matches requests with exactly that
+	// query. The client is pointed at Start.
+	Responses map[string]string
+	Start     string
+
+	// Check is given the client's response, its body and its error.
+	Check func(resp *gemini.Response, body []byte, err error) error
+}
+
+// ClientCases are the checks run by TestClient. Their paths don't overlap,
+// so they can all be served at once.
+var ClientCases = []ClientCase{
+	{
+		Name:      "success",
+		Responses: map[string]string{"/success": "20 text/gemini\r\nhello\n"},
+		Start:     "/success",
+		Check:     success("hello\n"),
+	},
+	{
+		Name:      "empty meta",
+		Responses: map[string]string{"/empty-meta": "20 \r\nhello\n"},
+		Start:     "/empty-meta",
+		Check:     success("hello\n"),
+	},
+	{
+		Name:      "unknown status handled by first digit",
+		Responses: map[string]string{"/status-25": "25 text/gemini\r\nhello\n"},
+		Start:     "/status-25",
+		Check:     success("hello\n"),
+	},
+	{
+		Name:      "input is returned",
+		Responses: map[string]string{"/input": "10 Name?\r\n"},
+		Start:     "/input",
+		Check:     returned(gemini.StatusInput),
+	},
+	{
+		Name:      "certificate required is returned",
+		Responses: map[string]string{"/cert": "60 certificate required\r\n"},
+		Start:     "/cert",
+		Check:     returned(gemini.StatusCertificateRequired),
+	},
+	{
+		Name: "relative redirect is followed",
+		Responses: map[string]string{
+			"/redirect/start":  "31 target\r\n",
+			"/redirect/target": "20 text/gemini\r\nreached\n",
+		},
+		Start: "/redirect/start",
+		Check: success("reached\n"),
+	},
+	{
+		Name: "relative redirect with a query",
+		Responses: map[string]string{
+			"/query/start":          "31 target?a=1&b=2\r\n",
+			"/query/target?a=1&b=2": "20 text/gemini\r\nreached\n",
+		},
+		Start: "/query/start",
+		Check: success("reached\n"),
+	},
+	{
+		Name: "redirect to a parent path",
+		Responses: map[string]string{
+			"/parent/a/b/start": "31 ../../target\r\n",
+			"/parent/target":    "20 text/gemini\r\nreached\n",
+		},
+		Start: "/parent/a/b/start",
+		Check: success("reached\n"),
+	},
+	{
+		Name: "redirect above the root stops at the root",
+		Responses: map[string]string{
+			"/above-root/start":  "31 ../../../above-root-target\r\n",
+			"/above-root-target": "20 text/gemini\r\nreached\n",
+		},
+		Start: "/above-root/start",
+		Check: success("reached\n"),
+	},
+	{
+		Name: "redirect which only changes the query",
+		Responses: map[string]string{
+			"/query-only":        "31 ?page=2\r\n",
+			"/query-only?page=2": "20 text/gemini\r\nreached\n",
+		},
+		Start: "/query-only",
+		Check: success("reached\n"),
+	},
+	{
+		Name: "whitespace around a redirect is ignored",
+		Responses: map[string]string{
+			"/whitespace/start":  "31  target \t\r\n",
+			"/whitespace/target": "20 text/gemini\r\nreached\n",
+		},
+		Start: "/whitespace/start",
+		Check: success("reached\n"),
+	},
+	{
+		Name: "fragment of a redirect is not requested",
+		Responses: map[string]string{
+			"/fragment/start":  "31 target#section\r\n",
+			"/fragment/target": "20 text/gemini\r\nreached\n",
+		},
+		Start: "/fragment/start",
+		Check: success("reached\n"),
+	},
+	{
+		Name:      "empty redirect is an error",
+		Responses: map[string]string{"/empty-redirect": "31 \r\n"},
+		Start:     "/empty-redirect",
+		Check:     failed,
+	},
+	{
+		Name:      "redirect loop is stopped",
+		Responses: map[string]string{"/loop": "30 /loop\r\n"},
+		Start:     "/loop",
+		Check:     failed,
+	},
+	{
+		Name:      "header without CR is rejected",
+		Responses: map[string]string{"/no-cr": "20 text/gemini\nhello\n"},
+		Start:     "/no-cr",
+		Check:     failed,
+	},
+	{
+		Name:      "header without space is rejected",
+		Responses: map[string]string{"/no-space": "20\r\n"},
+		Start:     "/no-space",
+		Check:     failed,
+	},
+	{
+		Name:      "one digit status is rejected",
+		Responses: map[string]string{"/one-digit": "2 text/gemini\r\n"},
+		Start:     "/one-digit",
+		Check:     failed,
+	},
+	{
+		Name:      "status above 69 is rejected",
+		Responses: map[string]string{"/status-70": "70 text/gemini\r\n"},
+		Start:     "/status-70",
+		Check:     failed,
+	},
+	{
+		Name:      "meta over 1024 bytes is rejected",
+		Responses: map[string]string{"/long-meta": "20 " + strings.Repeat("a", 1025) + "\r\n"},
+		Start:     "/long-meta",
+		Check:     failed,
+	},
+	{
+		Name:      "closing without a response is an error",
+		Responses: map[string]string{"/empty": ""},
+		Start:     "/empty",
+		Check:     failed,
+	},
+}
+
+func success(body string) func(*gemini.Response, []byte, error) error {
+	return func(resp *gemini.Response, got []byte, err error) error {
+		if err != nil {
+			return err
+		}
+		if !resp.IsSuccess() {
+			return fmt.Errorf("got %d %s", resp.Status, resp.Meta)
+		}
+		if string(got) != body {
+			return fmt.Errorf("got body %q, want %q", got, body)
+		}
+		return nil
+	}
+}
+
+func returned(status int) func(*gemini.Response, []byte, error) error {
+	return func(resp *gemini.Response, body []byte, err error) error {
+		if err != nil {
+			return err
+		}
+		if resp.Status != status {
+			return fmt.Errorf("got %d %s, want %d", resp.Status, resp.Meta, status)
+		}
+		return nil
+	}
+}
+
+func failed(resp *gemini.Response, body []byte, err error) error {
+	if err == nil {
+		return fmt.Errorf("got %d %s, want an error", resp.Status, resp.Meta)
+	}
+	return nil
+}
+
+// TestClient serves ClientCases on the loopback interface and runs do
+// against each of them.
+func TestClient(t *testing.T, do DoFunc) {
+	t.Helper()
+
+	cert, err := geminitest.NewCertificate("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go ServeClientCases(l, cert)
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	base := "gemini://localhost:" + port
+
+	for _, c := range ClientCases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			resp, err := do(ctx, base+c.Start)
+
+			var body []byte
+			if resp != nil && resp.Body != nil {
+				body, _ = ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+
+			if err := c.Check(resp, body, err); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// ServeClientCases accepts connections on l and replies to requests for the
+// paths in ClientCases with their raw responses, so any client can be
+// pointed at them. It returns when l is closed.
+func ServeClientCases(l net.Listener, cert tls.Certificate) error {
+	responses := make(map[string]string)
+	for _, c := range ClientCases {
+		for path, raw := range c.Responses {
+			responses[path] = raw
+		}
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		go serveClientCase(tls.Server(conn, config), responses)
+	}
+}
+
+func serveClientCase(conn net.Conn, responses map[string]string) {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	u, err := url.Parse(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		return
+	}
+
+	key := u.Path
+	if u.RawQuery != "" {
+		key += "?" + u.RawQuery
+	}
+
+	// Requests which contain anything the case didn't expect, such as a
+	// fragment, don't match.
+	raw, ok := responses[key]
+	if !ok || u.Fragment != "" {
+		raw = "51 no such case\r\n"
+	}
+
+	_, _ = conn.Write([]byte(raw))
+}
@@ -0,0 +1,14 @@
+// Package geminiconformance checks clients and servers against version
+// 0.16 of the Gemini spec. The checks are table driven, so they can be run
+// from tests, against this package's Client and Server or against other
+// software over the network:
+//
+//	func TestConformance(t *testing.T) {
+//		geminiconformance.TestHandler(t, mux)
+//		geminiconformance.TestClient(t, geminiconformance.GeminiClient(&gemini.Client{}))
+//	}
+//
+// To check a server running elsewhere, use TestServer with its address. To
+// check another client, either wrap it in a DoFunc or serve the cases with
+// ServeClientCases and point the client at them.
+package geminiconformance
@@ -0,0 +1,59 @@
+package geminiconformance
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+
+	"gopkg.in/gemini.v0"
+	"gopkg.in/gemini.v0/geminitest"
+)
+
+func hello() gemini.Handler {
+	return gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		w.WriteStatus(gemini.StatusSuccess, "text/gemini")
+		_, _ = w.Write([]byte("# Hello\n"))
+	})
+}
+
+func TestHostMux(t *testing.T) {
+	hosts := gemini.NewHostMux()
+	hosts.Handle("localhost", hello())
+
+	TestHandler(t, hosts)
+}
+
+// TestServerAllowedHosts checks a Server which only relies on AllowedHosts
+// to refuse requests for other hosts.
+func TestServerAllowedHosts(t *testing.T) {
+	cert, err := geminitest.NewCertificate("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	host := "localhost:" + port
+
+	server := &gemini.Server{
+		Handler:      hello(),
+		TLS:          &tls.Config{Certificates: []tls.Certificate{cert}},
+		AllowedHosts: []string{host},
+		ErrorLog:     log.New(ioutil.Discard, "", 0),
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	TestServer(t, l.Addr().String(), host)
+}
+
+func TestGeminiClient(t *testing.T) {
+	TestClient(t, GeminiClient(&gemini.Client{}))
+}
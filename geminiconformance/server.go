@@ -0,0 +1,280 @@
+package geminiconformance
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/gemini.v0"
+	"gopkg.in/gemini.v0/geminitest"
+)
+
+// A ServerResult is what a server sent in reply to a ServerCase.
+type ServerResult struct {
+	// Raw is everything the server sent before closing the connection.
+	Raw []byte
+
+	// Status, Meta and Body are parsed from Raw. HasHeader is false if Raw
+	// doesn't start with a CRLF terminated line.
+	HasHeader bool
+	Status    int
+	Meta      string
+	Body      []byte
+
+	TLS tls.ConnectionState
+}
+
+// A ServerCase is a request sent to a server, along with a check of the
+// response.
+type ServerCase struct {
+	Name string
+
+	// Request is the raw request. "{host}" is replaced with the host the
+	// server is being tested as.
+	Request string
+
+	Check func(res *ServerResult) error
+}
+
+// ServerCases are the checks run by TestServer.
+var ServerCases = []ServerCase{
+	{
+		Name:    "valid request gets a valid header",
+		Request: "gemini://{host}/\r\n",
+		Check:   validHeader,
+	},
+	{
+		Name:    "TLS 1.2 or newer",
+		Request: "gemini://{host}/\r\n",
+		Check: func(res *ServerResult) error {
+			if res.TLS.Version < tls.VersionTLS12 {
+				return fmt.Errorf("negotiated TLS version %#x", res.TLS.Version)
+			}
+			return nil
+		},
+	},
+	{
+		Name:    "URL of 1024 bytes is allowed",
+		Request: "gemini://{host}/{pad 1024}\r\n",
+		Check: func(res *ServerResult) error {
+			if err := validHeader(res); err != nil {
+				return err
+			}
+			if res.Status == gemini.StatusBadRequest {
+				return fmt.Errorf("got %d %s", res.Status, res.Meta)
+			}
+			return nil
+		},
+	},
+	{
+		Name:    "URL over 1024 bytes is rejected",
+		Request: "gemini://{host}/{pad 1025}\r\n",
+		Check:   status(gemini.StatusBadRequest),
+	},
+	{
+		Name:    "request without CR is rejected",
+		Request: "gemini://{host}/\n",
+		Check:   rejected,
+	},
+	{
+		Name:    "empty request is rejected",
+		Request: "\r\n",
+		Check:   rejected,
+	},
+	{
+		Name:    "relative URL is rejected",
+		Request: "/\r\n",
+		Check:   status(gemini.StatusBadRequest),
+	},
+	{
+		Name:    "userinfo is rejected",
+		Request: "gemini://user@{host}/\r\n",
+		Check:   status(gemini.StatusBadRequest),
+	},
+	{
+		Name:    "fragment is rejected",
+		Request: "gemini://{host}/#fragment\r\n",
+		Check:   status(gemini.StatusBadRequest),
+	},
+	{
+		Name:    "other hosts are refused",
+		Request: "gemini://conformance.invalid/\r\n",
+		Check:   status(gemini.StatusProxyRefusedRequest),
+	},
+	{
+		Name:    "other schemes are refused",
+		Request: "https://{host}/\r\n",
+		Check:   status(gemini.StatusProxyRefusedRequest, gemini.StatusBadRequest),
+	},
+}
+
+// validHeader checks the header is well formed, that only success responses
+// have a body and that redirects point at a URL.
+func validHeader(res *ServerResult) error {
+	if !res.HasHeader {
+		return fmt.Errorf("no CRLF terminated header in %q", truncate(res.Raw))
+	}
+
+	if res.Status < gemini.StatusInput || res.Status > 69 {
+		return fmt.Errorf("invalid status %d", res.Status)
+	}
+
+	if len(res.Meta) > gemini.MaxMetaLength {
+		return fmt.Errorf("meta is %d bytes", len(res.Meta))
+	}
+
+	success := res.Status >= gemini.StatusSuccess && res.Status < gemini.StatusRedirect
+	if !success && len(res.Body) > 0 {
+		return fmt.Errorf("status %d has a %d byte body", res.Status, len(res.Body))
+	}
+
+	if res.Status >= gemini.StatusRedirect && res.Status < gemini.StatusTemporaryFailure {
+		if _, err := url.Parse(res.Meta); err != nil || res.Meta == "" {
+			return fmt.Errorf("redirect to invalid URL %q", res.Meta)
+		}
+	}
+
+	return nil
+}
+
+// status checks for a valid header with one of the given statuses.
+func status(want ...int) func(*ServerResult) error {
+	return func(res *ServerResult) error {
+		if err := validHeader(res); err != nil {
+			return err
+		}
+
+		for _, s := range want {
+			if res.Status == s {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("got %d %s, want %v", res.Status, res.Meta, want)
+	}
+}
+
+// rejected allows either a bad request status or closing the connection
+// without a response.
+func rejected(res *ServerResult) error {
+	if len(res.Raw) == 0 {
+		return nil
+	}
+	return status(gemini.StatusBadRequest)(res)
+}
+
+func truncate(b []byte) []byte {
+	if len(b) > 64 {
+		return b[:64]
+	}
+	return b
+}
+
+// TestServer runs ServerCases against the server listening on addr, as the
+// host in host, which is the host part of the URLs it serves, like
+// "example.com" or "localhost:1966". The server's certificate isn't
+// verified.
+func TestServer(t *testing.T, addr, host string) {
+	t.Helper()
+
+	for _, c := range ServerCases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			res, err := RunServerCase(addr, host, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Check(res); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestHandler serves h from a Server on the loopback interface and runs
+// TestServer against it. Other hosts are expected to be refused, so h is
+// generally a HostMux or otherwise checks the host.
+func TestHandler(t *testing.T, h gemini.Handler) {
+	t.Helper()
+
+	srv := geminitest.NewServer(h)
+	defer srv.Close()
+
+	_, port, _ := net.SplitHostPort(srv.Addr())
+	TestServer(t, srv.Addr(), "localhost:"+port)
+}
+
+// RunServerCase sends the request from c to the server at addr and returns
+// its response. The error is only for failing to connect.
+func RunServerCase(addr, host string, c ServerCase) (*ServerResult, error) {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}, "tcp", addr, &tls.Config{
+		ServerName:         hostname,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.Write([]byte(expandRequest(c.Request, host))); err != nil {
+		return nil, err
+	}
+
+	// Servers may reset the connection after rejecting a request, so read
+	// errors only end the response.
+	raw, _ := ioutil.ReadAll(conn)
+
+	res := &ServerResult{Raw: raw, TLS: conn.ConnectionState()}
+	if i := bytes.Index(raw, []byte("\r\n")); i >= 0 {
+		header := string(raw[:i])
+		res.Body = raw[i+2:]
+
+		split := strings.SplitN(header, " ", 2)
+		if code, err := strconv.Atoi(split[0]); err == nil && len(split[0]) == 2 {
+			res.HasHeader = true
+			res.Status = code
+			if len(split) == 2 {
+				res.Meta = split[1]
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// expandRequest replaces {host} and {pad N}, which pads the URL with path
+// characters until it is N bytes long.
+func expandRequest(req, host string) string {
+	req = strings.Replace(req, "{host}", host, -1)
+
+	start := strings.Index(req, "{pad ")
+	if start < 0 {
+		return req
+	}
+	end := strings.Index(req[start:], "}") + start
+
+	n, _ := strconv.Atoi(req[start+len("{pad ") : end])
+	rest := req[end+1:]
+	prefix := req[:start]
+
+	urlLen := len(prefix) + len(strings.TrimSuffix(strings.TrimSuffix(rest, "\n"), "\r"))
+	if pad := n - urlLen; pad > 0 {
+		prefix += strings.Repeat("a", pad)
+	}
+
+	return prefix + rest
+}
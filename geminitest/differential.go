@@ -0,0 +1,282 @@
+// Package geminitest provides utilities for testing Gemini software.
+//
+// Server starts a real server for a handler, or one which injects faults
+// like slow or truncated responses, so error handling can be tested without
+// crafting raw TLS fixtures:
+//
+//	srv := geminitest.NewFaultServer(geminitest.CloseMidBody("text/gemini", body, 10))
+//	defer srv.Close()
+//
+// The differential harness checks that the package's readers and writers
+// agree with each other and with reference vectors, so the Client never
+// accepts something a Server would never send, and the Server never sends
+// something the Client can't read back exactly.
+//
+//	func TestDifferential(t *testing.T) {
+//		geminitest.Differential(t, 10000, 1)
+//	}
+package geminitest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"gopkg.in/gemini.v0"
+)
+
+// Differential checks the reference vectors, then round-trips iterations
+// randomly generated requests and responses, generated from seed, reporting
+// every asymmetry as a test error.
+func Differential(t testing.TB, iterations int, seed int64) {
+	t.Helper()
+
+	for _, err := range CheckVectors() {
+		t.Error(err)
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < iterations; i++ {
+		if raw := RandomResponse(rng); CheckResponse(raw) != nil {
+			t.Errorf("response %q: %v", raw, CheckResponse(raw))
+		}
+
+		if raw := RandomRequest(rng); CheckRequest(raw) != nil {
+			t.Errorf("request %q: %v", raw, CheckRequest(raw))
+		}
+	}
+}
+
+// CheckVectors checks the package against ResponseVectors, RequestVectors
+// and StrictRequestVectors, returning an error for each vector which isn't
+// handled as expected.
+func CheckVectors() []error {
+	var errs []error
+
+	for _, v := range ResponseVectors {
+		_, err := readResponse([]byte(v.Raw))
+		if (err == nil) != v.Valid {
+			errs = append(errs, vectorError("response", v, err))
+		}
+		if err := CheckResponse([]byte(v.Raw)); err != nil {
+			errs = append(errs, fmt.Errorf("response vector %q: %v", v.Name, err))
+		}
+	}
+
+	for _, v := range RequestVectors {
+		_, err := gemini.ReadRequest(strings.NewReader(v.Raw))
+		if (err == nil) != v.Valid {
+			errs = append(errs, vectorError("request", v, err))
+		}
+		if err := CheckRequest([]byte(v.Raw)); err != nil {
+			errs = append(errs, fmt.Errorf("request vector %q: %v", v.Name, err))
+		}
+	}
+
+	for _, v := range StrictRequestVectors {
+		_, err := gemini.ReadRequestStrict(strings.NewReader(v.Raw))
+		if (err == nil) != v.Valid {
+			errs = append(errs, vectorError("strict request", v, err))
+		}
+	}
+
+	return errs
+}
+
+func vectorError(kind string, v Vector, err error) error {
+	if v.Valid {
+		return fmt.Errorf("%s vector %q was rejected: %v", kind, v.Name, err)
+	}
+	return fmt.Errorf("%s vector %q was accepted", kind, v.Name)
+}
+
+func readResponse(raw []byte) (*gemini.Response, error) {
+	return gemini.ReadResponse(ioutil.NopCloser(bytes.NewReader(raw)))
+}
+
+// CheckResponse checks that raw, a response as it would be read by a client,
+// is handled symmetrically. If gemini.ReadResponse accepts it, the status
+// must have two digits and the meta must be one gemini.NewResponse accepts.
+// For known statuses, writing the response back out must also reproduce the
+// header, and the body of success responses, exactly. Responses which are
+// rejected are not an error.
+func CheckResponse(raw []byte) error {
+	resp, err := readResponse(raw)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading body: %v", err)
+	}
+
+	if resp.Status < 10 || resp.Status > 99 {
+		return fmt.Errorf("reader accepted status %d", resp.Status)
+	}
+
+	if _, err := gemini.NewResponse(gemini.StatusSuccess, resp.Meta); err != nil {
+		return fmt.Errorf("reader accepted a meta the writer rejects: %v", err)
+	}
+
+	// Clients handle statuses they don't know by their first digit, so the
+	// reader may accept some the writer won't produce.
+	if _, err := gemini.NewResponse(resp.Status, resp.Meta); err != nil {
+		return nil
+	}
+
+	expected := raw
+	out := &gemini.Response{Status: resp.Status, Meta: resp.Meta}
+	if out.IsSuccess() {
+		out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	} else {
+		expected = raw[:bytes.Index(raw, []byte("\r\n"))+2]
+	}
+
+	var buf bytes.Buffer
+	if _, err := out.WriteTo(&buf); err != nil {
+		return fmt.Errorf("writing response: %v", err)
+	}
+
+	if !bytes.Equal(buf.Bytes(), expected) {
+		return fmt.Errorf("round trip changed response to %q", buf.Bytes())
+	}
+
+	return nil
+}
+
+// CheckRequest checks that raw, a request line as it would be read by a
+// server, is handled symmetrically. If gemini.ReadRequestStrict accepts it,
+// gemini.ReadRequest must accept it too and agree on the URL. If
+// gemini.ReadRequest accepts it, the line must be within
+// gemini.MaxURLLength and the parsed request must survive being serialized
+// and read again. Requests which are rejected are not an error.
+func CheckRequest(raw []byte) error {
+	strict, strictErr := gemini.ReadRequestStrict(bytes.NewReader(raw))
+
+	req, err := gemini.ReadRequest(bytes.NewReader(raw))
+	if err != nil {
+		if strictErr == nil {
+			return fmt.Errorf("strict reader accepted a request the reader rejects: %v", err)
+		}
+		return nil
+	}
+
+	if strictErr == nil && strict.URL.String() != req.URL.String() {
+		return fmt.Errorf("readers disagree on URL: %q and %q", strict.URL, req.URL)
+	}
+
+	if len(raw)-2 > gemini.MaxURLLength {
+		return fmt.Errorf("reader accepted a %d byte URL", len(raw)-2)
+	}
+
+	// Titan parameters are moved out of the URL when the request is read, so
+	// it can't be serialized again. Relative URLs are never accepted by a
+	// Server, and some of them don't survive url.URL.String.
+	if req.Titan != nil || !req.URL.IsAbs() || req.URL.Host == "" {
+		return nil
+	}
+
+	// The URL may be normalized when it's serialized, but reading it back
+	// must not change it any further.
+	again, err := gemini.ReadRequest(strings.NewReader(req.String()))
+	if err != nil {
+		return fmt.Errorf("reader rejected its own request %q: %v", req.String(), err)
+	}
+	if again.URL.String() != req.URL.String() {
+		return fmt.Errorf("round trip changed URL from %q to %q", req.URL, again.URL)
+	}
+
+	return nil
+}
+
+// RandomResponse returns a raw response for CheckResponse. Most are valid, but
+// they are biased towards edge cases like long metas, odd statuses and stray
+// control characters.
+func RandomResponse(rng *rand.Rand) []byte {
+	var b bytes.Buffer
+
+	switch rng.Intn(8) {
+	case 0:
+		b.WriteString(fmt.Sprint(rng.Intn(1000)))
+	case 1:
+		b.WriteString(pick(rng, []string{"+2", "-1", "0x", " 20", "2 ", "020", "٢٠"}))
+	default:
+		b.WriteString(fmt.Sprint(pick(rng, []string{"10", "11", "20", "30", "31", "40", "44", "51", "53", "59", "60", "62"})))
+	}
+
+	if rng.Intn(20) != 0 {
+		b.WriteByte(' ')
+	}
+
+	b.WriteString(randomString(rng, metaAlphabet, randomLength(rng, gemini.MaxMetaLength)))
+	b.WriteString(lineEnding(rng))
+
+	if rng.Intn(2) == 0 {
+		b.WriteString(randomString(rng, metaAlphabet+"\r\n", rng.Intn(64)))
+	}
+
+	return b.Bytes()
+}
+
+// RandomRequest returns a raw request line for CheckRequest. Most are valid,
+// but they are biased towards edge cases like long URLs, unusual schemes and
+// characters which URL parsers disagree on.
+func RandomRequest(rng *rand.Rand) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(pick(rng, []string{"gemini://", "gemini://", "gemini://", "titan://", "http://", "//", "", "GEMINI://"}))
+	b.WriteString(pick(rng, []string{"example.com", "example.com", "EXAMPLE.com", "example.com:1965", "example.com:", "example.com:01965", "[::1]", "user@example.com", "xn--bcher-kva.example", "bücher.example", ""}))
+
+	length := randomLength(rng, gemini.MaxURLLength-b.Len())
+	b.WriteString("/")
+	b.WriteString(randomString(rng, pathAlphabet, length))
+
+	if strings.HasPrefix(b.String(), "titan://") && rng.Intn(4) != 0 {
+		b.WriteString(fmt.Sprintf(";size=%d", rng.Intn(100)))
+	}
+
+	b.WriteString(lineEnding(rng))
+	return b.Bytes()
+}
+
+const (
+	metaAlphabet = "abcdefghijklmnopqrstuvwxyz/;=-. 0123456789é☃\t\x00\x7f"
+	pathAlphabet = "abcdefghijklmnopqrstuvwxyz/.-_~%20?#&=+;@:\\ é\t\x00"
+)
+
+func pick(rng *rand.Rand, choices []string) string {
+	return choices[rng.Intn(len(choices))]
+}
+
+// randomLength returns a length which is usually short, but sometimes right
+// around limit.
+func randomLength(rng *rand.Rand, limit int) int {
+	if rng.Intn(10) == 0 && limit > 2 {
+		return limit - 2 + rng.Intn(5)
+	}
+	return rng.Intn(40)
+}
+
+func randomString(rng *rand.Rand, alphabet string, length int) string {
+	runes := []rune(alphabet)
+
+	var b strings.Builder
+	for b.Len() < length {
+		b.WriteRune(runes[rng.Intn(len(runes))])
+	}
+
+	// Multi-byte runes may overshoot, which only matters near the limits.
+	return b.String()
+}
+
+func lineEnding(rng *rand.Rand) string {
+	if rng.Intn(20) == 0 {
+		return pick(rng, []string{"\n", "\r", ""})
+	}
+	return "\r\n"
+}
@@ -0,0 +1,7 @@
+package geminitest
+
+import "testing"
+
+func TestDifferential(t *testing.T) {
+	Differential(t, 10000, 1)
+}
@@ -0,0 +1,118 @@
+package geminitest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// A Fault replies to a request on a FaultConn, generally by misbehaving in a
+// way a real server might. The connection is closed when it returns.
+type Fault func(c *FaultConn)
+
+// A FaultConn is a connection handed to a Fault, after its request line has
+// been read.
+type FaultConn struct {
+	*tls.Conn
+
+	// Request is the request line, without the CRLF.
+	Request string
+
+	raw    net.Conn
+	closed chan struct{}
+}
+
+// Abort closes the underlying connection without a TLS close_notify, which
+// is what a client sees when a server crashes or the network fails.
+func (c *FaultConn) Abort() {
+	c.raw.Close()
+}
+
+// Sleep waits for d, returning false early if the server is closed.
+func (c *FaultConn) Sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+// Respond sends raw, which may be any bytes at all, and closes the
+// connection cleanly.
+func Respond(raw string) Fault {
+	return func(c *FaultConn) {
+		_, _ = c.Write([]byte(raw))
+	}
+}
+
+// Success is the happy path: a gemtext response with body.
+func Success(body string) Fault {
+	return Respond("20 text/gemini\r\n" + body)
+}
+
+// Delay waits for d before running f, simulating a slow server.
+func Delay(d time.Duration, f Fault) Fault {
+	return func(c *FaultConn) {
+		if c.Sleep(d) {
+			f(c)
+		}
+	}
+}
+
+// Dribble sends raw one byte at a time, waiting interval between them,
+// simulating a slow or congested link.
+func Dribble(raw string, interval time.Duration) Fault {
+	return func(c *FaultConn) {
+		for i := 0; i < len(raw); i++ {
+			if _, err := c.Write([]byte{raw[i]}); err != nil {
+				return
+			}
+			if i < len(raw)-1 && !c.Sleep(interval) {
+				return
+			}
+		}
+	}
+}
+
+// CloseMidBody sends a success header with meta and the first n bytes of
+// body, then aborts the connection, as if the server crashed.
+func CloseMidBody(meta, body string, n int) Fault {
+	if n > len(body) {
+		n = len(body)
+	}
+
+	return func(c *FaultConn) {
+		_, _ = c.Write([]byte("20 " + meta + "\r\n" + body[:n]))
+		c.Abort()
+	}
+}
+
+// BadCRLF sends a header terminated by a bare LF instead of CRLF.
+func BadCRLF(status int, meta string) Fault {
+	return Respond(fmt.Sprintf("%d %s\n", status, meta))
+}
+
+// OversizedMeta sends a success header whose meta is size bytes long. The
+// spec limits metas to 1024 bytes.
+func OversizedMeta(size int) Fault {
+	return Respond("20 " + strings.Repeat("a", size) + "\r\n")
+}
+
+// NoResponse closes the connection without sending anything.
+func NoResponse() Fault {
+	return func(c *FaultConn) {}
+}
+
+// Hang never responds, holding the connection open until the server is
+// closed. It is useful for testing timeouts.
+func Hang() Fault {
+	return func(c *FaultConn) {
+		<-c.closed
+	}
+}
@@ -0,0 +1,199 @@
+package geminitest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// A Server is a Gemini server listening on the loopback interface, for use
+// in tests. It either runs a gemini.Server with a handler, or injects a Fault
+// in reply to every request.
+//
+//	srv := geminitest.NewServer(handler)
+//	defer srv.Close()
+//
+//	resp, err := client.Get(srv.URL + "/page")
+type Server struct {
+	// URL is the base URL of the server, like "gemini://127.0.0.1:1234",
+	// without a trailing slash.
+	URL string
+
+	Listener    net.Listener
+	Certificate tls.Certificate
+
+	// Server is the gemini.Server started by NewServer. It is nil for
+	// servers started by NewFaultServer.
+	Server *gemini.Server
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed chan struct{}
+}
+
+// NewServer starts and returns a server which serves h with a self-signed
+// certificate. Client certificates are requested, but not required. The
+// server's log is discarded.
+func NewServer(h gemini.Handler) *Server {
+	s := newServer()
+
+	s.Server = &gemini.Server{
+		Handler: h,
+		TLS: &tls.Config{
+			Certificates: []tls.Certificate{s.Certificate},
+			ClientAuth:   tls.RequestClientCert,
+		},
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}
+	go s.Server.Serve(s.Listener)
+
+	return s
+}
+
+// NewFaultServer starts and returns a server which reads each request line
+// and then hands the connection to fault.
+func NewFaultServer(fault Fault) *Server {
+	s := newServer()
+
+	config := &tls.Config{Certificates: []tls.Certificate{s.Certificate}}
+	go func() {
+		for {
+			conn, err := s.Listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.track(conn, true)
+			go s.serveFault(conn, tls.Server(conn, config), fault)
+		}
+	}()
+
+	return s
+}
+
+func newServer() *Server {
+	cert, err := NewCertificate("localhost", "127.0.0.1")
+	if err != nil {
+		panic("geminitest: " + err.Error())
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("geminitest: " + err.Error())
+	}
+
+	return &Server{
+		URL:         "gemini://" + l.Addr().String(),
+		Listener:    l,
+		Certificate: cert,
+		conns:       make(map[net.Conn]struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// Addr returns the host:port the server is listening on.
+func (s *Server) Addr() string {
+	return s.Listener.Addr().String()
+}
+
+// Close shuts down the server, closing all connections, including those of
+// faults which are still running.
+func (s *Server) Close() {
+	if s.Server != nil {
+		_ = s.Server.Close()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+
+	close(s.closed)
+	s.Listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *Server) track(conn net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+func (s *Server) serveFault(raw net.Conn, conn *tls.Conn, fault Fault) {
+	defer s.track(raw, false)
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return
+	}
+
+	fault(&FaultConn{
+		Conn:    conn,
+		Request: strings.TrimRight(line, "\r\n"),
+		raw:     raw,
+		closed:  s.closed,
+	})
+}
+
+// NewCertificate returns a self-signed certificate for the given hosts, which
+// may be names or IP addresses. It is valid from an hour ago for a day.
+func NewCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	if len(hosts) > 0 {
+		tmpl.Subject = pkix.Name{CommonName: hosts[0]}
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -0,0 +1,65 @@
+package geminitest
+
+import "strings"
+
+// A Vector is a raw request or response line along with whether the spec
+// allows it.
+type Vector struct {
+	Name  string
+	Raw   string
+	Valid bool
+}
+
+// ResponseVectors are reference response headers. Valid vectors must be
+// accepted by gemini.ReadResponse and invalid ones rejected.
+var ResponseVectors = []Vector{
+	{"success", "20 text/gemini\r\n", true},
+	{"success with params", "20 text/gemini; charset=utf-8; lang=en\r\n", true},
+	{"empty meta", "20 \r\n", true},
+	{"input", "10 What is your name?\r\n", true},
+	{"sensitive input", "11 Password\r\n", true},
+	{"redirect", "31 gemini://example.com/\r\n", true},
+	{"unknown status", "69 future\r\n", true},
+	{"meta at limit", "20 " + strings.Repeat("a", 1024) + "\r\n", true},
+	{"meta with spaces", "51 not  found \r\n", true},
+
+	{"missing space", "20\r\n", false},
+	{"missing CR", "20 text/gemini\n", false},
+	{"one digit status", "2 text/gemini\r\n", false},
+	{"three digit status", "200 text/gemini\r\n", false},
+	{"signed status", "+2 text/gemini\r\n", false},
+	{"non-numeric status", "ab text/gemini\r\n", false},
+	{"meta too long", "20 " + strings.Repeat("a", 1025) + "\r\n", false},
+	{"CR in meta", "20 text/\rgemini\r\n", false},
+	{"no line ending", "20 text/gemini", false},
+}
+
+// RequestVectors are reference request lines. Valid vectors must be accepted
+// by gemini.ReadRequest and invalid ones rejected. Vectors which only
+// gemini.ReadRequestStrict must reject are in StrictRequestVectors.
+var RequestVectors = []Vector{
+	{"root", "gemini://example.com/\r\n", true},
+	{"no path", "gemini://example.com\r\n", true},
+	{"query", "gemini://example.com/search?hello%20world\r\n", true},
+	{"port", "gemini://example.com:1966/\r\n", true},
+	{"IPv6", "gemini://[::1]/\r\n", true},
+	{"titan", "titan://example.com/file.txt;size=5;mime=text/plain\r\n", true},
+	{"URL at limit", "gemini://example.com/" + strings.Repeat("a", 1024-len("gemini://example.com/")) + "\r\n", true},
+
+	{"missing CR", "gemini://example.com/\n", false},
+	{"URL too long", "gemini://example.com/" + strings.Repeat("a", 1025-len("gemini://example.com/")) + "\r\n", false},
+	{"invalid escape", "gemini://example.com/%zz\r\n", false},
+	{"titan without size", "titan://example.com/file.txt\r\n", false},
+}
+
+// StrictRequestVectors are request lines which gemini.ReadRequest may accept
+// but gemini.ReadRequestStrict must reject.
+var StrictRequestVectors = []Vector{
+	{"backslash", "gemini://example.com\\@evil.com/\r\n", false},
+	{"fragment", "gemini://example.com/#top\r\n", false},
+	{"space", "gemini://example.com/a b\r\n", false},
+	{"userinfo", "gemini://user@example.com/\r\n", false},
+	{"relative", "/index.gmi\r\n", false},
+	{"empty port", "gemini://example.com:/\r\n", false},
+	{"padded port", "gemini://example.com:01965/\r\n", false},
+}
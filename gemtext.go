@@ -0,0 +1,59 @@
+package gemini
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// ParseLink parses a single gemtext link line, such as "=> /about About". It
+// returns false if line is not a link line.
+func ParseLink(line string) (Link, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if !strings.HasPrefix(line, "=>") {
+		return Link{}, false
+	}
+
+	fields := strings.Fields(line[2:])
+	if len(fields) == 0 {
+		return Link{}, false
+	}
+
+	link := Link{URL: fields[0]}
+
+	// The label is everything after the URL, with the original spacing
+	// inside it preserved.
+	rest := strings.TrimLeft(line[2:], " \t")
+	link.Label = strings.TrimSpace(rest[len(fields[0]):])
+
+	return link, true
+}
+
+// ExtractLinks returns all the links in a gemtext document, in order. Lines in
+// preformatted blocks are skipped.
+func ExtractLinks(r io.Reader) ([]Link, error) {
+	var links []Link
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+
+	preformatted := false
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "```") {
+			preformatted = !preformatted
+			continue
+		}
+
+		if preformatted {
+			continue
+		}
+
+		if link, ok := ParseLink(line); ok {
+			links = append(links, link)
+		}
+	}
+
+	return links, scanner.Err()
+}
@@ -0,0 +1,223 @@
+// Package guestbook collects messages from visitors, for guestbooks and
+// comment threads. Messages are entered with an input prompt, signed with the
+// visitor's client certificate if they have one, and rendered as a gemtext
+// thread.
+//
+// Each path under the prefix is a separate thread, so the same Guestbook can
+// hold the comments for every page of a capsule:
+//
+//	g := &guestbook.Guestbook{Store: guestbook.DirStore("/srv/comments")}
+//	mux.Handle("/comments/*thread", g)
+//
+//	/comments/gemlog/post.gmi        the thread for gemlog/post.gmi
+//	/comments/gemlog/post.gmi/post   prompts for a message to add to it
+package guestbook
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/gemini.v0"
+)
+
+// A Message is a single visitor message.
+type Message struct {
+	Thread string `json:"thread"`
+	Name   string `json:"name"`
+	Text   string `json:"text"`
+
+	// Fingerprint is the fingerprint of the certificate the message was
+	// posted with, or empty if it was anonymous.
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	Time time.Time `json:"time"`
+}
+
+// A Store saves messages.
+type Store interface {
+	// Add appends m to its thread.
+	Add(ctx context.Context, m Message) error
+
+	// Messages returns the messages in thread, oldest first.
+	Messages(ctx context.Context, thread string) ([]Message, error)
+}
+
+// Guestbook is a gemini.Handler which shows and collects messages.
+type Guestbook struct {
+	Store Store
+
+	// Title is the heading of each thread. If empty, "Guestbook" is used.
+	Title string
+
+	// Prefix is the path the Guestbook is mounted at. If empty,
+	// "/guestbook/" is used.
+	Prefix string
+
+	// MaxLength is the longest message accepted, in characters. If zero, 500
+	// is used.
+	MaxLength int
+
+	// RequireIdentity makes visitors without a client certificate get
+	// gemini.StatusCertificateRequired instead of posting anonymously.
+	RequireIdentity bool
+}
+
+// postSuffix is the last path segment of the input prompt for a thread.
+const postSuffix = "/post"
+
+func (g *Guestbook) prefix() string {
+	prefix := g.Prefix
+	if prefix == "" {
+		prefix = "/guestbook/"
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+func (g *Guestbook) maxLength() int {
+	if g.MaxLength > 0 {
+		return g.MaxLength
+	}
+	return 500
+}
+
+// ServeGemini implements gemini.Handler.
+func (g *Guestbook) ServeGemini(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(g.prefix(), "/"))
+	rest = strings.Trim(rest, "/")
+
+	if rest == "post" || strings.HasSuffix(rest, postSuffix) {
+		thread := strings.TrimSuffix(strings.TrimSuffix(rest, "post"), "/")
+		g.servePost(ctx, w, r, thread)
+		return
+	}
+
+	g.serveThread(ctx, w, rest)
+}
+
+// threadURL returns the path of thread, with an optional suffix.
+func (g *Guestbook) threadURL(thread, suffix string) string {
+	p := g.prefix() + thread
+	if thread != "" {
+		p += "/"
+	}
+	p += suffix
+
+	u := &url.URL{Path: p}
+	return u.EscapedPath()
+}
+
+func (g *Guestbook) serveThread(ctx context.Context, w gemini.ResponseWriter, thread string) {
+	messages, err := g.Store.Messages(ctx, thread)
+	if err != nil {
+		w.WriteStatus(gemini.StatusTemporaryFailure, "Unable to load messages")
+		return
+	}
+
+	title := g.Title
+	if title == "" {
+		title = "Guestbook"
+	}
+
+	w.WriteStatus(gemini.StatusSuccess, "text/gemini")
+	fmt.Fprintf(w, "# %s\n\n", title)
+	fmt.Fprintf(w, "=> %s Leave a message\n", g.threadURL(thread, "post"))
+
+	if len(messages) == 0 {
+		fmt.Fprintf(w, "\nThere are no messages yet.\n")
+		return
+	}
+
+	for _, m := range messages {
+		fmt.Fprintf(w, "\n%s", Render(m))
+	}
+}
+
+// Render formats a message as gemtext. The text is quoted line by line, so
+// messages can't add links, headings or preformatted blocks to the page.
+func Render(m Message) string {
+	var b strings.Builder
+
+	b.WriteString("### " + m.Name)
+	if len(m.Fingerprint) >= 11 {
+		b.WriteString(" (" + m.Fingerprint[:11] + ")")
+	}
+	b.WriteString(" · " + m.Time.UTC().Format("2006-01-02 15:04 MST") + "\n")
+
+	for _, line := range strings.Split(m.Text, "\n") {
+		b.WriteString("> " + line + "\n")
+	}
+
+	return b.String()
+}
+
+func (g *Guestbook) servePost(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request, thread string) {
+	if g.RequireIdentity && r.Identity == nil {
+		w.WriteStatus(gemini.StatusCertificateRequired, "A certificate is required to post")
+		return
+	}
+
+	if r.URL.RawQuery == "" {
+		w.WriteStatus(gemini.StatusInput, "Your message")
+		return
+	}
+
+	text, err := url.QueryUnescape(r.URL.RawQuery)
+	if err != nil {
+		w.WriteStatus(gemini.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	text = clean(text)
+	if text == "" {
+		w.WriteStatus(gemini.StatusInput, "Your message can't be empty")
+		return
+	}
+
+	if utf8.RuneCountInString(text) > g.maxLength() {
+		w.WriteStatus(gemini.StatusInput, fmt.Sprintf("Your message must be at most %d characters", g.maxLength()))
+		return
+	}
+
+	m := Message{
+		Thread: thread,
+		Name:   "Anonymous",
+		Text:   text,
+		Time:   time.Now(),
+	}
+
+	if r.Identity != nil {
+		m.Fingerprint = gemini.Fingerprint(r.Identity)
+		if cn := clean(r.Identity.Subject.CommonName); cn != "" {
+			m.Name = strings.Replace(cn, "\n", " ", -1)
+		}
+	}
+
+	if err := g.Store.Add(ctx, m); err != nil {
+		w.WriteStatus(gemini.StatusTemporaryFailure, "Unable to save message")
+		return
+	}
+
+	w.WriteStatus(gemini.StatusRedirect, g.threadURL(thread, ""))
+}
+
+// clean normalizes line endings and removes other control characters and
+// surrounding whitespace.
+func clean(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,106 @@
+package guestbook
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MemoryStore is a Store which keeps messages in memory. The zero value is
+// ready to use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	messages map[string][]Message
+}
+
+// Add implements Store.
+func (s *MemoryStore) Add(ctx context.Context, m Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.messages == nil {
+		s.messages = make(map[string][]Message)
+	}
+	s.messages[m.Thread] = append(s.messages[m.Thread], m)
+
+	return nil
+}
+
+// Messages implements Store.
+func (s *MemoryStore) Messages(ctx context.Context, thread string) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message(nil), s.messages[thread]...), nil
+}
+
+// DirStore is a Store which appends each thread's messages to a file of JSON
+// lines in a directory, named after the hash of the thread.
+type DirStore string
+
+// dirStoreLock serializes appends, so lines from concurrent posts aren't
+// interleaved.
+var dirStoreLock sync.Mutex
+
+func (d DirStore) path(thread string) string {
+	sum := sha256.Sum256([]byte(thread))
+	return filepath.Join(string(d), hex.EncodeToString(sum[:])+".jsonl")
+}
+
+// Add implements Store.
+func (d DirStore) Add(ctx context.Context, m Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	dirStoreLock.Lock()
+	defer dirStoreLock.Unlock()
+
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(d.path(m.Thread), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Messages implements Store.
+func (d DirStore) Messages(ctx context.Context, thread string) ([]Message, error) {
+	f, err := os.Open(d.path(thread))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var messages []Message
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var m Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			// Skip lines from interrupted writes.
+			continue
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, scanner.Err()
+}
@@ -0,0 +1,111 @@
+package gemini_test
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/gemini.v0"
+	"gopkg.in/gemini.v0/geminitest"
+)
+
+// leakTimeout is how long leak checks wait for goroutines and connections to
+// finish before failing.
+const leakTimeout = 5 * time.Second
+
+// startServer serves s on a loopback address with a self-signed certificate,
+// counting its open connections. Serve's result is sent on the returned
+// channel. The server is closed when the test finishes.
+func startServer(t *testing.T, s *gemini.Server) (string, *connCounter, <-chan error) {
+	t.Helper()
+
+	cert, err := geminitest.NewCertificate("localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter := &connCounter{}
+	s.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	s.ErrorLog = log.New(ioutil.Discard, "", 0)
+	s.DrainProgress = func(gemini.DrainStats) {}
+	s.ConnState = counter.hook
+
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	t.Cleanup(func() { _ = s.Close() })
+
+	return l.Addr().String(), counter, served
+}
+
+// connCounter counts a Server's open connections with its ConnState hook.
+type connCounter struct {
+	mu   sync.Mutex
+	open int
+}
+
+func (c *connCounter) hook(conn net.Conn, state gemini.ConnState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch state {
+	case gemini.StateNew:
+		c.open++
+	case gemini.StateHijacked, gemini.StateClosed:
+		c.open--
+	}
+}
+
+// waitClosed fails t if the server still has open connections once
+// leakTimeout has passed.
+func (c *connCounter) waitClosed(t *testing.T) {
+	t.Helper()
+
+	deadline := time.Now().Add(leakTimeout)
+	for {
+		c.mu.Lock()
+		open := c.open
+		c.mu.Unlock()
+
+		if open == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections were left open", open)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// checkGoroutines fails t if more than before goroutines are still running
+// once leakTimeout has passed.
+func checkGoroutines(t *testing.T, before int) {
+	t.Helper()
+
+	deadline := time.Now().Add(leakTimeout)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= before {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Fatalf("%d goroutines leaked:\n%s", n-before, buf)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,98 @@
+package hosting
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// TenantConfigFile is the name of the optional settings file in each tenant
+// directory of a DirStore.
+const TenantConfigFile = "tenant.json"
+
+// DirStore is a Store which loads tenants from a directory with a
+// subdirectory per host:
+//
+//	example.com/
+//		public/       the content of the capsule
+//		cert.pem      optional certificate and key
+//		key.pem
+//		tenant.json   optional limits
+//
+// The limits in tenant.json look like:
+//
+//	{"rate_limit": 60, "rate_window": "1m", "daily_bytes": 104857600}
+type DirStore string
+
+type tenantConfig struct {
+	RateLimit  int    `json:"rate_limit"`
+	RateWindow string `json:"rate_window"`
+	DailyBytes int64  `json:"daily_bytes"`
+}
+
+// Load implements Store.
+func (d DirStore) Load(host string) (*Tenant, error) {
+	// Hostnames which could escape the directory can never be tenants.
+	if host == "" || strings.ContainsAny(host, `/\`) || strings.HasPrefix(host, ".") {
+		return nil, ErrUnknownTenant
+	}
+
+	dir := filepath.Join(string(d), host)
+
+	public := filepath.Join(dir, "public")
+	info, err := os.Stat(public)
+	if os.IsNotExist(err) {
+		return nil, ErrUnknownTenant
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, ErrUnknownTenant
+	}
+
+	tenant := &Tenant{
+		Host: host,
+		Root: gemini.Dir(public),
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	if _, err := os.Stat(certFile); err == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, filepath.Join(dir, "key.pem"))
+		if err != nil {
+			return nil, err
+		}
+		tenant.Certificate = &cert
+	}
+
+	f, err := os.Open(filepath.Join(dir, TenantConfigFile))
+	if os.IsNotExist(err) {
+		return tenant, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var config tenantConfig
+	if err := json.NewDecoder(f).Decode(&config); err != nil {
+		return nil, err
+	}
+
+	tenant.RateLimit = config.RateLimit
+	tenant.DailyBytes = config.DailyBytes
+
+	if config.RateWindow != "" {
+		tenant.RateWindow, err = time.ParseDuration(config.RateWindow)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tenant, nil
+}
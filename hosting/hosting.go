@@ -0,0 +1,403 @@
+// Package hosting serves many capsules from one server, with each tenant
+// identified by hostname and given its own content, certificate, rate limit
+// and bandwidth quota, along with any further gemini.Quota limits.
+//
+// Tenants are loaded on demand from a Store, such as a DirStore, and cached
+// for a short time, so tenants can be added and changed without restarting
+// the server.
+//
+//	host := &hosting.Host{Store: hosting.DirStore("/srv/tenants")}
+//	server := &gemini.Server{
+//		Handler: host,
+//		TLS:     host.TLSConfig(),
+//	}
+package hosting
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// ErrUnknownTenant is returned by a Store when there is no tenant for a host.
+var ErrUnknownTenant = errors.New("hosting: unknown tenant")
+
+// A Tenant is a single hosted capsule.
+type Tenant struct {
+	// Host is the hostname of the capsule, in lower case.
+	Host string
+
+	// Root is the content of the capsule.
+	Root gemini.FileSystem
+
+	// Handler, if set, serves the capsule instead of a FileServer for Root.
+	Handler gemini.Handler
+
+	// Certificate is the certificate for Host. If nil, the Host's default
+	// certificate is used.
+	Certificate *tls.Certificate
+
+	// RateLimit is the number of requests a single client IP may make in
+	// RateWindow. Clients over the limit get gemini.StatusSlowDown. If
+	// RateLimit is zero, there is no limit. If RateWindow is zero, one
+	// minute is used.
+	RateLimit  int
+	RateWindow time.Duration
+
+	// DailyBytes is the number of response body bytes the capsule may serve
+	// each day, in UTC. Once it is used up, requests get
+	// gemini.StatusServerUnavailable until the next day. If zero, there is no
+	// quota.
+	DailyBytes int64
+
+	// Quotas are further limits for the capsule, such as request quotas for
+	// each client certificate, checked after RateLimit and DailyBytes.
+	// Usage is recorded in the Host's Quotas store, so it survives reloads,
+	// and the Store of each Quota is ignored.
+	Quotas []*gemini.Quota
+}
+
+// A Store loads tenants. It may be backed by a directory, such as DirStore,
+// or a database.
+type Store interface {
+	// Load returns the tenant for host, which is in lower case, or
+	// ErrUnknownTenant if there isn't one.
+	Load(host string) (*Tenant, error)
+}
+
+// Host is a Handler which dispatches requests to the tenant for the requested
+// hostname. Requests for unknown hosts, or whose URL doesn't match the server
+// name sent with SNI, get gemini.StatusProxyRefusedRequest.
+type Host struct {
+	Store Store
+
+	// Certificate is used for tenants without their own certificate.
+	Certificate *tls.Certificate
+
+	// CacheTTL is how long loaded tenants are kept before they are reloaded
+	// from the Store. If zero, one minute is used.
+	CacheTTL time.Duration
+
+	// Quotas records usage for the Quotas of tenants. If nil, a
+	// gemini.MemoryQuotaStore is used.
+	Quotas gemini.QuotaStore
+
+	mu        sync.Mutex
+	tenants   map[string]*cachedTenant
+	lastSweep time.Time
+	usage     map[string]*usage
+	quotas    gemini.QuotaStore
+}
+
+type cachedTenant struct {
+	tenant  *Tenant
+	handler gemini.Handler
+	loaded  time.Time
+}
+
+// usage tracks the rate limit and quota of a tenant. It is kept separately
+// from the cached tenant so it survives reloads.
+type usage struct {
+	windowStart time.Time
+	requests    map[string]int
+
+	day   string
+	bytes int64
+}
+
+func (h *Host) cacheTTL() time.Duration {
+	if h.CacheTTL > 0 {
+		return h.CacheTTL
+	}
+	return time.Minute
+}
+
+// tenant returns the cached tenant for host, loading it if needed. Unknown
+// hosts aren't cached, as they come from clients, so anyone could fill the
+// cache with made up names.
+func (h *Host) tenant(host string) (*cachedTenant, error) {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	h.mu.Lock()
+	cached, ok := h.tenants[host]
+	h.mu.Unlock()
+
+	if ok && time.Since(cached.loaded) < h.cacheTTL() {
+		return cached, nil
+	}
+
+	tenant, err := h.Store.Load(host)
+	if err == ErrUnknownTenant {
+		if ok {
+			h.mu.Lock()
+			delete(h.tenants, host)
+			h.mu.Unlock()
+		}
+		return nil, err
+	}
+	if err != nil {
+		// Keep serving the previous version if the store is temporarily
+		// broken.
+		if ok {
+			return cached, nil
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	cached = &cachedTenant{
+		tenant:  tenant,
+		handler: h.tenantHandler(tenant),
+		loaded:  now,
+	}
+
+	h.mu.Lock()
+	if h.tenants == nil {
+		h.tenants = make(map[string]*cachedTenant)
+	}
+	h.tenants[host] = cached
+	h.sweepLocked(now)
+	h.mu.Unlock()
+
+	return cached, nil
+}
+
+// sweepLocked drops tenants which haven't been reloaded for a while, such as
+// those which were removed from the Store, at most once per cache TTL. h.mu
+// must be held.
+func (h *Host) sweepLocked(now time.Time) {
+	ttl := h.cacheTTL()
+	if now.Sub(h.lastSweep) < ttl {
+		return
+	}
+	h.lastSweep = now
+
+	for host, cached := range h.tenants {
+		// Expired tenants are kept for a while longer, so they can still be
+		// served if the store breaks.
+		if now.Sub(cached.loaded) > 2*ttl {
+			delete(h.tenants, host)
+		}
+	}
+}
+
+// tenantHandler returns the handler for t, wrapped with its Quotas.
+func (h *Host) tenantHandler(t *Tenant) gemini.Handler {
+	handler := t.Handler
+	if handler == nil {
+		handler = gemini.FileServer(t.Root)
+	}
+
+	if len(t.Quotas) == 0 {
+		return handler
+	}
+
+	h.mu.Lock()
+	if h.quotas == nil {
+		h.quotas = h.Quotas
+		if h.quotas == nil {
+			h.quotas = &gemini.MemoryQuotaStore{}
+		}
+	}
+	store := h.quotas
+	h.mu.Unlock()
+
+	// The first quota is the outermost, so quotas are checked in order.
+	for i := len(t.Quotas) - 1; i >= 0; i-- {
+		handler = h.quotaFor(store, t, i).Middleware(handler)
+	}
+
+	return handler
+}
+
+// quotaFor returns a copy of the ith quota of t which records usage in store,
+// under keys which are unique to the tenant and quota.
+func (h *Host) quotaFor(store gemini.QuotaStore, t *Tenant, i int) *gemini.Quota {
+	q := t.Quotas[i]
+
+	keyFunc := q.Key
+	if keyFunc == nil {
+		keyFunc = gemini.QuotaByClient
+	}
+	prefix := fmt.Sprintf("%s %d ", t.Host, i)
+
+	return &gemini.Quota{
+		Store: store,
+		Key: func(ctx context.Context, r *gemini.Request) string {
+			key := keyFunc(ctx, r)
+			if key == "" {
+				return ""
+			}
+			return prefix + key
+		},
+		Period:      q.Period,
+		MaxRequests: q.MaxRequests,
+		MaxBytes:    q.MaxBytes,
+	}
+}
+
+// GetCertificate returns the certificate for the tenant named by SNI. It is
+// meant to be used as tls.Config.GetCertificate.
+func (h *Host) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cached, err := h.tenant(hello.ServerName)
+	if err == nil && cached.tenant.Certificate != nil {
+		return cached.tenant.Certificate, nil
+	}
+
+	if h.Certificate != nil {
+		return h.Certificate, nil
+	}
+
+	return nil, fmt.Errorf("hosting: no certificate for %q", hello.ServerName)
+}
+
+// TLSConfig returns a TLS config which uses GetCertificate and requests, but
+// doesn't require, client certificates.
+func (h *Host) TLSConfig() *tls.Config {
+	return &tls.Config{
+		ClientAuth:     tls.RequestClientCert,
+		GetCertificate: h.GetCertificate,
+	}
+}
+
+// ServeGemini implements gemini.Handler.
+func (h *Host) ServeGemini(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+	host := r.ServerName
+	if host == "" {
+		host = r.URL.Hostname()
+	}
+
+	if !strings.EqualFold(host, r.URL.Hostname()) {
+		w.WriteStatus(gemini.StatusProxyRefusedRequest, "Proxy requests are not allowed")
+		return
+	}
+
+	cached, err := h.tenant(host)
+	if err == ErrUnknownTenant {
+		w.WriteStatus(gemini.StatusProxyRefusedRequest, "Unknown host")
+		return
+	}
+	if err != nil {
+		w.WriteStatus(gemini.StatusTemporaryFailure, "Unable to load host")
+		return
+	}
+
+	if status, meta := h.checkLimits(ctx, cached.tenant); status != 0 {
+		w.WriteStatus(status, meta)
+		return
+	}
+
+	cw := &countingWriter{ResponseWriter: w}
+	cached.handler.ServeGemini(ctx, cw, r)
+
+	if cached.tenant.DailyBytes > 0 {
+		h.mu.Lock()
+		h.usageFor(cached.tenant.Host).bytes += cw.bytes
+		h.mu.Unlock()
+	}
+}
+
+// usageFor returns the usage for host. h.mu must be held.
+func (h *Host) usageFor(host string) *usage {
+	if h.usage == nil {
+		h.usage = make(map[string]*usage)
+	}
+
+	u, ok := h.usage[host]
+	if !ok {
+		u = &usage{requests: make(map[string]int)}
+		h.usage[host] = u
+	}
+
+	return u
+}
+
+// checkLimits records the request against the tenant's rate limit and
+// returns the status to send if it is over either of its limits.
+func (h *Host) checkLimits(ctx context.Context, t *Tenant) (int, string) {
+	if t.RateLimit <= 0 && t.DailyBytes <= 0 {
+		return 0, ""
+	}
+
+	now := time.Now()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	u := h.usageFor(t.Host)
+
+	if t.DailyBytes > 0 {
+		day := now.UTC().Format("2006-01-02")
+		if u.day != day {
+			u.day = day
+			u.bytes = 0
+		}
+
+		if u.bytes >= t.DailyBytes {
+			return gemini.StatusServerUnavailable, "Bandwidth quota exceeded"
+		}
+	}
+
+	if t.RateLimit > 0 {
+		window := t.RateWindow
+		if window <= 0 {
+			window = time.Minute
+		}
+
+		if now.Sub(u.windowStart) >= window {
+			u.windowStart = now
+			u.requests = make(map[string]int)
+		}
+
+		ip := remoteIP(ctx)
+		u.requests[ip]++
+		if u.requests[ip] > t.RateLimit {
+			wait := u.windowStart.Add(window).Sub(now)
+			return gemini.StatusSlowDown, fmt.Sprint(int(wait.Seconds()) + 1)
+		}
+	}
+
+	return 0, ""
+}
+
+func remoteIP(ctx context.Context) string {
+	addr := gemini.CtxRemoteAddr(ctx)
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+type countingWriter struct {
+	gemini.ResponseWriter
+
+	bytes int64
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements gemini.Flusher.
+func (w *countingWriter) Flush() error {
+	return gemini.Flush(w.ResponseWriter)
+}
+
+// Hijack implements gemini.Hijacker.
+func (w *countingWriter) Hijack() (*tls.Conn, error) {
+	return gemini.Hijack(w.ResponseWriter)
+}
@@ -0,0 +1,138 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"sync"
+)
+
+// HostMux is a Handler which dispatches requests to a handler for each
+// hostname, so a single Server and listener can serve several capsules. Each
+// host may also have its own certificate, which is picked using SNI.
+//
+// Hostnames may start with "*." to match any subdomain one level deep, such
+// as "*.example.com" for "foo.example.com". Exact matches take precedence.
+//
+//	hosts := gemini.NewHostMux()
+//	hosts.Handle("example.com", exampleMux)
+//	hosts.AddCertificate("example.com", exampleCert)
+//
+//	server := &gemini.Server{Handler: hosts, TLS: hosts.TLSConfig()}
+type HostMux struct {
+	// Default handles requests for hosts which haven't been registered. If
+	// nil, they get gemini.StatusProxyRefusedRequest.
+	Default Handler
+
+	lock  sync.RWMutex
+	hosts map[string]*hostEntry
+}
+
+type hostEntry struct {
+	handler Handler
+	cert    *tls.Certificate
+}
+
+// NewHostMux allocates and returns a new HostMux.
+func NewHostMux() *HostMux {
+	return &HostMux{hosts: make(map[string]*hostEntry)}
+}
+
+func (m *HostMux) entry(host string) *hostEntry {
+	host = strings.ToLower(host)
+
+	e := m.hosts[host]
+	if e == nil {
+		e = &hostEntry{}
+		m.hosts[host] = e
+	}
+	return e
+}
+
+// Handle registers the handler for host.
+func (m *HostMux) Handle(host string, h Handler) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.entry(host).handler = h
+}
+
+// AddCertificate sets the certificate presented to clients which ask for host
+// with SNI.
+func (m *HostMux) AddCertificate(host string, cert tls.Certificate) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.entry(host).cert = &cert
+}
+
+// lookup returns the entry for host for which ok returns true, falling back
+// to a wildcard entry.
+func (m *HostMux) lookup(host string, ok func(*hostEntry) bool) *hostEntry {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if e := m.hosts[host]; e != nil && ok(e) {
+		return e
+	}
+
+	if i := strings.IndexByte(host, '.'); i >= 0 {
+		if e := m.hosts["*"+host[i:]]; e != nil && ok(e) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// GetCertificate returns the certificate for the host named by SNI. It is
+// meant to be used as tls.Config.GetCertificate. If there isn't one, the
+// config's Certificates are used instead.
+func (m *HostMux) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if e := m.lookup(hello.ServerName, hasCert); e != nil {
+		return e.cert, nil
+	}
+
+	return nil, nil
+}
+
+// TLSConfig returns a TLS config which uses GetCertificate and requests, but
+// doesn't require, client certificates.
+func (m *HostMux) TLSConfig() *tls.Config {
+	return &tls.Config{
+		ClientAuth:     tls.RequestClientCert,
+		GetCertificate: m.GetCertificate,
+	}
+}
+
+// ServeGemini dispatches the request to the handler for its host. Requests
+// where the host in the URL doesn't match the server name sent with SNI get
+// gemini.StatusProxyRefusedRequest.
+func (m *HostMux) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
+	host := r.ServerName
+	if host == "" {
+		host = r.URL.Hostname()
+	}
+
+	if !strings.EqualFold(host, r.URL.Hostname()) {
+		w.WriteStatus(StatusProxyRefusedRequest, "proxy requests are not allowed")
+		return
+	}
+
+	if e := m.lookup(host, hasHandler); e != nil {
+		e.handler.ServeGemini(ctx, w, r)
+		return
+	}
+
+	if m.Default != nil {
+		m.Default.ServeGemini(ctx, w, r)
+		return
+	}
+
+	w.WriteStatus(StatusProxyRefusedRequest, "unknown host")
+}
+
+func hasCert(e *hostEntry) bool    { return e.cert != nil }
+func hasHandler(e *hostEntry) bool { return e.handler != nil }
@@ -0,0 +1,159 @@
+package gemini
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// FromHTTPHandler adapts a net/http handler so it can serve Gemini requests.
+// This makes it possible to reuse simple content generation code behind a
+// Gemini front end.
+//
+// Requests are passed to h as GET requests for the same URL. HTTP statuses are
+// mapped to the closest Gemini status, Content-Type is passed through as the
+// meta of success responses and Location is used for redirects. Bodies of
+// non-success responses are discarded, as Gemini has no way to send them.
+func FromHTTPHandler(h http.Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		req, err := http.NewRequest(http.MethodGet, r.URL.String(), nil)
+		if err != nil {
+			w.WriteStatus(StatusBadRequest, err.Error())
+			return
+		}
+		req = req.WithContext(ctx)
+
+		req.Host = r.URL.Host
+		req.RequestURI = r.URL.RequestURI()
+		req.TLS = r.TLS
+		req.RemoteAddr = r.RemoteAddr
+
+		hw := &httpResponseWriter{w: w, header: make(http.Header)}
+		h.ServeHTTP(hw, req)
+
+		// A handler which never writes anything is an empty 200 response in
+		// net/http.
+		if !hw.wroteHeader {
+			hw.WriteHeader(http.StatusOK)
+		}
+		if !hw.wroteStatus {
+			hw.writeStatus(nil)
+		}
+	})
+}
+
+type httpResponseWriter struct {
+	w      ResponseWriter
+	header http.Header
+
+	code        int
+	wroteHeader bool
+	wroteStatus bool
+}
+
+func (hw *httpResponseWriter) Header() http.Header {
+	return hw.header
+}
+
+func (hw *httpResponseWriter) WriteHeader(code int) {
+	if hw.wroteHeader {
+		return
+	}
+
+	hw.code = code
+	hw.wroteHeader = true
+
+	// Success responses are delayed until the first Write so the content type
+	// can be sniffed if the handler didn't set one.
+	if code < 200 || code >= 300 {
+		hw.writeStatus(nil)
+	}
+}
+
+func (hw *httpResponseWriter) Write(data []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+
+	if !hw.wroteStatus {
+		hw.writeStatus(data)
+	}
+
+	// Gemini can't carry a body for anything but success responses, so
+	// pretend it was written.
+	if hw.code < 200 || hw.code >= 300 {
+		return len(data), nil
+	}
+
+	return hw.w.Write(data)
+}
+
+// writeStatus sends the Gemini equivalent of the HTTP status. data is the first
+// chunk of the body, if any, used for sniffing the content type.
+func (hw *httpResponseWriter) writeStatus(data []byte) {
+	hw.wroteStatus = true
+
+	status, meta := httpToGeminiStatus(hw.code, hw.header)
+	if status == StatusSuccess && meta == "" {
+		meta = http.DetectContentType(data)
+	}
+
+	hw.w.WriteStatus(status, meta)
+}
+
+// httpToGeminiStatus maps an HTTP status code and response headers to the
+// closest Gemini status and meta.
+func httpToGeminiStatus(code int, header http.Header) (int, string) {
+	switch {
+	case code >= 200 && code < 300:
+		return StatusSuccess, header.Get("Content-Type")
+	case code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect:
+		if loc := header.Get("Location"); loc != "" {
+			return StatusPermanentRedirect, loc
+		}
+	case code >= 300 && code < 400:
+		if loc := header.Get("Location"); loc != "" {
+			return StatusRedirect, loc
+		}
+	case code == http.StatusBadRequest:
+		return StatusBadRequest, http.StatusText(code)
+	case code == http.StatusUnauthorized:
+		return StatusCertificateRequired, http.StatusText(code)
+	case code == http.StatusForbidden:
+		return StatusCertificateNotAuthorized, http.StatusText(code)
+	case code == http.StatusNotFound:
+		return StatusNotFound, http.StatusText(code)
+	case code == http.StatusGone:
+		return StatusGone, http.StatusText(code)
+	case code == http.StatusTooManyRequests:
+		if retry := header.Get("Retry-After"); retry != "" {
+			if _, err := strconv.Atoi(retry); err == nil {
+				return StatusSlowDown, retry
+			}
+		}
+		return StatusSlowDown, http.StatusText(code)
+	case code == http.StatusServiceUnavailable:
+		return StatusServerUnavailable, http.StatusText(code)
+	case code == http.StatusBadGateway || code == http.StatusGatewayTimeout:
+		return StatusProxyError, http.StatusText(code)
+	case code >= 500:
+		return StatusTemporaryFailure, http.StatusText(code)
+	}
+
+	return StatusPermanentFailure, http.StatusText(code)
+}
+
+// FromHTTPFileSystem adapts a net/http FileSystem so it can be used with
+// FileServer. Any implementation of http.FileSystem, such as http.Dir or one
+// generated by an asset embedding tool, can be served this way.
+func FromHTTPFileSystem(fs http.FileSystem) FileSystem {
+	return httpFileSystem{fs}
+}
+
+type httpFileSystem struct {
+	fs http.FileSystem
+}
+
+func (hfs httpFileSystem) Open(name string) (File, error) {
+	return hfs.fs.Open(name)
+}
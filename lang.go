@@ -0,0 +1,114 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// Languages routes requests to a subtree per language based on the first
+// path segment, such as /en/about.gmi or /de/about.gmi, for capsules with one
+// content tree per language. The prefix is stripped before the handler for the
+// language is called, the language is recorded in the context, where it can be
+// read with CtxLanguage, and a lang parameter is added to text responses which
+// don't already have one.
+//
+//	mux.Handle("/*path", &gemini.Languages{
+//		Handlers: map[string]gemini.Handler{
+//			"en": gemini.FileServer(gemini.Dir("/srv/capsule/en")),
+//			"de": gemini.FileServer(gemini.Dir("/srv/capsule/de")),
+//		},
+//		Default: "en",
+//	})
+type Languages struct {
+	// Handlers maps language tags, such as "en" or "pt-BR", to the handler
+	// for that language.
+	Handlers map[string]Handler
+
+	// Default is the language requests without a known language prefix are
+	// redirected to. If empty, they get a 51.
+	Default string
+}
+
+// ServeGemini implements Handler.
+func (l *Languages) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/")
+
+	lang, rest := p, ""
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		lang, rest = p[:i], p[i:]
+	}
+
+	h, ok := l.Handlers[lang]
+	if !ok {
+		if l.Default == "" {
+			NotFound(ctx, r, w)
+			return
+		}
+
+		target := &url.URL{Path: "/" + l.Default + cleanPath(r.URL.Path), RawQuery: r.URL.RawQuery}
+		w.WriteStatus(StatusRedirect, target.String())
+		return
+	}
+
+	// Treat the language prefix as a directory.
+	if rest == "" {
+		target := &url.URL{Path: "/" + lang + "/", RawQuery: r.URL.RawQuery}
+		w.WriteStatus(StatusRedirect, target.String())
+		return
+	}
+
+	r2 := new(Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = rest
+	r2.URL.RawPath = ""
+
+	ctx = context.WithValue(ctx, ctxKeyLanguage, lang)
+	h.ServeGemini(ctx, &langWriter{ResponseWriter: w, lang: lang}, r2)
+}
+
+type langWriter struct {
+	ResponseWriter
+
+	lang       string
+	hasWritten bool
+}
+
+func (w *langWriter) Write(data []byte) (int, error) {
+	if !w.hasWritten {
+		w.WriteStatus(StatusSuccess, "text/gemini")
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush implements Flusher.
+func (w *langWriter) Flush() error {
+	return Flush(w.ResponseWriter)
+}
+
+// Hijack implements Hijacker.
+func (w *langWriter) Hijack() (*tls.Conn, error) {
+	return Hijack(w.ResponseWriter)
+}
+
+func (w *langWriter) WriteStatus(statusCode int, meta string) {
+	w.hasWritten = true
+
+	if statusCode >= StatusSuccess && statusCode < StatusRedirect {
+		if strings.TrimSpace(meta) == "" {
+			meta = "text/gemini"
+		}
+
+		mediaType, params, err := mime.ParseMediaType(meta)
+		if err == nil && strings.HasPrefix(mediaType, "text/") && params["lang"] == "" {
+			meta += "; lang=" + w.lang
+		}
+	}
+
+	w.ResponseWriter.WriteStatus(statusCode, meta)
+}
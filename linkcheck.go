@@ -0,0 +1,221 @@
+package gemini
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DeadHosts caches which hosts are unreachable, so links to them can be
+// flagged by the CheckLinks middleware. Unknown hosts are probed in the
+// background, so serving a page never waits on a probe. The zero value is
+// ready to use.
+type DeadHosts struct {
+	// Client is used to probe hosts. If nil, a Client with a 10 second
+	// timeout is used.
+	Client *Client
+
+	// TTL is how long the result of a probe is trusted. If zero, a day is
+	// used.
+	TTL time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostStatus
+}
+
+type hostStatus struct {
+	dead    bool
+	checked time.Time
+	probing bool
+}
+
+// IsDead reports whether host, in host:port form, is known to be unreachable.
+// If there is no recent result for host, a probe is started in the background
+// and false is returned.
+func (d *DeadHosts) IsDead(host string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.hosts == nil {
+		d.hosts = make(map[string]*hostStatus)
+	}
+
+	status := d.hosts[host]
+	if status == nil {
+		status = &hostStatus{}
+		d.hosts[host] = status
+	}
+
+	if !status.probing && time.Since(status.checked) > d.ttl() {
+		status.probing = true
+		go d.probe(host)
+	}
+
+	return status.dead
+}
+
+// Mark records the state of host without probing it. This can be used to seed
+// the cache, for example from a crawler's results.
+func (d *DeadHosts) Mark(host string, dead bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.hosts == nil {
+		d.hosts = make(map[string]*hostStatus)
+	}
+
+	d.hosts[host] = &hostStatus{dead: dead, checked: time.Now()}
+}
+
+// Check probes host immediately and records the result. It returns true if
+// host is unreachable.
+func (d *DeadHosts) Check(ctx context.Context, host string) bool {
+	client := d.Client
+	if client == nil {
+		client = &Client{Timeout: 10 * time.Second}
+	}
+
+	dead := client.PreDial(ctx, host) != nil
+	d.Mark(host, dead)
+
+	return dead
+}
+
+func (d *DeadHosts) probe(host string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	d.Check(ctx, host)
+}
+
+func (d *DeadHosts) ttl() time.Duration {
+	if d.TTL == 0 {
+		return 24 * time.Hour
+	}
+	return d.TTL
+}
+
+// CheckLinks returns a middleware which appends annotation to the label of
+// links in gemtext responses which point to hosts known to be unreachable. If
+// annotation is empty, "(unreachable)" is used. Links to the same host as the
+// request are never checked.
+//
+// Responses written without calling WriteStatus are assumed to be gemtext.
+func CheckLinks(hosts *DeadHosts, annotation string) func(Handler) Handler {
+	if annotation == "" {
+		annotation = "(unreachable)"
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+			lw := &linkCheckWriter{
+				ResponseWriter: w,
+				hosts:          hosts,
+				annotation:     annotation,
+				base:           r.URL,
+				gemtext:        true,
+			}
+
+			next.ServeGemini(ctx, lw, r)
+			lw.flush()
+		})
+	}
+}
+
+type linkCheckWriter struct {
+	ResponseWriter
+
+	hosts      *DeadHosts
+	annotation string
+	base       *url.URL
+
+	gemtext      bool
+	preformatted bool
+	partial      []byte
+}
+
+func (w *linkCheckWriter) WriteStatus(statusCode int, meta string) {
+	mediaType, _, _ := mime.ParseMediaType(meta)
+	w.gemtext = statusCode >= StatusSuccess && statusCode < StatusRedirect && (meta == "" || mediaType == "text/gemini")
+	w.ResponseWriter.WriteStatus(statusCode, meta)
+}
+
+func (w *linkCheckWriter) Write(data []byte) (int, error) {
+	if !w.gemtext {
+		return w.ResponseWriter.Write(data)
+	}
+
+	// Only whole lines are rewritten, so anything after the last newline is
+	// held back until the next write.
+	w.partial = append(w.partial, data...)
+	end := bytes.LastIndexByte(w.partial, '\n') + 1
+	if end == 0 {
+		return len(data), nil
+	}
+
+	var out bytes.Buffer
+	for _, line := range strings.SplitAfter(string(w.partial[:end]), "\n") {
+		out.WriteString(w.rewrite(line))
+	}
+	w.partial = append(w.partial[:0], w.partial[end:]...)
+
+	if _, err := w.ResponseWriter.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// Flush implements Flusher.
+func (w *linkCheckWriter) Flush() error {
+	return Flush(w.ResponseWriter)
+}
+
+func (w *linkCheckWriter) flush() {
+	if len(w.partial) > 0 {
+		_, _ = w.ResponseWriter.Write([]byte(w.rewrite(string(w.partial))))
+		w.partial = nil
+	}
+}
+
+func (w *linkCheckWriter) rewrite(line string) string {
+	if strings.HasPrefix(line, "```") {
+		w.preformatted = !w.preformatted
+		return line
+	}
+
+	if w.preformatted {
+		return line
+	}
+
+	link, ok := ParseLink(line)
+	if !ok {
+		return line
+	}
+
+	u, err := url.Parse(link.URL)
+	if err != nil || u.Scheme != "gemini" || u.Host == "" || u.Host == w.base.Host {
+		return line
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host += ":1965"
+	}
+
+	if !w.hosts.IsDead(host) {
+		return line
+	}
+
+	if link.Label == "" {
+		link.Label = link.URL
+	}
+	link.Label += " " + w.annotation
+
+	ending := line[len(strings.TrimRight(line, "\r\n")):]
+	return link.String() + ending
+}
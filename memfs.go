@@ -0,0 +1,287 @@
+package gemini
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memNode is a single file or directory in an in-memory file tree.
+type memNode struct {
+	name    string
+	dir     bool
+	mode    os.FileMode
+	modTime time.Time
+	size    int64
+
+	// open returns the contents of a file. It allows archives to only
+	// decompress files when they're requested.
+	open func() ([]byte, error)
+
+	children map[string]*memNode
+}
+
+func newMemDir(name string, modTime time.Time) *memNode {
+	return &memNode{
+		name:     name,
+		dir:      true,
+		mode:     os.ModeDir | 0555,
+		modTime:  modTime,
+		children: make(map[string]*memNode),
+	}
+}
+
+// memFS is a read-only FileSystem backed by a tree of memNodes. Parent
+// directories are created as needed when adding files.
+type memFS struct {
+	lock sync.RWMutex
+	root *memNode
+}
+
+func newMemFS() *memFS {
+	return &memFS{root: newMemDir("/", time.Time{})}
+}
+
+// add stores node at name, replacing anything already there. Missing parent
+// directories are synthesized. If node is a directory which already exists,
+// its metadata is updated but its children are kept.
+//
+// As on other file systems, a directory's modification time is moved forward
+// to node's when an entry is added to it, so listings cached by
+// CachingFileServer or IncludeFileServer are refreshed.
+func (fs *memFS) add(name string, node *memNode) error {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if name == "" {
+		return errors.New("gemini: cannot replace the root directory")
+	}
+
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	parent := fs.root
+	segments := strings.Split(name, "/")
+	for _, segment := range segments[:len(segments)-1] {
+		next := parent.children[segment]
+		if next == nil {
+			next = newMemDir(segment, node.modTime)
+			parent.children[segment] = next
+			parent.touch(node.modTime)
+		}
+
+		if !next.dir {
+			return &os.PathError{Op: "add", Path: name, Err: errors.New("parent is not a directory")}
+		}
+
+		parent = next
+	}
+
+	node.name = segments[len(segments)-1]
+	if old := parent.children[node.name]; old != nil && old.dir && node.dir {
+		node.children = old.children
+	}
+	parent.children[node.name] = node
+	parent.touch(node.modTime)
+
+	return nil
+}
+
+// touch moves the modification time of n forward to t. The memFS lock must be
+// held.
+func (n *memNode) touch(t time.Time) {
+	if t.After(n.modTime) {
+		n.modTime = t
+	}
+}
+
+// info returns the metadata of n. The memFS lock must be held, as modification
+// times of directories change as entries are added.
+func (n *memNode) info() memFileInfo {
+	return memFileInfo{
+		name:    n.name,
+		dir:     n.dir,
+		mode:    n.mode,
+		modTime: n.modTime,
+		size:    n.size,
+	}
+}
+
+func (fs *memFS) lookup(name string) *memNode {
+	name = strings.Trim(path.Clean("/"+name), "/")
+
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	node := fs.root
+	if name == "" {
+		return node
+	}
+
+	for _, segment := range strings.Split(name, "/") {
+		if !node.dir {
+			return nil
+		}
+
+		node = node.children[segment]
+		if node == nil {
+			return nil
+		}
+	}
+
+	return node
+}
+
+// Open implements FileSystem.
+func (fs *memFS) Open(name string) (File, error) {
+	node := fs.lookup(name)
+	if node == nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	fs.lock.RLock()
+	f := &memFile{node: node, info: node.info()}
+	if node.dir {
+		for _, child := range node.children {
+			f.entries = append(f.entries, child.info())
+		}
+	}
+	fs.lock.RUnlock()
+
+	if node.dir {
+
+		sort.Slice(f.entries, func(i, j int) bool {
+			return f.entries[i].Name() < f.entries[j].Name()
+		})
+
+		return f, nil
+	}
+
+	data, err := node.open()
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f.Reader = bytes.NewReader(data)
+
+	return f, nil
+}
+
+// memFile is an open memNode. For files, the embedded Reader holds the
+// contents.
+type memFile struct {
+	*bytes.Reader
+
+	node    *memNode
+	info    memFileInfo
+	entries []os.FileInfo
+	offset  int
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.node.dir {
+		return 0, &os.PathError{Op: "read", Path: f.node.name, Err: errors.New("is a directory")}
+	}
+	return f.Reader.Read(p)
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if f.node.dir {
+		return 0, &os.PathError{Op: "seek", Path: f.node.name, Err: errors.New("is a directory")}
+	}
+	return f.Reader.Seek(offset, whence)
+}
+
+func (f *memFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.node.dir {
+		return nil, &os.PathError{Op: "readdir", Path: f.node.name, Err: errors.New("not a directory")}
+	}
+
+	remaining := f.entries[f.offset:]
+	if count <= 0 {
+		f.offset = len(f.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	f.offset += count
+
+	return remaining[:count], nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// memFileInfo is a snapshot of the metadata of a memNode.
+type memFileInfo struct {
+	name    string
+	dir     bool
+	mode    os.FileMode
+	modTime time.Time
+	size    int64
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.dir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+// MemFS is an in-memory FileSystem. It is useful for testing FileServer
+// behavior hermetically and for serving small generated capsules without
+// touching disk. Parent directories are created automatically when adding
+// files.
+//
+// MemFS is safe for concurrent use by multiple goroutines, including adding
+// files while it is being served.
+type MemFS struct {
+	fs *memFS
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{fs: newMemFS()}
+}
+
+// Open implements FileSystem.
+func (m *MemFS) Open(name string) (File, error) {
+	return m.fs.Open(name)
+}
+
+// AddFile stores a copy of data as the file name, replacing any existing file.
+func (m *MemFS) AddFile(name string, data []byte) error {
+	data = append([]byte(nil), data...)
+
+	return m.fs.add(name, &memNode{
+		mode:    0444,
+		modTime: time.Now(),
+		size:    int64(len(data)),
+		open:    func() ([]byte, error) { return data, nil },
+	})
+}
+
+// AddString is like AddFile, but takes the contents as a string.
+func (m *MemFS) AddString(name, data string) error {
+	return m.AddFile(name, []byte(data))
+}
+
+// AddDir creates an empty directory. Adding files already creates their parent
+// directories, so this is only needed for directories which should be empty.
+func (m *MemFS) AddDir(name string) error {
+	return m.fs.add(name, newMemDir("", time.Now()))
+}
@@ -0,0 +1,255 @@
+// Package mention receives and verifies capsule-to-capsule mentions, similar
+// to webmentions.
+//
+// A capsule mentioning a page sends a request to the Receiver with the path of
+// the mentioned page and the URL of the mentioning page as the query:
+//
+//	gemini://example.com/mention/gemlog/post.gmi?gemini%3A%2F%2Fother.example%2Freply.gmi
+//
+// The Receiver fetches the source and only stores the mention if it really
+// links to the target.
+//
+//	r := &mention.Receiver{
+//		Base:  "gemini://example.com/",
+//		Store: &mention.MemoryStore{},
+//	}
+//	mux.Handle("/mention/*target", r)
+package mention
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// maxSourceSize is the most which will be read from a source page.
+const maxSourceSize = 1 << 20
+
+// ErrNoLink is returned by Verify when the source doesn't link to the target.
+var ErrNoLink = errors.New("mention: source does not link to target")
+
+// A Mention records that Source links to Target.
+type Mention struct {
+	Source string
+	Target string
+
+	// Title is the first heading of the source page, if it has one.
+	Title string
+
+	Received time.Time
+}
+
+// A Store saves verified mentions.
+type Store interface {
+	// Add saves m, replacing any existing mention with the same source and
+	// target.
+	Add(ctx context.Context, m Mention) error
+
+	// Mentions returns the mentions of target, oldest first.
+	Mentions(ctx context.Context, target string) ([]Mention, error)
+}
+
+// MemoryStore is a Store which keeps mentions in memory. The zero value is
+// ready to use.
+type MemoryStore struct {
+	mu       sync.Mutex
+	mentions map[string][]Mention
+}
+
+// Add implements Store.
+func (s *MemoryStore) Add(ctx context.Context, m Mention) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mentions == nil {
+		s.mentions = make(map[string][]Mention)
+	}
+
+	existing := s.mentions[m.Target]
+	for i, e := range existing {
+		if e.Source == m.Source {
+			existing[i] = m
+			return nil
+		}
+	}
+
+	s.mentions[m.Target] = append(existing, m)
+	return nil
+}
+
+// Mentions implements Store.
+func (s *MemoryStore) Mentions(ctx context.Context, target string) ([]Mention, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Mention(nil), s.mentions[target]...), nil
+}
+
+// Receiver is a handler which accepts mention notifications. It must be
+// mounted with a catch-all named "target", which is the path of the
+// mentioned page.
+type Receiver struct {
+	// Base is the URL the target paths are relative to, generally the root of
+	// the capsule.
+	Base string
+
+	// Store saves verified mentions. It is required.
+	Store Store
+
+	// Client is used to fetch sources. If nil, a Client with a memory TOFU
+	// store and a 30 second timeout is used, which refuses to connect to
+	// private addresses so the Receiver can't be used to probe the local
+	// network.
+	Client *gemini.Client
+
+	// AllowTarget, if set, is called with each target URL before the source
+	// is fetched, so mentions of pages which don't exist can be rejected.
+	AllowTarget func(target *url.URL) bool
+}
+
+// ServeGemini implements gemini.Handler.
+func (rc *Receiver) ServeGemini(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+	if r.URL.RawQuery == "" {
+		w.WriteStatus(gemini.StatusInput, "URL of the page mentioning this one")
+		return
+	}
+
+	source, err := url.QueryUnescape(r.URL.RawQuery)
+	if err != nil {
+		w.WriteStatus(gemini.StatusBadRequest, "invalid source URL")
+		return
+	}
+
+	base, err := url.Parse(rc.Base)
+	if err != nil {
+		w.WriteStatus(gemini.StatusCGIError, "invalid base URL")
+		return
+	}
+
+	target := base.ResolveReference(&url.URL{Path: strings.TrimPrefix(gemini.CtxParam(ctx, "target"), "/")})
+	if rc.AllowTarget != nil && !rc.AllowTarget(target) {
+		w.WriteStatus(gemini.StatusNotFound, "unknown target")
+		return
+	}
+
+	m, err := rc.Verify(ctx, source, target.String())
+	if err != nil {
+		w.WriteStatus(gemini.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := rc.Store.Add(ctx, *m); err != nil {
+		w.WriteStatus(gemini.StatusTemporaryFailure, "failed to store mention")
+		return
+	}
+
+	w.WriteStatus(gemini.StatusSuccess, "text/gemini")
+	fmt.Fprintf(w, "Thanks! Your mention of %s has been recorded.\n", m.Target)
+}
+
+// Verify fetches source and checks that it links to target. It returns the
+// mention to store if it does, or ErrNoLink if it doesn't.
+func (rc *Receiver) Verify(ctx context.Context, source, target string) (*Mention, error) {
+	sourceURL, err := gemini.ParseGeminiURL(source)
+	if err != nil {
+		return nil, err
+	}
+
+	targetURL, err := gemini.ParseGeminiURL(target)
+	if err != nil {
+		return nil, err
+	}
+
+	client := rc.Client
+	if client == nil {
+		client = &gemini.Client{
+			TOFU:      &gemini.MemoryTOFUStore{},
+			Timeout:   30 * time.Second,
+			AllowHost: gemini.DenyPrivateAddresses,
+		}
+	}
+
+	resp, err := client.GetContext(ctx, sourceURL.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !resp.IsSuccess() || !resp.IsGemtext() {
+		return nil, fmt.Errorf("mention: source returned %d %s", resp.Status, resp.Meta)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSourceSize))
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := gemini.ExtractLinks(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, link := range links {
+		ref, err := url.Parse(link.URL)
+		if err != nil {
+			continue
+		}
+
+		resolved := resp.Request.URL.ResolveReference(ref)
+		resolved.Fragment = ""
+
+		linked, err := gemini.ParseGeminiURL(resolved.String())
+		if err != nil {
+			continue
+		}
+
+		if linked.String() == targetURL.String() {
+			return &Mention{
+				Source:   sourceURL.String(),
+				Target:   targetURL.String(),
+				Title:    firstHeading(body),
+				Received: time.Now(),
+			}, nil
+		}
+	}
+
+	return nil, ErrNoLink
+}
+
+// WriteMentions renders mentions as a gemtext list of links, for including on
+// the target page.
+func WriteMentions(w io.Writer, mentions []Mention) error {
+	for _, m := range mentions {
+		label := m.Title
+		if label == "" {
+			label = m.Source
+		}
+
+		if _, err := fmt.Fprintln(w, gemini.Link{URL: m.Source, Label: label}.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func firstHeading(body []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "# ") {
+			return strings.TrimSpace(line[2:])
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,59 @@
+package gemini
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Server metrics, published by EnableMetrics. They are shared by every Server
+// in the process.
+var (
+	metricsOnce    sync.Once
+	metricsEnabled int32
+
+	metricRequests          expvar.Int
+	metricBytesServed       expvar.Int
+	metricActiveConnections expvar.Int
+	metricStatusClasses     [7]expvar.Int
+)
+
+// EnableMetrics publishes server counters with expvar under the "gemini" key.
+// The counters are the number of requests, the number of responses in each
+// status class (such as "status_2x"), the bytes of response bodies served
+// and the number of active connections.
+//
+// Metrics are only collected after EnableMetrics has been called. It is safe
+// to call more than once.
+func EnableMetrics() {
+	metricsOnce.Do(func() {
+		m := expvar.NewMap("gemini")
+		m.Set("requests", &metricRequests)
+		m.Set("bytes_served", &metricBytesServed)
+		m.Set("active_connections", &metricActiveConnections)
+		for class := 1; class <= 6; class++ {
+			m.Set("status_"+strconv.Itoa(class)+"x", &metricStatusClasses[class])
+		}
+
+		atomic.StoreInt32(&metricsEnabled, 1)
+	})
+}
+
+func metricsOn() bool {
+	return atomic.LoadInt32(&metricsEnabled) != 0
+}
+
+// recordResponse updates the metrics for a finished request.
+func recordResponse(w *responseWriter) {
+	if !metricsOn() || !w.hasWritten {
+		return
+	}
+
+	metricRequests.Add(1)
+	metricBytesServed.Add(w.bytesWritten)
+
+	if class := w.writtenStatus / 10; class >= 1 && class <= 6 {
+		metricStatusClasses[class].Add(1)
+	}
+}
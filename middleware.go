@@ -0,0 +1,72 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+)
+
+// RequireIdentity is a middleware which only calls the next handler if the
+// client presented a certificate. Otherwise it replies with
+// gemini.StatusCertificateRequired.
+//
+// It is generally used to protect a whole subtree of a ServeMux:
+//
+//	mux.Route("/admin", func(r gemini.Router) {
+//	    r.Use(gemini.RequireIdentity)
+//	    r.Handle("/", adminHandler)
+//	})
+func RequireIdentity(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		if r.Identity == nil {
+			w.WriteStatus(StatusCertificateRequired, "client certificate required")
+			return
+		}
+
+		next.ServeGemini(ctx, w, r)
+	})
+}
+
+// DefaultMeta returns a middleware which changes the meta sent when a handler
+// calls Write without calling WriteStatus first. It is generally used to add a
+// charset or lang to a subtree of a ServeMux:
+//
+//	mux.Route("/de", func(r gemini.Router) {
+//		r.Use(gemini.DefaultMeta("text/gemini; lang=de"))
+//	})
+func DefaultMeta(meta string) func(Handler) Handler {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+			next.ServeGemini(ctx, &defaultMetaWriter{ResponseWriter: w, meta: meta}, r)
+		})
+	}
+}
+
+type defaultMetaWriter struct {
+	ResponseWriter
+
+	meta       string
+	hasWritten bool
+}
+
+func (w *defaultMetaWriter) Write(data []byte) (int, error) {
+	if !w.hasWritten {
+		w.WriteStatus(StatusSuccess, w.meta)
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush implements Flusher.
+func (w *defaultMetaWriter) Flush() error {
+	return Flush(w.ResponseWriter)
+}
+
+// Hijack implements Hijacker.
+func (w *defaultMetaWriter) Hijack() (*tls.Conn, error) {
+	return Hijack(w.ResponseWriter)
+}
+
+func (w *defaultMetaWriter) WriteStatus(statusCode int, meta string) {
+	w.hasWritten = true
+	w.ResponseWriter.WriteStatus(statusCode, meta)
+}
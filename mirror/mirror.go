@@ -0,0 +1,316 @@
+// Package mirror keeps a local directory in sync with a remote capsule.
+//
+// Gemini has no conditional requests, so every page is refetched on each
+// sync, but files are only rewritten when the content hash changes, and pages
+// which disappear from the capsule are removed locally.
+//
+//	m := &mirror.Mirror{
+//		Root:  "gemini://example.com/",
+//		Dir:   "/srv/mirror/example.com",
+//		Delay: 2 * time.Second,
+//	}
+//	result, err := m.Sync(ctx)
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// StateFile is the name of the file in Mirror.Dir which records what was
+// mirrored by the last sync.
+const StateFile = ".mirror.json"
+
+// maxPageSize is the largest page which will be mirrored.
+const maxPageSize = 16 << 20
+
+// Mirror describes a capsule to mirror and where to put it.
+type Mirror struct {
+	// Client is used to fetch pages. If nil, a Client with a memory TOFU
+	// store is used.
+	Client *gemini.Client
+
+	// Root is the URL to start from. Only pages at or below its directory on
+	// the same host are mirrored.
+	Root string
+
+	// Dir is the local directory the mirror is written to.
+	Dir string
+
+	// Delay is how long to wait between requests, to avoid putting too much
+	// load on the server. If zero, one second is used.
+	Delay time.Duration
+
+	// MaxPages limits how many pages are fetched in one sync. If zero, there
+	// is no limit.
+	MaxPages int
+}
+
+// Result describes the changes made by a sync. Pages are identified by their
+// URL path.
+type Result struct {
+	Added     []string
+	Updated   []string
+	Unchanged []string
+	Deleted   []string
+
+	// Errors holds the pages which could not be fetched. Their previous local
+	// copies, if any, are kept.
+	Errors map[string]error
+}
+
+type entry struct {
+	Hash string `json:"hash"`
+	Meta string `json:"meta"`
+	File string `json:"file"`
+}
+
+// Sync fetches every page reachable from Root and updates Dir to match. Pages
+// which were mirrored before but are no longer linked, or which now return
+// gemini.StatusNotFound or gemini.StatusGone, are deleted.
+func (m *Mirror) Sync(ctx context.Context) (*Result, error) {
+	root, err := gemini.ParseGeminiURL(m.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := root.Path[:strings.LastIndex(root.Path, "/")+1]
+
+	client := m.Client
+	if client == nil {
+		client = &gemini.Client{TOFU: &gemini.MemoryTOFUStore{}}
+	}
+
+	delay := m.Delay
+	if delay == 0 {
+		delay = time.Second
+	}
+
+	old, err := m.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	state := make(map[string]entry)
+	result := &Result{Errors: make(map[string]error)}
+
+	queue := []*url.URL{root}
+	seen := map[string]bool{root.Path: true}
+	fetched := 0
+
+	for len(queue) > 0 {
+		if m.MaxPages > 0 && fetched >= m.MaxPages {
+			break
+		}
+
+		u := queue[0]
+		queue = queue[1:]
+
+		if fetched > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		fetched++
+
+		resp, err := client.GetContext(ctx, u.String())
+		if err != nil {
+			result.Errors[u.Path] = err
+			keepEntry(state, old, u.Path)
+			continue
+		}
+
+		if resp.Status == gemini.StatusNotFound || resp.Status == gemini.StatusGone {
+			_ = resp.Body.Close()
+			continue
+		}
+
+		if !resp.IsSuccess() {
+			_ = resp.Body.Close()
+			result.Errors[u.Path] = fmt.Errorf("mirror: %d %s", resp.Status, resp.Meta)
+			keepEntry(state, old, u.Path)
+			continue
+		}
+
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPageSize+1))
+		_ = resp.Body.Close()
+		if err == nil && len(body) > maxPageSize {
+			err = errors.New("mirror: page too large")
+		}
+		if err != nil {
+			result.Errors[u.Path] = err
+			keepEntry(state, old, u.Path)
+			continue
+		}
+
+		sum := sha256.Sum256(body)
+		e := entry{
+			Hash: hex.EncodeToString(sum[:]),
+			Meta: resp.Meta,
+			File: localFile(scope, u.Path),
+		}
+
+		prev, existed := old[u.Path]
+		switch {
+		case existed && prev.Hash == e.Hash && prev.File == e.File:
+			result.Unchanged = append(result.Unchanged, u.Path)
+		default:
+			if err := m.writeFile(e.File, body); err != nil {
+				return nil, err
+			}
+
+			if existed {
+				result.Updated = append(result.Updated, u.Path)
+			} else {
+				result.Added = append(result.Added, u.Path)
+			}
+		}
+		state[u.Path] = e
+
+		if !resp.IsGemtext() {
+			continue
+		}
+
+		links, _ := gemini.ExtractLinks(strings.NewReader(string(body)))
+		for _, link := range links {
+			next, ok := inScope(resp.Request.URL, link.URL, root.Host, scope)
+			if !ok || seen[next.Path] {
+				continue
+			}
+
+			seen[next.Path] = true
+			queue = append(queue, next)
+		}
+	}
+
+	// Anything which wasn't seen this time has been removed from the capsule.
+	// If the sync stopped early because of MaxPages, the rest are kept.
+	for p, e := range old {
+		if _, ok := state[p]; ok {
+			continue
+		}
+
+		if len(queue) > 0 && !seenFetched(seen, queue, p) {
+			state[p] = e
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(m.Dir, e.File)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		result.Deleted = append(result.Deleted, p)
+	}
+
+	sort.Strings(result.Deleted)
+
+	return result, m.saveState(state)
+}
+
+// seenFetched reports whether p was fetched, rather than still waiting in the
+// queue or never found.
+func seenFetched(seen map[string]bool, queue []*url.URL, p string) bool {
+	if !seen[p] {
+		return false
+	}
+
+	for _, u := range queue {
+		if u.Path == p {
+			return false
+		}
+	}
+
+	return true
+}
+
+func keepEntry(state, old map[string]entry, p string) {
+	if e, ok := old[p]; ok {
+		state[p] = e
+	}
+}
+
+// inScope resolves a link against base, returning it if it should be mirrored.
+func inScope(base *url.URL, link, host, scope string) (*url.URL, bool) {
+	ref, err := url.Parse(link)
+	if err != nil {
+		return nil, false
+	}
+
+	u := base.ResolveReference(ref)
+	u.Fragment = ""
+
+	// Pages with queries are generally input or search results.
+	if u.Scheme != "gemini" || u.Host != host || u.RawQuery != "" {
+		return nil, false
+	}
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u, strings.HasPrefix(u.Path, scope)
+}
+
+// localFile maps a URL path to a file relative to the mirror directory.
+// Directory URLs are stored as index.gmi.
+func localFile(scope, urlPath string) string {
+	rel := strings.TrimPrefix(urlPath, scope)
+	if rel == "" || strings.HasSuffix(rel, "/") {
+		rel += "index.gmi"
+	}
+
+	return filepath.FromSlash(strings.TrimPrefix(path.Clean("/"+rel), "/"))
+}
+
+func (m *Mirror) writeFile(name string, data []byte) error {
+	full := filepath.Join(m.Dir, name)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+
+	tmp := full + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, full)
+}
+
+func (m *Mirror) loadState() (map[string]entry, error) {
+	state := make(map[string]entry)
+
+	data, err := ioutil.ReadFile(filepath.Join(m.Dir, StateFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return state, json.Unmarshal(data, &state)
+}
+
+func (m *Mirror) saveState(state map[string]entry) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return m.writeFile(StateFile, data)
+}
@@ -0,0 +1,282 @@
+// Package monitor periodically probes Gemini URLs and keeps a history of the
+// results, which can be shown as a gemtext status page.
+//
+//	m := &monitor.Monitor{
+//		URLs:     []string{"gemini://example.com/"},
+//		Interval: 5 * time.Minute,
+//	}
+//	go m.Run(ctx)
+//
+//	mux.Handle("/status", m.Handler("Status"))
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// A Result is the outcome of checking a URL once.
+type Result struct {
+	URL  string
+	Time time.Time
+
+	// Status and Meta are from the final response, after redirects. They
+	// are zero if the request failed, in which case Err is set.
+	Status int
+	Meta   string
+	Err    string
+
+	// Latency is how long it took to receive the whole response.
+	Latency time.Duration
+
+	// CertExpires is when the server's certificate expires, if the request
+	// got far enough to see it.
+	CertExpires time.Time
+}
+
+// Up reports whether the check succeeded. Any response other than a temporary
+// or permanent failure counts.
+func (r Result) Up() bool {
+	return r.Err == "" && r.Status >= gemini.StatusInput && r.Status < gemini.StatusTemporaryFailure
+}
+
+// A Store keeps the history of results.
+type Store interface {
+	// Record saves a result.
+	Record(ctx context.Context, r Result) error
+
+	// History returns up to limit of the most recent results for url, oldest
+	// first.
+	History(ctx context.Context, url string, limit int) ([]Result, error)
+}
+
+// MemoryStore is a Store which keeps results in memory. The zero value is
+// ready to use.
+type MemoryStore struct {
+	// MaxResults is how many results are kept per URL. If zero, 1000 are
+	// kept.
+	MaxResults int
+
+	mu      sync.Mutex
+	results map[string][]Result
+}
+
+// Record implements Store.
+func (s *MemoryStore) Record(ctx context.Context, r Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.results == nil {
+		s.results = make(map[string][]Result)
+	}
+
+	max := s.MaxResults
+	if max == 0 {
+		max = 1000
+	}
+
+	results := append(s.results[r.URL], r)
+	if len(results) > max {
+		results = append([]Result(nil), results[len(results)-max:]...)
+	}
+	s.results[r.URL] = results
+
+	return nil
+}
+
+// History implements Store.
+func (s *MemoryStore) History(ctx context.Context, url string, limit int) ([]Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := s.results[url]
+	if limit > 0 && len(results) > limit {
+		results = results[len(results)-limit:]
+	}
+
+	return append([]Result(nil), results...), nil
+}
+
+// Monitor checks a list of URLs on a schedule.
+type Monitor struct {
+	// URLs is the list of URLs to check. It must not be modified while Run is
+	// in progress.
+	URLs []string
+
+	// Client is used for the checks. If nil, a Client with a memory TOFU
+	// store and a 30 second timeout is used.
+	Client *gemini.Client
+
+	// Interval is how often the URLs are checked. If zero, five minutes is
+	// used.
+	Interval time.Duration
+
+	// Store keeps the results. If nil, a MemoryStore is used.
+	Store Store
+
+	// OnChange, if set, is called when a URL goes up or down. prev is the
+	// previous result and cur is the new one.
+	OnChange func(prev, cur Result)
+
+	initOnce sync.Once
+	client   *gemini.Client
+	store    Store
+
+	mu   sync.Mutex
+	last map[string]Result
+}
+
+func (m *Monitor) init() {
+	m.initOnce.Do(func() {
+		m.client = m.Client
+		if m.client == nil {
+			m.client = &gemini.Client{TOFU: &gemini.MemoryTOFUStore{}, Timeout: 30 * time.Second}
+		}
+
+		m.store = m.Store
+		if m.store == nil {
+			m.store = &MemoryStore{}
+		}
+
+		m.last = make(map[string]Result)
+	})
+}
+
+// Run checks all URLs immediately and then every Interval until ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	interval := m.Interval
+	if interval == 0 {
+		interval = 5 * time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.CheckAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks every URL once, concurrently, and records the results.
+func (m *Monitor) CheckAll(ctx context.Context) {
+	m.init()
+
+	var wg sync.WaitGroup
+	for _, u := range m.URLs {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			m.record(ctx, m.Check(ctx, u))
+		}(u)
+	}
+	wg.Wait()
+}
+
+// Check checks a single URL without recording the result.
+func (m *Monitor) Check(ctx context.Context, url string) Result {
+	m.init()
+
+	result := Result{URL: url, Time: time.Now()}
+
+	resp, err := m.client.GetContext(ctx, url)
+	if resp != nil && resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		result.CertExpires = resp.TLS.PeerCertificates[0].NotAfter
+	}
+	if err != nil {
+		result.Err = err.Error()
+		result.Latency = time.Since(result.Time)
+		return result
+	}
+
+	result.Status = resp.Status
+	result.Meta = resp.Meta
+
+	// The body is read so the latency covers the whole response, but only
+	// up to a limit, as some URLs may be streams.
+	_, err = io.CopyN(ioutil.Discard, resp.Body, 1<<20)
+	if err != nil && err != io.EOF {
+		result.Err = err.Error()
+	}
+	_ = resp.Body.Close()
+
+	result.Latency = time.Since(result.Time)
+
+	return result
+}
+
+func (m *Monitor) record(ctx context.Context, r Result) {
+	_ = m.store.Record(ctx, r)
+
+	m.mu.Lock()
+	prev, ok := m.last[r.URL]
+	m.last[r.URL] = r
+	m.mu.Unlock()
+
+	if ok && prev.Up() != r.Up() && m.OnChange != nil {
+		m.OnChange(prev, r)
+	}
+}
+
+// Handler returns a handler which renders the latest result and recent uptime
+// of every URL as gemtext.
+func (m *Monitor) Handler(title string) gemini.Handler {
+	return gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		m.init()
+
+		w.WriteStatus(gemini.StatusSuccess, "text/gemini; charset=utf-8")
+		if title != "" {
+			fmt.Fprintf(w, "# %s\n", title)
+		}
+
+		for _, u := range m.URLs {
+			history, err := m.store.History(ctx, u, 100)
+			if err != nil {
+				continue
+			}
+
+			fmt.Fprintf(w, "\n## %s\n\n", u)
+			fmt.Fprintln(w, gemini.Link{URL: u}.String())
+
+			if len(history) == 0 {
+				fmt.Fprintln(w, "* Not checked yet")
+				continue
+			}
+
+			last := history[len(history)-1]
+			if last.Up() {
+				fmt.Fprintf(w, "* Up: %d %s\n", last.Status, last.Meta)
+			} else if last.Err != "" {
+				fmt.Fprintf(w, "* Down: %s\n", last.Err)
+			} else {
+				fmt.Fprintf(w, "* Down: %d %s\n", last.Status, last.Meta)
+			}
+
+			fmt.Fprintf(w, "* Checked: %s\n", last.Time.UTC().Format(time.RFC3339))
+			fmt.Fprintf(w, "* Latency: %s\n", last.Latency.Round(time.Millisecond))
+
+			if !last.CertExpires.IsZero() {
+				fmt.Fprintf(w, "* Certificate expires: %s\n", last.CertExpires.UTC().Format("2006-01-02"))
+			}
+
+			up := 0
+			for _, result := range history {
+				if result.Up() {
+					up++
+				}
+			}
+			fmt.Fprintf(w, "* Uptime: %.1f%% over the last %d checks\n", float64(up)*100/float64(len(history)), len(history))
+		}
+	})
+}
@@ -12,7 +12,19 @@ import "context"
 // into many smaller parts composed of middlewares and end handlers.
 type ServeMux struct {
 	RedirectSlash bool
-	root          *node
+
+	// CaseInsensitive enables matching static path segments regardless of
+	// case. When a request only matches after folding, the client is sent a
+	// gemini.StatusPermanentRedirect to the path as it was registered.
+	CaseInsensitive bool
+
+	// Normalize, if set, is applied to both request and route path segments
+	// before comparing them, redirecting in the same way as CaseInsensitive.
+	// This is generally used with a unicode normalization form, such as
+	// norm.NFC.String from golang.org/x/text/unicode/norm.
+	Normalize func(string) string
+
+	root *node
 }
 
 // NewServeMux returns a newly initialized ServeMux object that implements the
@@ -29,7 +41,9 @@ func NewServeMux() *ServeMux {
 	return s
 }
 
-// ServeGemini implements the gemini.Handler interface.
+// ServeGemini implements the gemini.Handler interface. Routing a request to a
+// static route takes four allocations, and routes with URL params take a few
+// more.
 func (mux *ServeMux) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
 	mux.root.ServeGemini(ctx, w, r)
 }
@@ -45,15 +59,25 @@ func (mux *ServeMux) NotFound(handler Handler) {
 	mux.root.NotFound(handler)
 }
 
-// Route effectively defines a new subrouter, mounted to `pattern`.
+// Route effectively defines a new subrouter, mounted to `pattern`. Any
+// middlewares registered on the subrouter with Use apply to every handler
+// mounted beneath it.
 func (mux *ServeMux) Route(pattern string, fn func(r Router)) Router {
 	return mux.root.Route(pattern, fn)
 }
 
+// Use appends one or more middlewares to the ServeMux. They are applied to
+// every matched handler, including the NotFound handler. Handlers are wrapped
+// when they or the middlewares are registered, not on every request.
+func (mux *ServeMux) Use(middlewares ...func(Handler) Handler) {
+	mux.root.Use(middlewares...)
+}
+
 // Router consisting of the core routing methods used by ServeMux.
 type Router interface {
 	Handler
 	Handle(pattern string, h Handler)
 	NotFound(h Handler)
 	Route(pattern string, fn func(r Router)) Router
+	Use(middlewares ...func(Handler) Handler)
 }
@@ -0,0 +1,78 @@
+package gemini
+
+import (
+	"context"
+	"testing"
+)
+
+func newBenchMux() *ServeMux {
+	noop := HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {})
+
+	mux := NewServeMux()
+	mux.Handle("/", noop)
+	mux.Handle("/about", noop)
+	mux.Handle("/docs/guide/install", noop)
+	mux.Handle("/users/:id", noop)
+	mux.Handle("/users/:id/posts/:post", noop)
+	mux.Handle("/files/*path", noop)
+
+	return mux
+}
+
+func benchmarkServeMux(b *testing.B, rawURL string) {
+	mux := newBenchMux()
+	r, err := NewRequest(rawURL)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		mux.ServeGemini(ctx, nil, r)
+	}
+}
+
+func BenchmarkServeMuxStatic(b *testing.B) {
+	benchmarkServeMux(b, "gemini://example.com/docs/guide/install")
+}
+
+func BenchmarkServeMuxParams(b *testing.B) {
+	benchmarkServeMux(b, "gemini://example.com/users/42/posts/7")
+}
+
+func BenchmarkServeMuxCatchAll(b *testing.B) {
+	benchmarkServeMux(b, "gemini://example.com/files/a/b/")
+}
+
+func TestServeMuxAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not counted reliably with the race detector")
+	}
+
+	mux := newBenchMux()
+	ctx := context.Background()
+
+	tests := []struct {
+		url    string
+		allocs float64
+	}{
+		{"gemini://example.com/docs/guide/install", 4},
+		{"gemini://example.com/users/42/posts/7", 8},
+		{"gemini://example.com/files/a/b/", 9},
+	}
+
+	for _, tt := range tests {
+		r, err := NewRequest(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		allocs := testing.AllocsPerRun(100, func() {
+			mux.ServeGemini(ctx, nil, r)
+		})
+		if allocs > tt.allocs {
+			t.Errorf("routing %s made %v allocations, want at most %v", tt.url, allocs, tt.allocs)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package gemini
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ReverseProxy is a Handler which forwards requests to another Gemini server
+// and relays its responses. The request path, which generally has the mount
+// point stripped, is appended to the path of Target, and the query is passed
+// through. Redirects are relayed to the client rather than followed.
+//
+//	target, _ := url.Parse("gemini://localhost:1966/")
+//	mux.Handle("/app/*path", gemini.StripPrefix("/app", &gemini.ReverseProxy{Target: target}))
+type ReverseProxy struct {
+	Target *url.URL
+
+	// Client is used to make the upstream requests. If nil, the default
+	// client is used.
+	Client *Client
+
+	// MaxBodySize and Timeout limit the relayed response, as described by
+	// RelayBody.
+	MaxBodySize int64
+	Timeout     time.Duration
+}
+
+// ServeGemini implements Handler.
+func (p *ReverseProxy) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
+	target := *p.Target
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + strings.TrimPrefix(r.URL.Path, "/")
+	target.RawPath = ""
+	target.RawQuery = r.URL.RawQuery
+
+	client := p.Client
+	if client == nil {
+		client = defaultClient()
+	}
+
+	resp, err := client.roundTrip(ctx, NewRequestURL(&target))
+	if err != nil {
+		w.WriteStatus(StatusProxyError, "upstream request failed")
+		return
+	}
+
+	if err := RelayBody(w, resp, p.MaxBodySize, p.Timeout); err != nil {
+		panic(ErrAbortHandler)
+	}
+}
+
+// RelayBody writes the status and meta of resp to w and, for success
+// responses, streams the body after it. It is meant for handlers which proxy
+// responses from another server.
+//
+// If maxBytes is greater than zero, at most maxBytes of the body are relayed
+// before ErrBodyTooLarge is returned. If maxDuration is greater than zero, the
+// upstream body is closed once it has elapsed and ErrRelayTimeout is returned.
+// In either case the client will have seen a truncated body, so handlers should
+// generally abort by panicking with ErrAbortHandler.
+//
+// resp.Body is always closed when RelayBody returns.
+func RelayBody(w ResponseWriter, resp *Response, maxBytes int64, maxDuration time.Duration) error {
+	defer resp.Body.Close()
+
+	w.WriteStatus(resp.Status, resp.Meta)
+	if !resp.IsSuccess() {
+		return nil
+	}
+
+	var timedOut int32
+	if maxDuration > 0 {
+		timer := time.AfterFunc(maxDuration, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			_ = resp.Body.Close()
+		})
+		defer timer.Stop()
+	}
+
+	var err error
+	if maxBytes > 0 {
+		var relayed int64
+		relayed, err = copyBuffer(w, io.LimitReader(resp.Body, maxBytes))
+		if err == nil && relayed == maxBytes {
+			// We've relayed exactly maxBytes, so make sure there's nothing
+			// left before declaring success.
+			var extra [1]byte
+			var n int
+			n, err = io.ReadFull(resp.Body, extra[:])
+			if n > 0 {
+				err = ErrBodyTooLarge
+			}
+		}
+		if err == io.EOF {
+			err = nil
+		}
+	} else {
+		_, err = copyBuffer(w, resp.Body)
+	}
+
+	if atomic.LoadInt32(&timedOut) != 0 {
+		return ErrRelayTimeout
+	}
+
+	return err
+}
@@ -0,0 +1,168 @@
+package gemini
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// proxyV2Signature starts every version 2 PROXY protocol header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyV1MaxLength is the longest version 1 header allowed by the spec,
+// including the CRLF.
+const proxyV1MaxLength = 107
+
+// proxyConn is a connection which starts with a HAProxy PROXY protocol
+// header, as sent by load balancers. Once readHeader has been called, its
+// RemoteAddr and LocalAddr are those of the original connection, as reported
+// in the header.
+type proxyConn struct {
+	net.Conn
+
+	r      *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func newProxyConn(c net.Conn) *proxyConn {
+	return &proxyConn{Conn: c, r: bufio.NewReader(c)}
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader reads the PROXY protocol header, in either version, from the
+// start of the connection. Headers for health checks from the proxy itself,
+// and for protocols it can't describe, leave the addresses unchanged.
+func (c *proxyConn) readHeader() error {
+	sig, err := c.r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(sig, proxyV2Signature) {
+		return c.readV2()
+	}
+
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return c.readV1()
+	}
+
+	return fmt.Errorf("%w: missing header", ErrInvalidProxyHeader)
+}
+
+func (c *proxyConn) readV1() error {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > proxyV1MaxLength {
+		return fmt.Errorf("%w: header too long", ErrInvalidProxyHeader)
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return fmt.Errorf("%w: missing CRLF", ErrInvalidProxyHeader)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+	}
+
+	remote, err := parseProxyAddr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	local, err := parseProxyAddr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+
+	c.remote, c.local = remote, local
+	return nil
+}
+
+func parseProxyAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("%w: bad address %s:%s", ErrInvalidProxyHeader, host, port)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func (c *proxyConn) readV2() error {
+	var hdr [16]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return err
+	}
+
+	if hdr[12]>>4 != 2 {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidProxyHeader, hdr[12]>>4)
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+
+	// LOCAL connections come from the proxy itself, such as health checks.
+	switch hdr[12] & 0xf {
+	case 0:
+		return nil
+	case 1:
+	default:
+		return fmt.Errorf("%w: unsupported command %d", ErrInvalidProxyHeader, hdr[12]&0xf)
+	}
+
+	var size int
+	switch hdr[13] >> 4 {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		// Unix sockets and unspecified families carry no useful client
+		// address.
+		return nil
+	}
+
+	if len(data) < 2*size+4 {
+		return fmt.Errorf("%w: short address block", ErrInvalidProxyHeader)
+	}
+
+	c.remote = &net.TCPAddr{
+		IP:   net.IP(data[:size]),
+		Port: int(binary.BigEndian.Uint16(data[2*size:])),
+	}
+	c.local = &net.TCPAddr{
+		IP:   net.IP(data[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(data[2*size+2:])),
+	}
+
+	return nil
+}
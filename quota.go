@@ -0,0 +1,190 @@
+package gemini
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A QuotaStore records usage for Quota. Implementations must be safe for
+// concurrent use, and may be backed by a shared database so quotas apply
+// across several servers.
+type QuotaStore interface {
+	// Add adds requests and bytes to the usage of key in the period ending
+	// at end, and returns the new totals for that period. Usage from earlier
+	// periods is not included.
+	Add(key string, end time.Time, requests, bytes int64) (totalRequests, totalBytes int64, err error)
+}
+
+// MemoryQuotaStore is a QuotaStore which keeps usage in memory. Usage from
+// finished periods is discarded. The zero value is ready to use.
+type MemoryQuotaStore struct {
+	mu    sync.Mutex
+	usage map[string]*quotaUsage
+	adds  int
+}
+
+type quotaUsage struct {
+	end      time.Time
+	requests int64
+	bytes    int64
+}
+
+// memoryQuotaSweepInterval is how many calls to Add there are between sweeps
+// for finished periods.
+const memoryQuotaSweepInterval = 1024
+
+// Add implements QuotaStore.
+func (s *MemoryQuotaStore) Add(key string, end time.Time, requests, bytes int64) (int64, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.usage == nil {
+		s.usage = make(map[string]*quotaUsage)
+	}
+
+	s.adds++
+	if s.adds%memoryQuotaSweepInterval == 0 {
+		now := time.Now()
+		for k, u := range s.usage {
+			if !u.end.After(now) {
+				delete(s.usage, k)
+			}
+		}
+	}
+
+	u, ok := s.usage[key]
+	if !ok || !u.end.Equal(end) {
+		u = &quotaUsage{end: end}
+		s.usage[key] = u
+	}
+
+	u.requests += requests
+	u.bytes += bytes
+
+	return u.requests, u.bytes, nil
+}
+
+// Quota is a middleware which limits how many requests and response body
+// bytes each client may use in a period. Clients over the request limit get
+// gemini.StatusSlowDown with the number of seconds until the period ends, and
+// clients over the byte limit get gemini.StatusServerUnavailable.
+//
+//	quota := &gemini.Quota{MaxRequests: 1000, MaxBytes: 100 << 20}
+//	mux.Use(quota.Middleware)
+type Quota struct {
+	// Store records usage. If nil, a MemoryQuotaStore is used.
+	Store QuotaStore
+
+	// Key returns the key usage is recorded against. Requests for which it
+	// returns an empty string aren't limited. If nil, QuotaByClient is used.
+	Key func(ctx context.Context, r *Request) string
+
+	// Period is the length of each quota period. Periods are aligned to
+	// multiples of Period since the zero time, so a Period of 24 hours
+	// starts at midnight UTC. If zero, 24 hours is used.
+	Period time.Duration
+
+	// MaxRequests and MaxBytes are the limits for each period. If zero,
+	// there is no limit.
+	MaxRequests int64
+	MaxBytes    int64
+
+	once  sync.Once
+	store QuotaStore
+}
+
+// QuotaByClient keys usage by the fingerprint of the client certificate, if
+// there is one, and otherwise by the client's IP.
+func QuotaByClient(ctx context.Context, r *Request) string {
+	if r.Identity != nil {
+		return "cert:" + Fingerprint(r.Identity)
+	}
+
+	if ip := remoteIP(ctx); ip != "" {
+		return "ip:" + ip
+	}
+
+	return ""
+}
+
+// QuotaByHost keys usage by the requested hostname, so each capsule served by
+// a Server has its own quota.
+func QuotaByHost(ctx context.Context, r *Request) string {
+	return "host:" + r.URL.Hostname()
+}
+
+// Middleware wraps next with the quota. It can be passed to Router.Use.
+func (q *Quota) Middleware(next Handler) Handler {
+	q.once.Do(func() {
+		q.store = q.Store
+		if q.store == nil {
+			q.store = &MemoryQuotaStore{}
+		}
+	})
+
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		keyFunc := q.Key
+		if keyFunc == nil {
+			keyFunc = QuotaByClient
+		}
+
+		key := keyFunc(ctx, r)
+		if key == "" {
+			next.ServeGemini(ctx, w, r)
+			return
+		}
+
+		period := q.Period
+		if period <= 0 {
+			period = 24 * time.Hour
+		}
+
+		now := time.Now()
+		end := now.Truncate(period).Add(period)
+
+		requests, bytes, err := q.store.Add(key, end, 1, 0)
+		if err != nil {
+			w.WriteStatus(StatusTemporaryFailure, "Unable to check quota")
+			return
+		}
+
+		if q.MaxBytes > 0 && bytes >= q.MaxBytes {
+			w.WriteStatus(StatusServerUnavailable, "Quota exceeded")
+			return
+		}
+
+		if q.MaxRequests > 0 && requests > q.MaxRequests {
+			w.WriteStatus(StatusSlowDown, strconv.Itoa(int(end.Sub(now).Seconds())+1))
+			return
+		}
+
+		if q.MaxBytes <= 0 {
+			next.ServeGemini(ctx, w, r)
+			return
+		}
+
+		cw := &quotaWriter{ResponseWriter: w}
+		next.ServeGemini(ctx, cw, r)
+
+		_, _, _ = q.store.Add(key, end, 0, cw.bytes)
+	})
+}
+
+type quotaWriter struct {
+	ResponseWriter
+
+	bytes int64
+}
+
+func (w *quotaWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements Flusher.
+func (w *quotaWriter) Flush() error {
+	return Flush(w.ResponseWriter)
+}
@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package gemini
+
+// raceEnabled is set when testing with the race detector, which makes
+// sync.Pool drop items at random, so allocation counts aren't meaningful.
+const raceEnabled = true
@@ -0,0 +1,152 @@
+package gemini
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is a middleware which limits how quickly each client can make
+// requests, using a token bucket per client IP. Clients which exceed the
+// limit get gemini.StatusSlowDown, with the number of seconds until their
+// next request will be allowed as the meta.
+//
+//	limit := &gemini.RateLimit{Rate: 2, Burst: 20}
+//	mux.Use(limit.Middleware)
+//
+// The zero value allows a burst of 10 requests, refilled at one request a
+// second.
+type RateLimit struct {
+	// Rate is how many requests per second each client is allowed on
+	// average. If zero, 1 is used.
+	Rate float64
+
+	// Burst is how many requests a client may make at once after being
+	// idle. If zero, 10 is used.
+	Burst int
+
+	// Key returns the key requests are limited by. Requests for which it
+	// returns an empty string aren't limited. If nil, the client's IP is
+	// used, or for ClientMiddleware, the host of the request URL.
+	Key func(ctx context.Context, r *Request) string
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	checks  int
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func (l *RateLimit) rate() float64 {
+	if l.Rate > 0 {
+		return l.Rate
+	}
+	return 1
+}
+
+func (l *RateLimit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return 10
+}
+
+// Middleware wraps next with the rate limit. It can be passed to Router.Use.
+func (l *RateLimit) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		var key string
+		if l.Key != nil {
+			key = l.Key(ctx, r)
+		} else {
+			key = remoteIP(ctx)
+		}
+
+		if key != "" {
+			if wait := l.take(key, time.Now()); wait > 0 {
+				seconds := int(math.Ceil(wait.Seconds()))
+				w.WriteStatus(StatusSlowDown, strconv.Itoa(seconds))
+				return
+			}
+		}
+
+		next.ServeGemini(ctx, w, r)
+	})
+}
+
+// ClientMiddleware applies the rate limit to the requests a Client makes, so
+// it can be passed to Client.Use. Rather than failing, requests over the
+// limit wait until they are allowed, or until their context is done.
+func (l *RateLimit) ClientMiddleware(next RoundTripper) RoundTripper {
+	return RoundTripperFunc(func(ctx context.Context, r *Request) (*Response, error) {
+		key := r.URL.Host
+		if l.Key != nil {
+			key = l.Key(ctx, r)
+		}
+
+		if key != "" {
+			for {
+				wait := l.take(key, time.Now())
+				if wait <= 0 {
+					break
+				}
+
+				if !sleepContext(ctx, wait) {
+					return nil, ctx.Err()
+				}
+			}
+		}
+
+		return next.RoundTrip(ctx, r)
+	})
+}
+
+// take removes a token from the bucket for key. If there isn't one, it
+// returns how long until there will be.
+func (l *RateLimit) take(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	l.sweepLocked(now)
+
+	rate, burst := l.rate(), l.burst()
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+
+	b.tokens--
+	return 0
+}
+
+// sweepLocked occasionally discards buckets which would have refilled, since
+// they're the same as a new bucket.
+func (l *RateLimit) sweepLocked(now time.Time) {
+	l.checks++
+	if l.checks%1024 != 0 {
+		return
+	}
+
+	full := time.Duration(l.burst() / l.rate() * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
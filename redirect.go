@@ -0,0 +1,167 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// RedirectPolicy validates and normalizes the targets of redirects written by
+// handlers. Its Middleware checks every redirect passing through it, and
+// Check can be called directly by handlers which build redirect targets.
+//
+//	policy := &gemini.RedirectPolicy{Absolute: true, RejectOpenRedirects: true}
+//	mux.Use(policy.Middleware)
+//
+// Targets may be relative, in which case they are resolved against the
+// request URL before they are checked. The zero value only allows gemini
+// targets no longer than MaxURLLength.
+type RedirectPolicy struct {
+	// Absolute makes Check return targets as absolute URLs, for clients
+	// which don't resolve relative redirects. Otherwise targets are
+	// returned as they were written, once they have been checked.
+	Absolute bool
+
+	// Schemes are the schemes targets may use. If empty, only gemini is
+	// allowed.
+	Schemes []string
+
+	// RejectOpenRedirects rejects redirects to other hosts whose target
+	// appears in the request URL, as these are usually a handler passing
+	// user input straight through, which anyone could use to send visitors
+	// to a host of their choice.
+	RejectOpenRedirects bool
+
+	// SameHost rejects every redirect to another host. Hosts listed in
+	// AllowedHosts are still allowed.
+	SameHost     bool
+	AllowedHosts []string
+}
+
+// Check validates target, the meta of a redirect in reply to r, and returns
+// it normalized. The error wraps ErrInvalidRedirect, or ErrOpenRedirect if
+// the target was rejected by RejectOpenRedirects or SameHost.
+func (p *RedirectPolicy) Check(r *Request, target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("%w: empty target", ErrInvalidRedirect)
+	}
+
+	if strings.ContainsAny(target, "\r\n") {
+		return "", fmt.Errorf("%w: line break in target", ErrInvalidRedirect)
+	}
+
+	ref, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+	}
+
+	u := r.URL.ResolveReference(ref)
+	if !p.allowedScheme(u.Scheme) {
+		return "", fmt.Errorf("%w: scheme %q is not allowed", ErrInvalidRedirect, u.Scheme)
+	}
+
+	if u.Scheme == "gemini" {
+		// Fragments are never sent in requests, so they are dropped rather
+		// than rejected.
+		u.Fragment = ""
+		if err := NormalizeURL(u); err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+		}
+	}
+
+	if u.Host != "" && !strings.EqualFold(u.Hostname(), r.URL.Hostname()) && !p.allowedHost(u.Hostname()) {
+		if p.SameHost {
+			return "", fmt.Errorf("%w: %s is another host", ErrOpenRedirect, u.Hostname())
+		}
+
+		if p.RejectOpenRedirects && fromRequest(r.URL, target) {
+			return "", fmt.Errorf("%w: target %q comes from the request", ErrOpenRedirect, target)
+		}
+	}
+
+	ret := target
+	if p.Absolute || (ref.IsAbs() && u.Scheme == "gemini") {
+		ret = u.String()
+	}
+
+	if len(ret) > MaxURLLength {
+		return "", fmt.Errorf("%w: target is longer than %d bytes", ErrInvalidRedirect, MaxURLLength)
+	}
+
+	return ret, nil
+}
+
+func (p *RedirectPolicy) allowedScheme(scheme string) bool {
+	if len(p.Schemes) == 0 {
+		return scheme == "gemini"
+	}
+
+	for _, s := range p.Schemes {
+		if strings.EqualFold(s, scheme) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *RedirectPolicy) allowedHost(host string) bool {
+	for _, h := range p.AllowedHosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fromRequest reports whether target appears in the path or query of u,
+// either as it was sent or unescaped.
+func fromRequest(u *url.URL, target string) bool {
+	for _, s := range []string{u.RawQuery, u.EscapedPath()} {
+		if strings.Contains(s, target) {
+			return true
+		}
+
+		if unescaped, err := url.QueryUnescape(s); err == nil && strings.Contains(unescaped, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware returns next wrapped with the policy. Redirects which fail Check
+// are replaced with gemini.StatusPermanentFailure.
+func (p *RedirectPolicy) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		next.ServeGemini(ctx, &redirectWriter{ResponseWriter: w, policy: p, r: r}, r)
+	})
+}
+
+type redirectWriter struct {
+	ResponseWriter
+
+	policy *RedirectPolicy
+	r      *Request
+}
+
+func (w *redirectWriter) WriteStatus(statusCode int, meta string) {
+	if statusCode >= StatusRedirect && statusCode < StatusTemporaryFailure {
+		target, err := w.policy.Check(w.r, meta)
+		if err != nil {
+			w.ResponseWriter.WriteStatus(StatusPermanentFailure, "Invalid redirect")
+			return
+		}
+
+		meta = target
+	}
+
+	w.ResponseWriter.WriteStatus(statusCode, meta)
+}
+
+// Flush implements Flusher.
+func (w *redirectWriter) Flush() error {
+	return Flush(w.ResponseWriter)
+}
@@ -0,0 +1,165 @@
+package gemini
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A Profile is what a Server knows about a registered client certificate.
+type Profile struct {
+	Fingerprint string
+	Name        string
+	Registered  time.Time
+}
+
+// A ProfileStore keeps the profiles created by Registration. Implementations
+// must be safe for concurrent use.
+type ProfileStore interface {
+	// Lookup returns the profile for the certificate with the given
+	// fingerprint, if it has been registered.
+	Lookup(fingerprint string) (Profile, bool, error)
+
+	// Save creates or replaces a profile.
+	Save(profile Profile) error
+}
+
+// MemoryProfileStore is a ProfileStore which only keeps profiles in memory.
+// Its zero value is ready to use.
+type MemoryProfileStore struct {
+	lock     sync.RWMutex
+	profiles map[string]Profile
+}
+
+// Lookup implements ProfileStore.
+func (s *MemoryProfileStore) Lookup(fingerprint string) (Profile, bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	profile, ok := s.profiles[fingerprint]
+	return profile, ok, nil
+}
+
+// Save implements ProfileStore.
+func (s *MemoryProfileStore) Save(profile Profile) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.profiles == nil {
+		s.profiles = make(map[string]Profile)
+	}
+	s.profiles[profile.Fingerprint] = profile
+
+	return nil
+}
+
+// Registration is a middleware which asks clients to register their
+// certificate before using the next handler, without the client having to
+// create anything beyond a self-signed certificate.
+//
+// Clients without a certificate get gemini.StatusCertificateRequired. The
+// first time a certificate is seen, the client is asked for a display name
+// with gemini.StatusInput, and once it has been saved the client is
+// redirected back to the page it asked for. After that, the profile is
+// available to handlers with CtxProfile.
+//
+// The query of the first request with a new certificate is used for the
+// name, so it is not passed on to the next handler.
+type Registration struct {
+	Store ProfileStore
+
+	// Prompt is the meta sent when asking for a name. If empty, "Choose a
+	// display name" is used.
+	Prompt string
+
+	// MaxNameLength limits the length of names, in characters. If zero, 32
+	// is used.
+	MaxNameLength int
+
+	// Optional lets clients without a certificate through to the next
+	// handler, without a profile in the context.
+	Optional bool
+}
+
+func (reg *Registration) prompt() string {
+	if reg.Prompt != "" {
+		return reg.Prompt
+	}
+	return "Choose a display name"
+}
+
+func (reg *Registration) maxNameLength() int {
+	if reg.MaxNameLength > 0 {
+		return reg.MaxNameLength
+	}
+	return 32
+}
+
+// Middleware returns a handler which only calls next for registered clients.
+func (reg *Registration) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		if r.Identity == nil {
+			if reg.Optional {
+				next.ServeGemini(ctx, w, r)
+				return
+			}
+
+			w.WriteStatus(StatusCertificateRequired, "client certificate required")
+			return
+		}
+
+		fingerprint := Fingerprint(r.Identity)
+
+		profile, ok, err := reg.Store.Lookup(fingerprint)
+		if err != nil {
+			w.WriteStatus(StatusTemporaryFailure, "unable to load profile")
+			return
+		}
+
+		if ok {
+			next.ServeGemini(CtxWithProfile(ctx, &profile), w, r)
+			return
+		}
+
+		if r.URL.RawQuery == "" {
+			w.WriteStatus(StatusInput, reg.prompt())
+			return
+		}
+
+		name, err := url.QueryUnescape(r.URL.RawQuery)
+		if err != nil {
+			w.WriteStatus(StatusBadRequest, "invalid query")
+			return
+		}
+
+		name = strings.TrimSpace(strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, name))
+
+		if name == "" || utf8.RuneCountInString(name) > reg.maxNameLength() {
+			w.WriteStatus(StatusInput, reg.prompt())
+			return
+		}
+
+		err = reg.Store.Save(Profile{
+			Fingerprint: fingerprint,
+			Name:        name,
+			Registered:  time.Now(),
+		})
+		if err != nil {
+			w.WriteStatus(StatusTemporaryFailure, "unable to save profile")
+			return
+		}
+
+		target := *r.URL
+		target.RawQuery = ""
+		w.WriteStatus(StatusRedirect, target.String())
+	})
+}
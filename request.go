@@ -4,19 +4,26 @@ import (
 	"bufio"
 	"crypto/tls"
 	"crypto/x509"
-	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // A Request represents a Gemini request received by a server or to be sent by a
 // client.
 type Request struct {
-	// TODO: find a good api for making a request where the connection URL and
-	// Request URL are different.
+	// URL is the URL sent to the server.
 	URL *url.URL
 
+	// Addr, if set, is the host:port the Client connects to instead of the
+	// host in the URL. This is used for proxies, onion services and
+	// split-horizon setups. See NewProxiedRequest.
+	Addr string
+
 	// ServerName allows you to override the server name sent via SNI. This is
 	// generally only needed for proxy requests.
 	ServerName string
@@ -24,13 +31,41 @@ type Request struct {
 	// Identity allows Gemini servers and other software to record information
 	// the certificate the client is using to connect.
 	Identity *x509.Certificate
+
+	// RemoteAddr is the network address of the client which sent the
+	// request, in host:port form. It is set by ReadRequest when reading from
+	// a net.Conn and is ignored by the Client.
+	RemoteAddr string
+
+	// TLS holds the state of the connection the request was received on,
+	// including the negotiated cipher suite and the full certificate chain
+	// the client presented. It is set by ReadRequest when reading from a
+	// *tls.Conn and is ignored by the Client.
+	TLS *tls.ConnectionState
+
+	// HeaderTimeout, if non-zero, limits how long the Client will wait for the
+	// response header, including connecting.
+	HeaderTimeout time.Duration
+
+	// TotalTimeout, if non-zero, limits the whole request, including reading
+	// the response body. Once it has elapsed, reads from the body will fail.
+	TotalTimeout time.Duration
+
+	// Titan holds the upload parameters for titan:// requests received by a
+	// server. It is nil for other requests.
+	Titan *TitanUpload
+
+	// Body is the uploaded content of a Titan request, limited to the
+	// declared size. It is nil for other requests.
+	Body io.Reader
 }
 
 func (r *Request) String() string {
 	return r.URL.String() + "\r\n"
 }
 
-// NewRequest returns a new Request given a URL in string form.
+// NewRequest returns a new Request given a URL in string form. Gemini URLs
+// are validated and normalized with NormalizeURL.
 func NewRequest(rawUrl string) (*Request, error) {
 	url, err := url.Parse(rawUrl)
 	if err != nil {
@@ -41,6 +76,12 @@ func NewRequest(rawUrl string) (*Request, error) {
 		url.Scheme = "gemini"
 	}
 
+	if url.Scheme == "gemini" {
+		if err := NormalizeURL(url); err != nil {
+			return nil, err
+		}
+	}
+
 	return NewRequestURL(url), nil
 }
 
@@ -57,10 +98,85 @@ func NewRequestURL(url *url.URL) *Request {
 	}
 }
 
-// ReadRequest reads and returns a Gemini request from r.
+// NewProxiedRequest returns a new Request for targetURL which is sent to the
+// server at connectURL, such as a Gemini proxy. The connection, including SNI
+// and certificate verification, uses the host from connectURL, while
+// targetURL is what is sent on the wire.
+//
+// To reach the host in targetURL through a different address, without
+// presenting a different server name, set Request.Addr directly instead.
+func NewProxiedRequest(connectURL, targetURL string) (*Request, error) {
+	connect, err := ParseGeminiURL(connectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := NewRequest(targetURL)
+	if err != nil {
+		return nil, err
+	}
+
+	port := connect.Port()
+	if port == "" {
+		port = "1965"
+	}
+
+	ret.Addr = net.JoinHostPort(connect.Hostname(), port)
+	ret.ServerName = connect.Hostname()
+
+	return ret, nil
+}
+
+// connectAddr returns the host and port the Client should connect to for r.
+func (r *Request) connectAddr() (string, string, error) {
+	if r.Addr != "" {
+		return net.SplitHostPort(r.Addr)
+	}
+
+	port := r.URL.Port()
+	if port == "" {
+		port = "1965"
+	}
+
+	return r.URL.Hostname(), port, nil
+}
+
+// MaxURLLength is the longest request URL allowed by the spec, in bytes, not
+// including the trailing CRLF.
+const MaxURLLength = 1024
+
+// ReadRequest reads and returns a Gemini request from r. The read buffer is
+// pooled, so reading a plain gemini:// request takes four allocations, for the
+// request line and the parsed Request.
+//
+// Request URLs longer than MaxURLLength are rejected with ErrRequestTooLong,
+// without reading the rest of the line.
 func ReadRequest(conn io.Reader) (*Request, error) {
-	reader := bufio.NewReader(conn)
-	line, err := reader.ReadString('\n')
+	return readRequest(conn, false, MaxURLLength)
+}
+
+// ReadRequestStrict is like ReadRequest, but it also rejects request lines
+// which different software may interpret differently, which could be used to
+// smuggle requests past proxies or filters. This includes backslashes,
+// whitespace, control characters, fragments, missing hosts, userinfo and
+// unusual ports. Errors from these checks wrap ErrInvalidRequest.
+func ReadRequestStrict(conn io.Reader) (*Request, error) {
+	return readRequest(conn, true, MaxURLLength)
+}
+
+func readRequest(conn io.Reader, strict bool, maxLength int) (*Request, error) {
+	reader := getBufioReader(conn)
+
+	// Titan uploads keep reading from the buffer for their body, so it can
+	// only be returned to the pool for other requests.
+	keepReader := false
+	defer func() {
+		if !keepReader {
+			putBufioReader(reader)
+		}
+	}()
+
+	line, err := readRequestLine(reader, maxLength)
 	if err != nil {
 		return nil, err
 	}
@@ -68,27 +184,54 @@ func ReadRequest(conn io.Reader) (*Request, error) {
 	// This check needs to be here, otherwise TrimSuffix won't be able to
 	// guarantee that we're getting valid lines.
 	if !strings.HasSuffix(line, "\r\n") {
-		return nil, errors.New("malformed status line")
+		return nil, fmt.Errorf("%w: request line does not end in CRLF", ErrInvalidRequest)
 	}
 
 	line = strings.TrimSuffix(line, "\r\n")
 
+	if strict {
+		if err := validateRequestLine(line); err != nil {
+			return nil, err
+		}
+	}
+
 	url, err := url.Parse(line)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	if strict {
+		if err := validateRequestURL(url); err != nil {
+			return nil, err
+		}
 	}
 
 	ret := &Request{
 		URL: url,
 	}
 
+	if url.Scheme == "titan" {
+		ret.Titan, err = parseTitanParams(url)
+		if err != nil {
+			return nil, err
+		}
+
+		ret.Body = io.LimitReader(reader, ret.Titan.Size)
+		keepReader = true
+	}
+
 	// ServerName defaults to the Hostname, but it can be overridden from the
 	// tls.Conn data.
 	ret.ServerName = url.Hostname()
 
+	if nc, ok := conn.(net.Conn); ok {
+		ret.RemoteAddr = nc.RemoteAddr().String()
+	}
+
 	if tc, ok := conn.(*tls.Conn); ok {
 		state := tc.ConnectionState()
 
+		ret.TLS = &state
 		ret.ServerName = state.ServerName
 
 		if len(state.PeerCertificates) > 0 {
@@ -98,3 +241,102 @@ func ReadRequest(conn io.Reader) (*Request, error) {
 
 	return ret, nil
 }
+
+// readRequestLine reads a line from reader, giving up with ErrRequestTooLong
+// once it is clear the URL is longer than maxLength.
+func readRequestLine(reader *bufio.Reader, maxLength int) (string, error) {
+	// Allow for the CRLF.
+	limit := maxLength + 2
+
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return "", ErrRequestTooLong
+		}
+		line = append(line, chunk...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return string(line), nil
+	}
+}
+
+// validateRequestLine checks the raw request line for characters which are
+// never valid in a request URL, but which some URL parsers will accept.
+func validateRequestLine(line string) error {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\':
+			return fmt.Errorf("%w: backslash in URL", ErrInvalidRequest)
+		case c == '#':
+			return fmt.Errorf("%w: fragment in URL", ErrInvalidRequest)
+		case c == ' ' || c == '\t':
+			return fmt.Errorf("%w: whitespace in URL", ErrInvalidRequest)
+		case c < 0x20 || c == 0x7f:
+			return fmt.Errorf("%w: control character in URL", ErrInvalidRequest)
+		}
+	}
+
+	return nil
+}
+
+// validateServerRequest checks the URL of a request received by a Server for
+// parts which are never allowed. Unless allowProxy is set, only gemini and
+// titan URLs are accepted.
+func validateServerRequest(u *url.URL, allowProxy bool) error {
+	if !u.IsAbs() || u.Opaque != "" || u.Host == "" {
+		return fmt.Errorf("%w: URL is not absolute", ErrInvalidRequest)
+	}
+
+	if !allowProxy && u.Scheme != "gemini" && u.Scheme != "titan" {
+		return fmt.Errorf("%w: unsupported scheme %q", ErrInvalidRequest, u.Scheme)
+	}
+
+	if u.User != nil {
+		return fmt.Errorf("%w: userinfo in URL", ErrInvalidRequest)
+	}
+
+	if u.Fragment != "" {
+		return fmt.Errorf("%w: fragment in URL", ErrInvalidRequest)
+	}
+
+	return nil
+}
+
+// validateRequestURL checks the parsed URL for parts which are either not
+// allowed in Gemini requests or which are ambiguous.
+func validateRequestURL(u *url.URL) error {
+	if !u.IsAbs() || u.Opaque != "" {
+		return fmt.Errorf("%w: URL is not absolute", ErrInvalidRequest)
+	}
+
+	if u.Hostname() == "" {
+		return fmt.Errorf("%w: empty host", ErrInvalidRequest)
+	}
+
+	if u.User != nil {
+		return fmt.Errorf("%w: userinfo in URL", ErrInvalidRequest)
+	}
+
+	// Only allow ports written the canonical way, so "host:", "host:01965"
+	// and "host:+1965" can't be used to get around filters on the host.
+	if strings.HasSuffix(u.Host, ":") {
+		return fmt.Errorf("%w: empty port", ErrInvalidRequest)
+	}
+
+	if port := u.Port(); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n < 1 || n > 65535 || strconv.Itoa(n) != port {
+			return fmt.Errorf("%w: invalid port %q", ErrInvalidRequest, port)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package gemini
+
+import (
+	"strings"
+	"testing"
+)
+
+const benchRequest = "gemini://example.com/path/to/page.gmi?query\r\n"
+
+func BenchmarkReadRequest(b *testing.B) {
+	r := strings.NewReader("")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(benchRequest)
+		if _, err := ReadRequest(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReadRequestAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not counted reliably with the race detector")
+	}
+
+	r := strings.NewReader("")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(benchRequest)
+		if _, err := ReadRequest(r); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// The request line, as bytes and a string, the URL and the Request.
+	if allocs > 4 {
+		t.Errorf("ReadRequest made %v allocations, want at most 4", allocs)
+	}
+}
@@ -2,9 +2,11 @@ package gemini
 
 import (
 	"bufio"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"strconv"
 	"strings"
@@ -44,66 +46,195 @@ type Response struct {
 	Status int
 	Meta   string
 	Body   io.ReadCloser
+
+	// Request is the request which was sent to obtain this Response. It is
+	// only set for responses returned by the Client.
+	Request *Request
+
+	// Via holds the requests which were made before Request while following
+	// redirects, oldest first. It is empty if there were no redirects.
+	Via []*Request
+
+	// TLS holds the state of the connection the response was read from. It is
+	// only set for gemini responses returned by the Client.
+	TLS *tls.ConnectionState
+}
+
+// MaxMetaLength is the maximum length of a meta string in bytes, as defined by
+// the spec.
+const MaxMetaLength = 1024
+
+// NewResponse returns a Response with the given status and meta and an empty
+// body. It returns an error if the status or meta could not be sent as a valid
+// Gemini response header.
+func NewResponse(status int, meta string) (*Response, error) {
+	return NewResponseBody(status, meta, nil)
 }
 
+// NewResponseBody is like NewResponse, but it also sets the body. Only success
+// responses may have a body. If body is also an io.Closer, closing the
+// Response's Body will close it.
+func NewResponseBody(status int, meta string, body io.Reader) (*Response, error) {
+	err := validateHeader(status, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	if body == nil {
+		body = strings.NewReader("")
+	} else if status < StatusSuccess || status >= StatusRedirect {
+		return nil, ErrBodyNotAllowed
+	}
+
+	rc, ok := body.(io.ReadCloser)
+	if !ok {
+		rc = ioutil.NopCloser(body)
+	}
+
+	return &Response{
+		Status: status,
+		Meta:   meta,
+		Body:   rc,
+	}, nil
+}
+
+// validateHeader checks that status and meta make up a valid response header.
+func validateHeader(status int, meta string) error {
+	if status < StatusInput || status >= statusSentinel {
+		return ErrInvalidStatus
+	}
+
+	if len(meta) > MaxMetaLength {
+		return ErrMetaTooLong
+	}
+
+	if strings.ContainsAny(meta, "\r\n") {
+		return ErrInvalidMeta
+	}
+
+	return nil
+}
+
+// Header returns the response header, without the trailing CRLF.
 func (r *Response) Header() string {
 	return fmt.Sprintf("%2d %s", r.Status, r.Meta)
 }
 
-// WriteTo implements io.WriterTo for Response.
+// WriteTo implements io.WriterTo for Response. It serializes the response as it
+// would be sent by a server.
+//
+// The header is validated before anything is written, returning
+// ErrInvalidStatus, ErrInvalidMeta or ErrMetaTooLong if it isn't valid. Only
+// success responses have their body written; for all other statuses the body
+// is ignored.
 func (r *Response) WriteTo(w io.Writer) (int64, error) {
-	var bytesWritten, n64 int64
-
-	n, err := w.Write([]byte(r.Header() + "\r\n"))
+	err := validateHeader(r.Status, r.Meta)
 	if err != nil {
 		return 0, err
 	}
-	bytesWritten += int64(n)
 
-	if r.IsSuccess() {
-		n64, err = io.Copy(w, r.Body)
-		bytesWritten += n64
+	n, err := io.WriteString(w, strconv.Itoa(r.Status)+" "+r.Meta+"\r\n")
+	bytesWritten := int64(n)
+	if err != nil {
+		return bytesWritten, err
+	}
+
+	if !r.IsSuccess() || r.Body == nil {
+		return bytesWritten, nil
 	}
 
+	n64, err := copyBuffer(w, r.Body)
+	bytesWritten += n64
+
 	return bytesWritten, err
 }
 
+// maxDiscardBytes is the most Discard will read from a body before closing it.
+// Because Gemini connections are never reused, there is no benefit to reading
+// more than this from a misbehaving server.
+const maxDiscardBytes = 64 << 10
+
+// Discard reads and throws away any remaining data in the body, then closes it.
+// Any response returned alongside an error from the Client has already been
+// discarded.
+func (r *Response) Discard() error {
+	if r.Body == nil {
+		return nil
+	}
+
+	_, err := io.CopyN(ioutil.Discard, r.Body, maxDiscardBytes)
+	if err == io.EOF {
+		err = nil
+	}
+
+	if cerr := r.Body.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
 // ReadResponse reads and returns a Gemini response from r. conn will be closed
 // afterwords. On success, clients must call resp.Body.Close when finished
 // reading resp.Body.
+//
+// The buffer used to read the response is pooled, and is only released once the
+// body is closed, so callers which don't close bodies lose the benefit of
+// pooling. With pooling, reading the header takes four allocations.
 func ReadResponse(conn io.ReadCloser) (*Response, error) {
-	reader := bufio.NewReader(conn)
-	line, err := reader.ReadString('\n')
+	reader := getBufioReader(conn)
+	status, meta, err := readResponseHeader(reader)
 	if err != nil {
+		putBufioReader(reader)
 		return nil, err
 	}
 
+	return &Response{
+		Status: status,
+		Meta:   meta,
+		Body: &wrappedBufferedReader{
+			buf: reader,
+			rc:  conn,
+		},
+	}, nil
+}
+
+func readResponseHeader(reader *bufio.Reader) (int, string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, "", err
+	}
+
 	// This check needs to be here, otherwise TrimSuffix won't be able to
 	// guarantee that we're getting valid lines.
 	if !strings.HasSuffix(line, "\r\n") {
-		return nil, errors.New("malformed status line")
+		return 0, "", errors.New("malformed status line")
 	}
 
 	line = strings.TrimSuffix(line, "\r\n")
 
 	split := strings.SplitN(line, " ", 2)
 	if len(split) != 2 {
-		return nil, errors.New("invalid response")
+		return 0, "", errors.New("invalid response")
 	}
 
-	status, err := strconv.Atoi(split[0])
-	if err != nil {
-		return nil, err
+	// Statuses are always two digits. Atoi alone would also accept signs
+	// and longer numbers.
+	code := split[0]
+	if len(code) != 2 || code[0] < '0' || code[0] > '9' || code[1] < '0' || code[1] > '9' {
+		return 0, "", ErrInvalidStatus
 	}
+	status, _ := strconv.Atoi(code)
 
-	return &Response{
-		Status: status,
-		Meta:   split[1],
-		Body: &wrappedBufferedReader{
-			buf: reader,
-			rc:  conn,
-		},
-	}, nil
+	meta := split[1]
+	if len(meta) > MaxMetaLength {
+		return 0, "", ErrMetaTooLong
+	}
+	if strings.ContainsAny(meta, "\r\n") {
+		return 0, "", ErrInvalidMeta
+	}
+
+	return status, meta, nil
 }
 
 // IsInput is a convenience method for determining if this response status
@@ -143,7 +274,7 @@ func (r *Response) IsCertificateRequired() bool {
 }
 
 func (r *Response) statusIsUnknown() bool {
-	return r.Status < StatusInput || r.Status > statusSentinel
+	return r.Status < StatusInput || r.Status >= statusSentinel
 }
 
 // MediaType attempts to parse and normalize the media type of a success
@@ -156,7 +287,13 @@ func (r *Response) MediaType() (string, map[string]string, error) {
 		return "", nil, ErrUnknownStatus
 	}
 
-	mt, params, err := mime.ParseMediaType(r.Meta)
+	// The spec says an empty media type should be treated as text/gemini.
+	meta := r.Meta
+	if strings.TrimSpace(meta) == "" {
+		meta = "text/gemini"
+	}
+
+	mt, params, err := mime.ParseMediaType(meta)
 
 	// => gemini://gemini.conman.org/test/torture/0017
 	// => gemini://gemini.conman.org/test/torture/0018
@@ -166,3 +303,37 @@ func (r *Response) MediaType() (string, map[string]string, error) {
 
 	return mt, params, err
 }
+
+// DefaultCharset is the charset assumed for text responses which don't specify
+// one, as required by the spec.
+const DefaultCharset = "utf-8"
+
+// IsGemtext returns true if this is a success response with a media type of
+// text/gemini.
+func (r *Response) IsGemtext() bool {
+	mt, _, err := r.MediaType()
+	return err == nil && mt == "text/gemini"
+}
+
+// IsText returns true if this is a success response with any text/* media
+// type, including text/gemini.
+func (r *Response) IsText() bool {
+	mt, _, err := r.MediaType()
+	return err == nil && strings.HasPrefix(mt, "text/")
+}
+
+// Charset returns the normalized charset of a text response. If the response
+// doesn't specify one, DefaultCharset is returned. For non-text responses, an
+// empty string is returned.
+func (r *Response) Charset() string {
+	mt, params, err := r.MediaType()
+	if err != nil || !strings.HasPrefix(mt, "text/") {
+		return ""
+	}
+
+	if charset := params["charset"]; charset != "" {
+		return charset
+	}
+
+	return DefaultCharset
+}
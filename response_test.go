@@ -0,0 +1,54 @@
+package gemini
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+const benchResponse = "20 text/gemini; lang=en\r\n# Hello\n"
+
+// stringConn is a connection which reads from a strings.Reader.
+type stringConn struct {
+	*strings.Reader
+}
+
+func (stringConn) Close() error { return nil }
+
+func BenchmarkReadResponse(b *testing.B) {
+	r := strings.NewReader("")
+	var conn io.ReadCloser = stringConn{r}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		r.Reset(benchResponse)
+		resp, err := ReadResponse(conn)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+}
+
+func TestReadResponseAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not counted reliably with the race detector")
+	}
+
+	r := strings.NewReader("")
+	var conn io.ReadCloser = stringConn{r}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Reset(benchResponse)
+		resp, err := ReadResponse(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	})
+
+	// The status line, its split, the Response and its Body.
+	if allocs > 4 {
+		t.Errorf("ReadResponse made %v allocations, want at most 4", allocs)
+	}
+}
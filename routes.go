@@ -0,0 +1,138 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A RouteType is the kind of handler a Route creates.
+type RouteType string
+
+// Route types supported by NewRouteMux.
+const (
+	// RouteStatic serves files from the directory in Target.
+	RouteStatic RouteType = "static"
+
+	// RouteRedirect redirects to the URL in Target.
+	RouteRedirect RouteType = "redirect"
+
+	// RouteProxy forwards requests to the Gemini server at the URL in Target.
+	RouteProxy RouteType = "proxy"
+
+	// RouteCGI runs the executable in Target.
+	RouteCGI RouteType = "cgi"
+)
+
+// A Route is a declarative description of a single route, for servers which
+// are configured from data rather than code.
+type Route struct {
+	// Pattern is a ServeMux pattern. For static, proxy and cgi routes, it
+	// generally ends with a catch-all such as "/files/*path", and everything
+	// before the catch-all is stripped from the request path before it is
+	// handled.
+	Pattern string `json:"pattern"`
+
+	Type   RouteType `json:"type"`
+	Target string    `json:"target"`
+
+	// Permanent makes redirect routes send a permanent redirect.
+	Permanent bool `json:"permanent,omitempty"`
+}
+
+// LoadRoutes reads a JSON array of routes, such as:
+//
+//	[
+//		{"pattern": "/files/*path", "type": "static", "target": "/srv/files"},
+//		{"pattern": "/old", "type": "redirect", "target": "/new", "permanent": true},
+//		{"pattern": "/app/*path", "type": "proxy", "target": "gemini://localhost:1966/"},
+//		{"pattern": "/search", "type": "cgi", "target": "/srv/cgi/search"}
+//	]
+func LoadRoutes(r io.Reader) ([]Route, error) {
+	var routes []Route
+
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&routes); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// NewRouteMux returns a ServeMux with a handler for each route. It returns an
+// error if any route is invalid.
+func NewRouteMux(routes []Route) (*ServeMux, error) {
+	mux := NewServeMux()
+
+	for _, route := range routes {
+		h, err := route.handler()
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", route.Pattern, err)
+		}
+
+		if err := handleRoute(mux, route.Pattern, h); err != nil {
+			return nil, fmt.Errorf("route %q: %w", route.Pattern, err)
+		}
+	}
+
+	return mux, nil
+}
+
+// handleRoute registers h, turning the panics ServeMux uses for overlapping
+// patterns into errors.
+func handleRoute(mux *ServeMux, pattern string, h Handler) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%v", v)
+		}
+	}()
+
+	mux.Handle(pattern, h)
+	return nil
+}
+
+func (route Route) handler() (Handler, error) {
+	if !strings.HasPrefix(route.Pattern, "/") {
+		return nil, fmt.Errorf("pattern must start with /")
+	}
+
+	if route.Target == "" {
+		return nil, fmt.Errorf("missing target")
+	}
+
+	prefix := route.Pattern
+	if _, ok := catchAllName(cleanPath(prefix)); ok {
+		prefix = prefix[:strings.LastIndex(prefix, "/")]
+	}
+
+	switch route.Type {
+	case RouteStatic:
+		return StripPrefix(prefix, FileServer(Dir(route.Target))), nil
+
+	case RouteRedirect:
+		status := StatusRedirect
+		if route.Permanent {
+			status = StatusPermanentRedirect
+		}
+
+		return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+			w.WriteStatus(status, route.Target)
+		}), nil
+
+	case RouteProxy:
+		target, err := ParseGeminiURL(route.Target)
+		if err != nil {
+			return nil, err
+		}
+
+		return StripPrefix(prefix, &ReverseProxy{Target: target}), nil
+
+	case RouteCGI:
+		return StripPrefix(prefix, &CGI{Path: route.Target, Root: prefix}), nil
+	}
+
+	return nil, fmt.Errorf("unknown route type %q", route.Type)
+}
@@ -0,0 +1,145 @@
+package gemini
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// publishSuffix is the suffix of sidecar files which hold a publish time.
+const publishSuffix = ".publish"
+
+// Scheduled is a FileSystem which hides files until their publish time, so
+// posts can be added ahead of time without a scheduled deploy. It is
+// generally wrapped in a FileServer:
+//
+//	gemini.FileServer(&gemini.Scheduled{Root: gemini.Dir("/srv/gemlog")})
+//
+// A file's publish time comes from a sidecar file with the same name plus
+// ".publish", such as "post.gmi.publish", containing an RFC 3339 time,
+// "2006-01-02 15:04" or "2006-01-02". Otherwise, a file whose name starts with
+// a date, such as "2006-01-02-post.gmi", is published at the start of that
+// day. Other files are always visible. Sidecar files are never served.
+//
+// Hidden files don't appear in directory listings, and opening them fails
+// with an error satisfying os.IsNotExist.
+type Scheduled struct {
+	Root FileSystem
+
+	// Location is the time zone of publish times without one. If nil, UTC
+	// is used.
+	Location *time.Location
+
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+}
+
+func (s *Scheduled) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Scheduled) location() *time.Location {
+	if s.Location != nil {
+		return s.Location
+	}
+	return time.UTC
+}
+
+// Open implements FileSystem.
+func (s *Scheduled) Open(name string) (File, error) {
+	if strings.HasSuffix(name, publishSuffix) || !s.published(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	f, err := s.Root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &scheduledFile{File: f, fs: s, name: name}, nil
+}
+
+// PublishTime returns the time the named file is published, or the zero time
+// if it doesn't have one.
+func (s *Scheduled) PublishTime(name string) time.Time {
+	if t, ok := s.sidecarTime(name); ok {
+		return t
+	}
+
+	base := path.Base(name)
+	if len(base) >= len("2006-01-02") {
+		if t, err := time.ParseInLocation("2006-01-02", base[:len("2006-01-02")], s.location()); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *Scheduled) published(name string) bool {
+	return !s.PublishTime(name).After(s.now())
+}
+
+func (s *Scheduled) sidecarTime(name string) (time.Time, bool) {
+	f, err := s.Root.Open(strings.TrimSuffix(name, "/") + publishSuffix)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, 128))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	value := strings.TrimSpace(string(data))
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, s.location()); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// scheduledFile filters unpublished files and sidecars out of directory
+// listings.
+type scheduledFile struct {
+	File
+
+	fs   *Scheduled
+	name string
+}
+
+func (f *scheduledFile) Readdir(count int) ([]os.FileInfo, error) {
+	for {
+		entries, err := f.File.Readdir(count)
+
+		visible := entries[:0]
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasSuffix(name, publishSuffix) || !f.fs.published(path.Join(f.name, name)) {
+				continue
+			}
+			visible = append(visible, entry)
+		}
+
+		// Keep reading if everything in this batch was hidden, so an empty
+		// result still means the end of the directory.
+		if count > 0 && len(visible) == 0 && len(entries) > 0 && err == nil {
+			continue
+		}
+
+		return visible, err
+	}
+}
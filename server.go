@@ -1,14 +1,20 @@
 package gemini
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/url"
+	"os"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,6 +23,56 @@ func NotFound(ctx context.Context, r *Request, w ResponseWriter) {
 	w.WriteStatus(StatusNotFound, "not found")
 }
 
+// A Flusher is a ResponseWriter which buffers the response, and can send what
+// has been buffered so far to the client. The ResponseWriter passed to
+// handlers by a Server implements it, and the Server flushes the rest of the
+// response once the handler returns, so only handlers which stream a response
+// over time, or want the client to see the header early, need to call Flush.
+//
+// Middleware which wraps a ResponseWriter should pass Flush through, which
+// the Flush function makes easy.
+type Flusher interface {
+	// Flush sends any buffered data to the client, returning the error from
+	// writing it.
+	Flush() error
+}
+
+// Flush flushes w if it implements Flusher, and otherwise does nothing.
+func Flush(w ResponseWriter) error {
+	if f, ok := w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// A Hijacker is a ResponseWriter which lets a handler take over the
+// connection, for long-lived endpoints which manage it themselves. The
+// ResponseWriter passed to handlers by a Server implements it.
+//
+// Middleware which wraps a ResponseWriter and passes the body through
+// unchanged may pass Hijack through, using the Hijack function.
+type Hijacker interface {
+	// Hijack flushes the response and returns the connection. The status
+	// must have been written first, or ErrHijackBeforeStatus is returned.
+	//
+	// After a call to Hijack, the Server doesn't write to, close or track
+	// the connection, which is no longer affected by the Server's timeouts,
+	// Shutdown or Close. The ResponseWriter must not be used afterwards.
+	// Anything the client sent after the request line, other than a Titan
+	// body read through Request.Body, may already have been read by the
+	// Server.
+	Hijack() (*tls.Conn, error)
+}
+
+// Hijack hijacks w if it implements Hijacker, and otherwise returns
+// ErrNotHijackable.
+func Hijack(w ResponseWriter) (*tls.Conn, error) {
+	if h, ok := w.(Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, ErrNotHijackable
+}
+
 // A ResponseWriter interface is used by a Gemini handler to construct a Gemini
 // response.
 //
@@ -27,6 +83,8 @@ type ResponseWriter interface {
 	//
 	// If WriteStatus has not yet been called, Write calls
 	// WriteStatus(gemini.StatusSuccess, "text/gemini") before writing the data.
+	// The meta used can be changed with Server.DefaultMeta or the DefaultMeta
+	// middleware.
 	Write([]byte) (int, error)
 
 	// WriteStatus sends a Gemini status response with the provided status code
@@ -46,6 +104,12 @@ type Params []string
 
 // A Handler responds to a Gemini request.
 //
+// For requests from a Server, the context is cancelled when the client closes
+// the connection, when the connection is closed by Close or by Shutdown giving
+// up, and when ServeGemini returns. Long running handlers should stop once it
+// is done. For Titan requests the client is only noticed going away by reading
+// the body.
+//
 // If ServeGemini panics, the server (the caller of ServeGemini) assumes that
 // the effect of the panic was isolated to the active request. It recovers the
 // panic, logs a stack trace to the server error log, and closes the network
@@ -74,16 +138,143 @@ type Server struct {
 	Addr    string
 	Handler Handler
 	TLS     *tls.Config
+
+	// Listener, if set, is used by ListenAndServe instead of listening on
+	// Addr. This makes it easy to serve on a socket created elsewhere, such
+	// as by a test harness or a local relay.
+	Listener net.Listener
+
+	// Addrs, if set, are the TCP network addresses ListenAndServe listens
+	// on instead of Addr, such as "0.0.0.0:1965" and "[::]:1965" for
+	// separate IPv4 and IPv6 sockets. They are all served with ServeAll.
+	Addrs []string
+
+	// PreHandlers are run in order on every request before Handler is called.
+	// They see the raw request and are intended for global policy like IP
+	// blocklists or maintenance mode, which shouldn't be part of the route
+	// tree.
+	PreHandlers []PreHandler
+
+	// DefaultMeta is the meta sent when a handler calls Write without calling
+	// WriteStatus first. If empty, "text/gemini" is used. To change it for
+	// only part of a ServeMux, use the DefaultMeta middleware.
+	DefaultMeta string
+
+	// ErrorPages, if set, renders the meta of every error response sent
+	// after the request has been read, including the Server's own replies
+	// for requests nothing handled. To use them for only part of a
+	// ServeMux, use ErrorPages.Middleware instead.
+	ErrorPages *ErrorPages
+
+	// StrictRequests makes the Server read requests with ReadRequestStrict,
+	// replying with gemini.StatusBadRequest to any which are rejected.
+	StrictRequests bool
+
+	// AllowProxyRequests lets requests for URLs with schemes other than
+	// gemini and titan through to the Handler, for servers which act as
+	// proxies. Otherwise they get gemini.StatusBadRequest, as do requests
+	// for relative URLs or URLs with userinfo or a fragment.
+	AllowProxyRequests bool
+
+	// AllowedHosts, if set, are the hostnames the Server answers requests
+	// for. Requests for URLs with any other host or port get
+	// gemini.StatusProxyRefusedRequest, as the spec recommends, rather than
+	// being served under a name the server doesn't own. Names may be
+	// wildcards like "*.example.com", and CertificateHosts returns the names
+	// a certificate is valid for. Names without a port only match URLs for
+	// port 1965, so servers reached on other ports must list them as
+	// "host:port". If empty, requests for any host are passed to the
+	// Handler.
+	AllowedHosts []string
+
+	// MaxURLLength is the longest request URL accepted, in bytes. Longer
+	// requests get gemini.StatusBadRequest. If zero, the spec's limit of
+	// gemini.MaxURLLength is used.
+	MaxURLLength int
+
+	// ProxyProtocol makes the Server expect a HAProxy PROXY protocol header,
+	// version 1 or 2, at the start of every connection, before the TLS
+	// handshake. The client address it reports is used for RemoteAddr, so
+	// servers behind a load balancer see the real client IP in requests and
+	// logs. Connections without a valid header are closed. Only enable it
+	// when every connection comes through a proxy which sends the header.
+	ProxyProtocol bool
+
+	// TLSHandshakeTimeout limits how long the TLS handshake may take.
+	// ReadTimeout limits how long the client has to send the request line,
+	// after the handshake. WriteTimeout limits how long writing the response
+	// may take, starting once the request has been read. Connections which
+	// exceed them are closed. If zero, there is no limit.
+	//
+	// The handler's context has a deadline at the end of WriteTimeout, so
+	// database and network calls the handler makes with it can't outlive
+	// the response. ReadTimeout only covers the request line, so it doesn't
+	// affect the context.
+	TLSHandshakeTimeout time.Duration
+	ReadTimeout         time.Duration
+	WriteTimeout        time.Duration
+
+	// HandlerTimeout, if set, is a deadline for the handler's context,
+	// starting once the request has been read. If WriteTimeout is also set,
+	// the earlier of the two is used. Handlers aren't interrupted when it
+	// passes, so they must watch the context for it to have any effect.
+	HandlerTimeout time.Duration
+
+	// ErrorLog is used to log errors accepting connections and reading
+	// requests, panics in handlers, and a line for each request and
+	// response. If nil, the log package's standard logger is used. To
+	// silence the Server, use a logger which writes to ioutil.Discard.
+	ErrorLog *log.Logger
+
+	// ConnState, if set, is called when a client connection changes state.
+	// See the ConnState type for details. StateNew is reported from the
+	// accept loop, so the hook should not block.
+	ConnState func(net.Conn, ConnState)
+
+	// DrainProgress, if set, is called about once a second while Shutdown
+	// waits for requests to finish, so operators can see how draining is
+	// going and tune their shutdown deadlines. If nil, the progress is
+	// logged instead.
+	DrainProgress func(DrainStats)
+
+	mu          sync.Mutex
+	statusHooks map[int][]StatusHook
+	listeners   map[*net.Listener]struct{}
+	activeConn  map[*tls.Conn]time.Time
+	inShutdown  int32
+}
+
+// A PreHandler inspects a request before it is routed. If it returns a non-zero
+// status, that status and meta are sent to the client and no further handlers
+// are called.
+type PreHandler func(ctx context.Context, r *Request) (status int, meta string)
+
+// Maintenance returns a PreHandler which replies to every request with
+// gemini.StatusServerUnavailable and the given meta.
+func Maintenance(meta string) PreHandler {
+	return func(ctx context.Context, r *Request) (int, string) {
+		return StatusServerUnavailable, meta
+	}
 }
 
 // Serve accepts incoming connections on the Listener l, creating a new service
 // goroutine for each. The service goroutines read requests and then call
 // srv.Handler to reply to them.
 //
-// Serve always returns a non-nil error and closes l.
+// Serve may be called more than once, with different listeners, and all of
+// them are closed by Shutdown and Close.
+//
+// Serve always returns a non-nil error and closes l. After Shutdown or Close,
+// the returned error is ErrServerClosed.
 func (s *Server) Serve(l net.Listener) error {
+	l = &onceCloseListener{Listener: l}
 	defer l.Close()
 
+	if !s.trackListener(&l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(&l, false)
+
 	tlsConfig := s.TLS.Clone()
 
 	// If the MinVersion has not been set, set it to what the spec recommends.
@@ -96,6 +287,10 @@ func (s *Server) Serve(l net.Listener) error {
 	for {
 		conn, err := l.Accept()
 		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
 					tempDelay = 5 * time.Millisecond
@@ -107,6 +302,7 @@ func (s *Server) Serve(l net.Listener) error {
 					tempDelay = max
 				}
 
+				s.logf("gemini: Accept error: %v; retrying in %v", err, tempDelay)
 				time.Sleep(tempDelay)
 				continue
 			}
@@ -118,8 +314,16 @@ func (s *Server) Serve(l net.Listener) error {
 			tcpConn.SetKeepAlive(true)
 		}
 
+		var proxy *proxyConn
+		if s.ProxyProtocol {
+			proxy = newProxyConn(conn)
+			conn = proxy
+		}
+
 		rwc := tls.Server(conn, tlsConfig)
-		go s.serve(rwc)
+		s.trackConn(rwc, true)
+		s.setState(rwc, StateNew)
+		go s.serve(rwc, proxy)
 	}
 }
 
@@ -127,7 +331,9 @@ func (s *Server) Serve(l net.Listener) error {
 // Serve to handle requests on incoming connections. Accepted connections are
 // configured to enable TCP keep-alives.
 //
-// If srv.Addr is blank, ":1965" is used.
+// If srv.Listener is set, it is served instead. If srv.Addrs is set, every
+// address in it is listened on and served with ServeAll. Otherwise, if
+// srv.Addr is blank, ":1965" is used.
 //
 // ListenAndServe always returns a non-nil error. After Shutdown or Close, the
 // returned error is ErrServerClosed.
@@ -137,6 +343,30 @@ func (s *Server) ListenAndServe() error {
 		addr = ":1965"
 	}
 
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	if s.Listener != nil {
+		return s.Serve(s.Listener)
+	}
+
+	if len(s.Addrs) > 0 {
+		listeners := make([]net.Listener, 0, len(s.Addrs))
+		for _, addr := range s.Addrs {
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				for _, l := range listeners {
+					l.Close()
+				}
+				return err
+			}
+			listeners = append(listeners, l)
+		}
+
+		return s.ServeAll(listeners...)
+	}
+
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
@@ -145,15 +375,146 @@ func (s *Server) ListenAndServe() error {
 	return s.Serve(l)
 }
 
-func (s *Server) serve(rwc *tls.Conn) {
+// ServeAll calls Serve for each of the listeners concurrently and waits for
+// them all to return. If any of them fails, the others are closed too, so a
+// server listening on several addresses never keeps running on only some of
+// them. Connections which were already accepted are left to finish.
+//
+// ServeAll always returns a non-nil error, which is the first error returned
+// from Serve. After Shutdown or Close, it is ErrServerClosed.
+func (s *Server) ServeAll(listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("no listeners")
+	}
+
+	wrapped := make([]net.Listener, len(listeners))
+	for i, l := range listeners {
+		wrapped[i] = &onceCloseListener{Listener: l}
+	}
+
+	errc := make(chan error, len(wrapped))
+	for _, l := range wrapped {
+		go func(l net.Listener) {
+			errc <- s.Serve(l)
+		}(l)
+	}
+
+	err := <-errc
+	for _, l := range wrapped {
+		l.Close()
+	}
+	for range wrapped[1:] {
+		<-errc
+	}
+
+	return err
+}
+
+// ListenAndServeUnix listens on the Unix domain socket at path and then
+// calls Serve to handle requests on incoming connections. This is useful
+// behind a local relay, which can reach the server without TCP. A stale
+// socket left at path by a previous process is removed first.
+//
+// ListenAndServeUnix always returns a non-nil error. After Shutdown or Close,
+// the returned error is ErrServerClosed.
+func (s *Server) ListenAndServeUnix(path string) error {
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	removeStaleSocket(path)
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// removeStaleSocket removes the socket at path if nothing is listening on
+// it.
+func removeStaleSocket(path string) {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		conn.Close()
+		return
+	}
+
+	_ = os.Remove(path)
+}
+
+func (s *Server) maxURLLength() int {
+	if s.MaxURLLength > 0 {
+		return s.MaxURLLength
+	}
+	return MaxURLLength
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// ctxLogf logs to the ErrorLog of the Server handling the request in ctx, or
+// to the log package's standard logger outside of a Server.
+func ctxLogf(ctx context.Context, format string, args ...interface{}) {
+	if s := CtxServer(ctx); s != nil {
+		s.logf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (s *Server) serve(rwc *tls.Conn, proxy *proxyConn) {
+	start := time.Now()
 	writer := newResponseWriter(rwc)
+	defer writer.release()
+	writer.logf = s.logf
+	if s.DefaultMeta != "" {
+		writer.defaultMeta = s.DefaultMeta
+	}
+
+	if metricsOn() {
+		metricActiveConnections.Add(1)
+		defer metricActiveConnections.Add(-1)
+	}
+
+	defer s.trackConn(rwc, false)
+	defer func() {
+		// A hijacked connection belongs to the handler.
+		if writer.hijacked {
+			return
+		}
+
+		_ = writer.Flush()
+		rwc.Close()
+		s.setState(rwc, StateClosed)
+	}()
 
+	// This is deferred after closing the connection, so it runs first and
+	// the reply to a panicking handler is flushed before the close.
 	defer func() {
-		if err := recover(); err != nil && err != ErrAbortHandler {
+		defer recordResponse(writer)
+
+		err := recover()
+		if err == nil {
+			return
+		}
+
+		if err != ErrAbortHandler {
 			const size = 64 << 10
 			buf := make([]byte, size)
 			buf = buf[:runtime.Stack(buf, false)]
-			fmt.Printf("gemini: panic serving %v: %v\n%s", rwc.RemoteAddr(), err, buf)
+			s.logf("gemini: panic serving %v: %v\n%s", rwc.RemoteAddr(), err, buf)
 		}
 
 		if !writer.hasWritten {
@@ -161,25 +522,136 @@ func (s *Server) serve(rwc *tls.Conn) {
 		}
 	}()
 
-	defer rwc.Close()
+	// stopWatching stops watching for the client disconnecting, once the
+	// request has been read.
+	var stopWatching func()
+
+	writer.conn = rwc
+	writer.onHijack = func() {
+		if stopWatching != nil {
+			stopWatching()
+		}
+		s.trackConn(rwc, false)
+		s.setState(rwc, StateHijacked)
+	}
+
+	// The PROXY header is sent before the handshake, so it has the same
+	// time limit.
+	if proxy != nil {
+		if s.TLSHandshakeTimeout > 0 {
+			_ = rwc.SetDeadline(time.Now().Add(s.TLSHandshakeTimeout))
+		}
+		if err := proxy.readHeader(); err != nil {
+			s.logf("gemini: PROXY header error from %v: %v", proxy.Conn.RemoteAddr(), err)
+			return
+		}
+	}
 
-	req, err := ReadRequest(rwc)
+	if s.TLSHandshakeTimeout > 0 {
+		_ = rwc.SetDeadline(time.Now().Add(s.TLSHandshakeTimeout))
+		if err := rwc.Handshake(); err != nil {
+			s.logf("gemini: TLS handshake error from %v: %v", rwc.RemoteAddr(), err)
+			return
+		}
+		_ = rwc.SetDeadline(time.Time{})
+	}
+
+	if s.ReadTimeout > 0 {
+		_ = rwc.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+	}
+
+	req, err := readRequest(rwc, s.StrictRequests, s.maxURLLength())
+	if err == nil {
+		err = validateServerRequest(req.URL, s.AllowProxyRequests)
+	}
 	if err != nil {
-		fmt.Println(err)
+		s.logf("gemini: error reading request from %v: %v", rwc.RemoteAddr(), err)
+		if errors.Is(err, ErrInvalidRequest) || errors.Is(err, ErrRequestTooLong) {
+			writer.WriteStatus(StatusBadRequest, err.Error())
+		}
 		return
 	}
 
-	fmt.Printf("--> %s\n", req.URL)
+	// The request line has been read, so the read deadline no longer
+	// applies. Titan bodies are read by the handler, which can set its own.
+	if s.ReadTimeout > 0 {
+		_ = rwc.SetReadDeadline(time.Time{})
+	}
 
-	if s.Handler != nil {
-		s.Handler.ServeGemini(context.TODO(), writer, req)
+	// The handler's context gets the earliest of the write deadline and
+	// HandlerTimeout, so calls it makes are bounded by them too.
+	var deadline time.Time
+	if s.WriteTimeout > 0 {
+		deadline = time.Now().Add(s.WriteTimeout)
+		_ = rwc.SetWriteDeadline(deadline)
+	}
+	if s.HandlerTimeout > 0 {
+		if d := time.Now().Add(s.HandlerTimeout); deadline.IsZero() || d.Before(deadline) {
+			deadline = d
+		}
+	}
+
+	s.logf("--> %s", req.URL)
+
+	s.setConnActive(rwc)
+	s.setState(rwc, StateActive)
+
+	// The context is cancelled when the handler returns, or earlier if the
+	// client goes away. Titan requests are left alone, as their body is read
+	// by the handler.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if !deadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, deadline)
+		defer cancelDeadline()
+	}
+
+	if req.Body == nil {
+		stopWatching = watchDisconnect(rwc, cancel)
+	}
+
+	ctx = context.WithValue(ctx, ctxKeyServer, s)
+	ctx = context.WithValue(ctx, ctxKeyStartTime, start)
+	ctx = context.WithValue(ctx, ctxKeyRemoteAddr, rwc.RemoteAddr())
+	ctx = context.WithValue(ctx, ctxKeyLocalAddr, rwc.LocalAddr())
+	ctx = context.WithValue(ctx, ctxKeyTLS, req.TLS)
+
+	var w ResponseWriter = writer
+	if s.ErrorPages != nil {
+		w = &errorPageWriter{ResponseWriter: writer, pages: s.ErrorPages, r: req}
+	}
+
+	if !s.hostAllowed(req.URL) {
+		w.WriteStatus(StatusProxyRefusedRequest, "Proxy requests are not allowed")
+	} else {
+		for _, pre := range s.PreHandlers {
+			if status, meta := pre(ctx, req); status != 0 {
+				w.WriteStatus(status, meta)
+				break
+			}
+		}
+	}
+
+	if s.Handler != nil && !writer.hasWritten {
+		s.Handler.ServeGemini(ctx, w, req)
 	}
 
 	if !writer.hasWritten {
-		NotFound(context.TODO(), req, writer)
+		NotFound(ctx, req, w)
 	}
 
-	fmt.Printf("<-- %d %s\n", writer.writtenStatus, writer.writtenMeta)
+	s.logf("<-- %d %s", writer.writtenStatus, writer.writtenMeta)
+
+	if hooks := s.statusHooksFor(writer.writtenStatus); len(hooks) > 0 {
+		// Send the response before running the hooks, so slow hooks don't
+		// hold up the client.
+		_ = writer.Flush()
+		for _, hook := range hooks {
+			hook(ctx, req, writer.writtenStatus, writer.writtenMeta)
+		}
+	}
 }
 
 // StripPrefix returns a handler that serves requests by removing the given
@@ -208,25 +680,125 @@ type responseWriter struct {
 	writtenStatus int
 	writtenMeta   string
 	hasWritten    bool
+	defaultMeta   string
+
+	// bytesWritten counts the body bytes, not including the header.
+	bytesWritten int64
+
+	// w buffers the response, so handlers making many small writes don't
+	// make a syscall for each one. It is flushed when the handler returns,
+	// or when the handler calls Flush.
+	w *bufio.Writer
 
-	w io.Writer
+	// conn is the connection the response is written to, which is handed
+	// over by Hijack. onHijack is called when that happens.
+	conn     *tls.Conn
+	onHijack func()
+	hijacked bool
+
+	logf func(format string, args ...interface{})
 }
 
+// newResponseWriter returns a responseWriter for w. Its buffer is pooled, so
+// writing a response takes no allocations beyond the responseWriter itself.
 func newResponseWriter(w io.Writer) *responseWriter {
-	return &responseWriter{w: w}
+	return &responseWriter{w: getBufioWriter(w), defaultMeta: "text/gemini", logf: log.Printf}
+}
+
+// Flush implements Flusher.
+func (w *responseWriter) Flush() error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	return w.w.Flush()
+}
+
+// Hijack implements Hijacker.
+func (w *responseWriter) Hijack() (*tls.Conn, error) {
+	if w.conn == nil {
+		return nil, ErrNotHijackable
+	}
+	if w.hijacked {
+		return nil, ErrHijacked
+	}
+	if !w.hasWritten {
+		return nil, ErrHijackBeforeStatus
+	}
+
+	if err := w.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	w.hijacked = true
+	if w.onHijack != nil {
+		w.onHijack()
+	}
+
+	// The handler manages the connection from now on, including its
+	// deadlines.
+	_ = w.conn.SetDeadline(time.Time{})
+
+	return w.conn, nil
+}
+
+// aLongTimeAgo is a deadline in the past, which makes blocked reads return
+// immediately.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// watchDisconnect calls cancel when the client closes c, or c is closed by
+// the Server. Clients don't send anything after their request, so this reads
+// from c in the background until it fails, discarding anything which does
+// arrive. The returned function stops watching without calling cancel, and
+// leaves c usable.
+func watchDisconnect(c *tls.Conn, cancel context.CancelFunc) func() {
+	var stopped int32
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		var buf [1]byte
+		for {
+			if _, err := c.Read(buf[:]); err != nil {
+				if atomic.LoadInt32(&stopped) == 0 {
+					cancel()
+				}
+				return
+			}
+		}
+	}()
+
+	return func() {
+		atomic.StoreInt32(&stopped, 1)
+		_ = c.SetReadDeadline(aLongTimeAgo)
+		<-done
+	}
+}
+
+// release returns the buffer to the pool. The responseWriter must not be used
+// afterwards.
+func (w *responseWriter) release() {
+	putBufioWriter(w.w)
+	w.w = nil
 }
 
 func (w *responseWriter) Write(data []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+
 	if !w.hasWritten {
-		w.WriteStatus(StatusSuccess, "text/gemini")
+		w.WriteStatus(StatusSuccess, w.defaultMeta)
 	}
 
-	return w.w.Write(data)
+	n, err := w.w.Write(data)
+	w.bytesWritten += int64(n)
+	return n, err
 }
 
 func (w *responseWriter) WriteStatus(statusCode int, meta string) {
 	if w.hasWritten {
-		fmt.Println("Cannot write status multiple times")
+		w.logf("gemini: cannot write status multiple times")
 		return
 	}
 
@@ -0,0 +1,37 @@
+package gemini
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+var benchBody = []byte("# Hello\n\nThis is a small page.\n")
+
+func writeBenchResponse(w *responseWriter) {
+	w.WriteStatus(StatusSuccess, "text/gemini")
+	_, _ = w.Write(benchBody)
+	_ = w.Flush()
+	w.release()
+}
+
+func BenchmarkResponseWriter(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		writeBenchResponse(newResponseWriter(ioutil.Discard))
+	}
+}
+
+func TestResponseWriterAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not counted reliably with the race detector")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		writeBenchResponse(newResponseWriter(ioutil.Discard))
+	})
+
+	// Only the responseWriter itself, as its buffer is pooled.
+	if allocs > 1 {
+		t.Errorf("responseWriter made %v allocations, want at most 1", allocs)
+	}
+}
@@ -1,26 +1,63 @@
 package gemini
 
-import "context"
+import (
+	"context"
+	"sync"
+)
 
-// DefaultClient is the Client is used by the shortcuts Get, and Do.
+// DefaultClient is the Client used by the shortcuts Get and Do until
+// SetDefaultClient is called.
+//
+// Deprecated: modifying DefaultClient races with concurrent shortcut calls.
+// Use SetDefaultClient to change the process-wide client configuration, such
+// as installing a TOFUStore or a Timeout.
 var DefaultClient = &Client{}
 
-// Get is a wrapper around DefaultClient.Get.
+var (
+	installedClientLock sync.RWMutex
+	installedClient     *Client
+)
+
+// SetDefaultClient makes the shortcuts Get and Do use a copy of c instead of
+// DefaultClient. It is safe to call concurrently with the shortcuts, and c may
+// be modified afterwards without affecting them.
+func SetDefaultClient(c *Client) {
+	c = c.Clone()
+
+	installedClientLock.Lock()
+	defer installedClientLock.Unlock()
+
+	installedClient = c
+}
+
+// defaultClient returns the Client used by the shortcuts. The Client installed
+// by SetDefaultClient is never exposed, so it is safe to share.
+func defaultClient() *Client {
+	installedClientLock.RLock()
+	defer installedClientLock.RUnlock()
+
+	if installedClient != nil {
+		return installedClient
+	}
+	return DefaultClient
+}
+
+// Get is a wrapper around Client.Get using the default client.
 func Get(rawUrl string) (*Response, error) {
-	return DefaultClient.Get(rawUrl)
+	return defaultClient().Get(rawUrl)
 }
 
-// GetContext is a wrapper around DefaultClient.GetContext.
+// GetContext is a wrapper around Client.GetContext using the default client.
 func GetContext(ctx context.Context, rawUrl string) (*Response, error) {
-	return DefaultClient.GetContext(ctx, rawUrl)
+	return defaultClient().GetContext(ctx, rawUrl)
 }
 
-// Do is a wrapper around DefaultClient.Do.
+// Do is a wrapper around Client.Do using the default client.
 func Do(req *Request) (*Response, error) {
-	return DefaultClient.Do(req)
+	return defaultClient().Do(req)
 }
 
-// DoContext is a wrapper around DefaultClient.DoContext.
+// DoContext is a wrapper around Client.DoContext using the default client.
 func DoContext(ctx context.Context, req *Request) (*Response, error) {
-	return DefaultClient.DoContext(ctx, req)
+	return defaultClient().DoContext(ctx, req)
 }
@@ -0,0 +1,41 @@
+package gemini_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+func TestSetDefaultClientConcurrent(t *testing.T) {
+	addr, _, _ := startServer(t, &gemini.Server{Handler: redirectHandler()})
+	t.Cleanup(func() { gemini.SetDefaultClient(&gemini.Client{}) })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			resp, err := gemini.Get("gemini://" + addr + "/ok")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_ = resp.Body.Close()
+		}()
+
+		go func(i int) {
+			defer wg.Done()
+
+			client := &gemini.Client{Timeout: time.Duration(i+1) * time.Minute}
+			gemini.SetDefaultClient(client)
+
+			// The installed copy must not see later changes.
+			client.Timeout = 0
+		}(i)
+	}
+	wg.Wait()
+}
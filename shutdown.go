@@ -0,0 +1,246 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown checks whether all connections
+// have finished.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// drainReportInterval is how often Shutdown reports its progress.
+const drainReportInterval = time.Second
+
+// DrainStats describes the progress of a graceful shutdown.
+type DrainStats struct {
+	// Active is the number of connections still handling a request.
+	Active int
+
+	// Oldest is how long the longest running of those requests has been
+	// handled for.
+	Oldest time.Duration
+
+	// Elapsed is how long Shutdown has been waiting.
+	Elapsed time.Duration
+}
+
+// Close immediately closes all listeners and connections, including those
+// with requests in progress. For a graceful shutdown, use Shutdown.
+//
+// Close returns any error returned from closing the listeners.
+func (s *Server) Close() error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.closeListenersLocked()
+	for c := range s.activeConn {
+		_ = c.Close()
+		delete(s.activeConn, c)
+	}
+
+	return err
+}
+
+// Shutdown gracefully shuts down the server without interrupting any
+// requests which are being handled. It first closes all listeners, then
+// closes connections which haven't sent a request yet, and then waits for the
+// remaining connections to finish.
+//
+// While it waits, Shutdown reports the number of remaining requests and the
+// age of the oldest to srv.DrainProgress, or logs them if it is nil.
+//
+// If ctx expires before all connections have finished, Shutdown closes the
+// remaining ones, logging each, and returns the context's error. Otherwise,
+// it returns any error returned from closing the listeners.
+//
+// Once Shutdown has been called, Serve and ListenAndServe immediately return
+// ErrServerClosed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.inShutdown, 1)
+
+	s.mu.Lock()
+	err := s.closeListenersLocked()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	lastReport := start
+
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.closeStragglers()
+			return ctx.Err()
+		case now := <-ticker.C:
+			if now.Sub(lastReport) >= drainReportInterval {
+				lastReport = now
+				s.reportDrain(now.Sub(start))
+			}
+		}
+	}
+}
+
+// drainStats returns the progress of a shutdown which has been waiting for
+// elapsed.
+func (s *Server) drainStats(elapsed time.Duration) DrainStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := DrainStats{Elapsed: elapsed}
+	now := time.Now()
+	for _, started := range s.activeConn {
+		if started.IsZero() {
+			continue
+		}
+
+		stats.Active++
+		if age := now.Sub(started); age > stats.Oldest {
+			stats.Oldest = age
+		}
+	}
+
+	return stats
+}
+
+func (s *Server) reportDrain(elapsed time.Duration) {
+	stats := s.drainStats(elapsed)
+	if s.DrainProgress != nil {
+		s.DrainProgress(stats)
+		return
+	}
+
+	s.logf("gemini: shutdown waiting for %d connections after %v, oldest request %v",
+		stats.Active, stats.Elapsed.Round(time.Millisecond), stats.Oldest.Round(time.Millisecond))
+}
+
+// closeStragglers closes the connections still open when Shutdown's deadline
+// passes.
+func (s *Server) closeStragglers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for c, started := range s.activeConn {
+		if !started.IsZero() {
+			s.logf("gemini: shutdown deadline exceeded, force-closing connection from %v after %v",
+				c.RemoteAddr(), now.Sub(started).Round(time.Millisecond))
+		}
+
+		_ = c.Close()
+		delete(s.activeConn, c)
+	}
+}
+
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s.inShutdown) != 0
+}
+
+// trackListener adds or removes a listener. It returns false if the server is
+// shutting down, in which case the listener isn't added.
+func (s *Server) trackListener(l *net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listeners == nil {
+		s.listeners = make(map[*net.Listener]struct{})
+	}
+
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+
+	return true
+}
+
+// trackConn adds or removes a connection. Connections start out idle, until
+// their request has been read.
+func (s *Server) trackConn(c *tls.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.activeConn == nil {
+		s.activeConn = make(map[*tls.Conn]time.Time)
+	}
+
+	if add {
+		s.activeConn[c] = time.Time{}
+	} else {
+		delete(s.activeConn, c)
+	}
+}
+
+// setConnActive marks a connection as handling a request, so Shutdown will
+// wait for it rather than closing it, and records when the request started.
+func (s *Server) setConnActive(c *tls.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.activeConn[c]; ok {
+		s.activeConn[c] = time.Now()
+	}
+}
+
+// closeIdleConns closes connections which haven't sent a request yet and
+// reports whether all connections are gone.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quiescent := true
+	for c, started := range s.activeConn {
+		if !started.IsZero() {
+			quiescent = false
+			continue
+		}
+
+		_ = c.Close()
+		delete(s.activeConn, c)
+	}
+
+	return quiescent
+}
+
+func (s *Server) closeListenersLocked() error {
+	var err error
+	for l := range s.listeners {
+		if cerr := (*l).Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// onceCloseListener wraps a net.Listener, protecting it from multiple Close
+// calls.
+type onceCloseListener struct {
+	net.Listener
+
+	once     sync.Once
+	closeErr error
+}
+
+func (l *onceCloseListener) Close() error {
+	l.once.Do(func() {
+		l.closeErr = l.Listener.Close()
+	})
+	return l.closeErr
+}
@@ -0,0 +1,152 @@
+package gemini_test
+
+import (
+	"context"
+	"crypto/tls"
+	"runtime"
+	"testing"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &gemini.Server{Handler: gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		close(started)
+		<-release
+		w.WriteStatus(gemini.StatusSuccess, "text/plain")
+		_, _ = w.Write([]byte("done"))
+	})}
+	addr, counter, served := startServer(t, server)
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := gemini.Get("gemini://" + addr + "/")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+
+		var buf [16]byte
+		n, _ := resp.Body.Read(buf[:])
+		results <- result{body: string(buf[:n])}
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v while a request was being handled", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(release)
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if err := <-served; err != gemini.ErrServerClosed {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+	if res := <-results; res.err != nil || res.body != "done" {
+		t.Errorf("got body %q and error %v, want the full response", res.body, res.err)
+	}
+
+	counter.waitClosed(t)
+	checkGoroutines(t, before)
+}
+
+func TestShutdownClosesIdleConns(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	server := &gemini.Server{Handler: gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		t.Error("handler called for a connection without a request")
+	})}
+	addr, counter, served := startServer(t, server)
+
+	// This connection completes the handshake but never sends a request.
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), leakTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if err := <-served; err != gemini.ErrServerClosed {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(leakTimeout))
+	var buf [1]byte
+	if _, err := conn.Read(buf[:]); err == nil {
+		t.Error("idle connection was not closed")
+	}
+
+	counter.waitClosed(t)
+	checkGoroutines(t, before)
+}
+
+func TestShutdownDeadlineClosesStragglers(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	started := make(chan struct{})
+	server := &gemini.Server{Handler: gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		close(started)
+		<-ctx.Done()
+	})}
+	addr, counter, served := startServer(t, server)
+
+	errs := make(chan error, 1)
+	go func() {
+		resp, err := gemini.Get("gemini://" + addr + "/")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		errs <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown returned %v, want context.DeadlineExceeded", err)
+	}
+	if err := <-served; err != gemini.ErrServerClosed {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+	if err := <-errs; err == nil {
+		t.Error("request succeeded after its connection was closed")
+	}
+
+	counter.waitClosed(t)
+	checkGoroutines(t, before)
+}
+
+func TestServeAfterShutdown(t *testing.T) {
+	server := &gemini.Server{}
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, served := startServer(t, server)
+	if err := <-served; err != gemini.ErrServerClosed {
+		t.Errorf("Serve returned %v, want ErrServerClosed", err)
+	}
+}
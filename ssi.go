@@ -0,0 +1,325 @@
+package gemini
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIncludeDepth limits how deeply includes may be nested, which also stops
+// include cycles.
+const maxIncludeDepth = 8
+
+var errIncludeDepth = errors.New("includes nested too deeply")
+
+// IncludeFileServer is like CachingFileServer, but it processes server-side
+// include directives in .gmi files before serving them. A directive is a line
+// of its own, outside preformatted blocks, of the form:
+//
+//	<!--#include "header.gmi" -->
+//
+// The supported directives are:
+//
+//	include "file"   inserts the processed contents of file
+//	last-modified    inserts the modification time of the served file
+//	index ["dir"]    inserts links to the entries of dir, or of the
+//	                 directory the file is in
+//
+// Paths are relative to the file containing the directive, unless they start
+// with a slash, in which case they are relative to root. Unknown directives
+// are left as they are.
+//
+// Processed pages are cached until one of the files or directories they were
+// built from changes.
+func IncludeFileServer(root FileSystem) Handler {
+	return &includeHandler{
+		fileHandler: fileHandler{
+			root:  root,
+			cache: newFileCache(),
+		},
+		pages: make(map[string]*includePage),
+	}
+}
+
+type includeHandler struct {
+	fileHandler
+
+	lock  sync.RWMutex
+	pages map[string]*includePage
+}
+
+// includePage is a processed page, along with the state of everything it was
+// built from.
+type includePage struct {
+	body []byte
+	deps map[string]includeDep
+}
+
+type includeDep struct {
+	size    int64
+	modTime time.Time
+}
+
+func (h *includeHandler) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
+	upath := r.URL.Path
+	if !strings.HasPrefix(upath, "/") {
+		upath = "/" + upath
+		r.URL.Path = upath
+	}
+	name := cleanPath(upath)
+
+	d, err := h.stat(name)
+	if err != nil {
+		h.serveFile(ctx, w, r, name)
+		return
+	}
+
+	// Anything which isn't a canonical request for a gemtext file, or a
+	// directory with an index, is served as usual.
+	if d.IsDir() && strings.HasSuffix(upath, "/") {
+		name = strings.TrimSuffix(name, "/") + "/index.gmi"
+		d, err = h.stat(name)
+	} else if strings.HasSuffix(upath, "/") {
+		err = os.ErrNotExist
+	}
+	if err != nil || d.IsDir() || path.Ext(name) != ".gmi" {
+		h.serveFile(ctx, w, r, cleanPath(upath))
+		return
+	}
+
+	body, err := h.page(name)
+	if err != nil {
+		// Errors include paths on the server, which clients shouldn't see.
+		ctxLogf(ctx, "gemini: processing includes in %s: %v", name, err)
+		w.WriteStatus(StatusTemporaryFailure, "include failed")
+		return
+	}
+
+	w.WriteStatus(StatusSuccess, "text/gemini")
+	_, _ = w.Write(body)
+}
+
+func (h *includeHandler) stat(name string) (os.FileInfo, error) {
+	f, err := h.root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Stat()
+}
+
+// page returns the processed contents of name, from the cache if nothing it
+// depends on has changed.
+func (h *includeHandler) page(name string) ([]byte, error) {
+	h.lock.RLock()
+	cached := h.pages[name]
+	h.lock.RUnlock()
+
+	if cached != nil && h.fresh(cached) {
+		return cached.body, nil
+	}
+
+	p := &includePage{deps: make(map[string]includeDep)}
+
+	var buf bytes.Buffer
+	if err := h.process(&buf, p, name, name, 0); err != nil {
+		return nil, err
+	}
+	p.body = buf.Bytes()
+
+	h.lock.Lock()
+	h.pages[name] = p
+	h.lock.Unlock()
+
+	return p.body, nil
+}
+
+func (h *includeHandler) fresh(p *includePage) bool {
+	for name, dep := range p.deps {
+		d, err := h.stat(name)
+		if err != nil || d.Size() != dep.size || !d.ModTime().Equal(dep.modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// process writes the contents of name to buf with its directives evaluated.
+// page is the file being served, which last-modified refers to.
+func (h *includeHandler) process(buf *bytes.Buffer, p *includePage, page, name string, depth int) error {
+	if depth > maxIncludeDepth {
+		return errIncludeDepth
+	}
+
+	f, err := h.root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	p.deps[name] = includeDep{size: d.Size(), modTime: d.ModTime()}
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	preformatted := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "```") {
+			preformatted = !preformatted
+		}
+
+		directive, arg, ok := parseDirective(line)
+		if preformatted || !ok {
+			buf.WriteString(line + "\n")
+			continue
+		}
+
+		switch directive {
+		case "include":
+			if err := h.process(buf, p, page, resolveInclude(name, arg), depth+1); err != nil {
+				return err
+			}
+		case "last-modified":
+			modTime := p.deps[page].modTime
+			buf.WriteString(modTime.UTC().Format("2006-01-02") + "\n")
+		case "index":
+			if err := h.index(buf, p, name, arg); err != nil {
+				return err
+			}
+		default:
+			buf.WriteString(line + "\n")
+		}
+	}
+
+	return scanner.Err()
+}
+
+// index writes links to the entries of the directory arg, relative to name.
+// The directory's own index.gmi is left out.
+func (h *includeHandler) index(buf *bytes.Buffer, p *includePage, name, arg string) error {
+	dir := path.Dir(name)
+	if arg != "" {
+		dir = resolveInclude(name, arg)
+	}
+
+	f, err := h.root.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	d, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	p.deps[dir] = includeDep{size: d.Size(), modTime: d.ModTime()}
+
+	entries, err := f.Readdir(0)
+	if err != nil {
+		return err
+	}
+
+	filtered := entries[:0]
+	for _, entry := range entries {
+		if entry.Name() != "index.gmi" {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		if filtered[i].IsDir() == filtered[j].IsDir() {
+			return filtered[i].Name() < filtered[j].Name()
+		}
+		return filtered[i].IsDir()
+	})
+
+	// Links are relative to the page, not the listed directory.
+	prefix := ""
+	if arg != "" {
+		prefix = strings.TrimSuffix(arg, "/") + "/"
+	}
+
+	for _, entry := range filtered {
+		target := prefix + entry.Name()
+		if entry.IsDir() {
+			target += "/"
+		}
+
+		buf.WriteString("=> ")
+		buf.WriteString(escapeLinkPath(target))
+		buf.WriteString("\n")
+	}
+
+	return nil
+}
+
+// escapeLinkPath escapes each segment of the relative path p for use as a
+// link target.
+func escapeLinkPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	// A colon in the first segment would be taken for a scheme.
+	if strings.Contains(segments[0], ":") {
+		return "./" + strings.Join(segments, "/")
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// resolveInclude returns the path of target, relative to the file name.
+func resolveInclude(name, target string) string {
+	if strings.HasPrefix(target, "/") {
+		return path.Clean(target)
+	}
+	return path.Join(path.Dir(name), target)
+}
+
+// parseDirective parses a line of the form <!--#directive "arg" -->.
+func parseDirective(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "<!--#") || !strings.HasSuffix(line, "-->") {
+		return "", "", false
+	}
+
+	inner := strings.TrimSpace(line[len("<!--#") : len(line)-len("-->")])
+	directive := inner
+	arg := ""
+	if i := strings.IndexAny(inner, " \t"); i >= 0 {
+		directive = inner[:i]
+		arg = strings.TrimSpace(inner[i:])
+	}
+
+	if arg != "" {
+		unquoted, err := strconv.Unquote(arg)
+		if err != nil {
+			return "", "", false
+		}
+		arg = unquoted
+	}
+
+	return directive, arg, directive != ""
+}
@@ -0,0 +1,49 @@
+package gemini
+
+import "context"
+
+// A StatusHook is called by a Server after it has sent a response, with the
+// status and meta which were sent. It runs on the connection's goroutine once
+// the response has been flushed, so it doesn't delay the client, but the
+// connection isn't closed until it returns.
+type StatusHook func(ctx context.Context, r *Request, status int, meta string)
+
+// OnStatus registers hook to be called after every response whose status is
+// in statusClass, which is the first digit of the status, such as 4 for
+// temporary failures or 5 for permanent failures. A statusClass of 0 matches
+// every response. This is useful for alerting on spikes of errors or feeding
+// custom metrics, without wrapping the Handler in middleware.
+//
+// Hooks for every response run first, then those for the status class, each
+// in the order they were registered. Responses the Server sends to requests
+// it couldn't read, and responses from handlers which panicked, don't run
+// them.
+//
+// OnStatus may be called while the Server is running.
+func (s *Server) OnStatus(statusClass int, hook StatusHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.statusHooks == nil {
+		s.statusHooks = make(map[int][]StatusHook)
+	}
+
+	s.statusHooks[statusClass] = append(s.statusHooks[statusClass], hook)
+}
+
+// statusHooksFor returns the hooks to run for status.
+func (s *Server) statusHooksFor(status int) []StatusHook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.statusHooks) == 0 {
+		return nil
+	}
+
+	hooks := append([]StatusHook(nil), s.statusHooks[0]...)
+	if class := status / 10; class != 0 {
+		hooks = append(hooks, s.statusHooks[class]...)
+	}
+
+	return hooks
+}
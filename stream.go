@@ -0,0 +1,67 @@
+package gemini
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"time"
+)
+
+// StreamHandler returns a handler which keeps the connection open and sends
+// gemtext lines as they are received from the channel returned by lines. This
+// can be used for live logs, chat rooms and similar endpoints, though note that
+// many clients wait for the whole response before showing anything.
+//
+// Lines are buffered and written out every flushInterval, or immediately if
+// flushInterval is zero. The stream ends when the channel is closed. The
+// context passed to lines is cancelled once the handler returns, including
+// when a write fails because the client went away, so producers should stop
+// sending when it is done.
+func StreamHandler(flushInterval time.Duration, lines func(ctx context.Context, r *Request) <-chan string) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		ch := lines(ctx, r)
+
+		w.WriteStatus(StatusSuccess, "text/gemini")
+		bw := bufio.NewWriter(w)
+
+		var tick <-chan time.Time
+		if flushInterval > 0 {
+			ticker := time.NewTicker(flushInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-tick:
+				if bw.Flush() != nil || Flush(w) != nil {
+					return
+				}
+
+			case line, ok := <-ch:
+				if !ok {
+					_ = bw.Flush()
+					return
+				}
+
+				if !strings.HasSuffix(line, "\n") {
+					line += "\n"
+				}
+
+				if _, err := bw.WriteString(line); err != nil {
+					return
+				}
+
+				if tick == nil && (bw.Flush() != nil || Flush(w) != nil) {
+					return
+				}
+			}
+		}
+	})
+}
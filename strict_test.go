@@ -0,0 +1,93 @@
+package gemini_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// sendRaw sends line to the server at addr as it is, and returns the status
+// line of the response, without the CRLF.
+func sendRaw(t *testing.T, addr, line string) string {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(leakTimeout))
+	if _, err := conn.Write([]byte(line)); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading response to %q: %v", line, err)
+	}
+
+	return strings.TrimSuffix(status, "\r\n")
+}
+
+// TestStrictRequests sends request lines from the Gemini torture tests to a
+// Server, checking which are rejected with StatusBadRequest with and without
+// StrictRequests.
+func TestStrictRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		strict int
+		lax    int
+	}{
+		{"root", "gemini://localhost/\r\n", 20, 20},
+		{"no path", "gemini://localhost\r\n", 20, 20},
+		{"default port", "gemini://localhost:1965/\r\n", 20, 20},
+		{"IPv6 literal", "gemini://[::1]/\r\n", 20, 20},
+		{"query", "gemini://localhost/?a%20b\r\n", 20, 20},
+		{"longest URL", "gemini://localhost/" + strings.Repeat("a", gemini.MaxURLLength-19) + "\r\n", 20, 20},
+
+		{"URL too long", "gemini://localhost/" + strings.Repeat("a", gemini.MaxURLLength-18) + "\r\n", 59, 59},
+		{"missing CR", "gemini://localhost/\n", 59, 59},
+		{"invalid escape", "gemini://localhost/%zz\r\n", 59, 59},
+		{"relative URL", "/\r\n", 59, 59},
+		{"empty host", "gemini:///\r\n", 59, 59},
+		{"other scheme", "https://localhost/\r\n", 59, 59},
+		{"userinfo", "gemini://user@localhost/\r\n", 59, 59},
+		{"fragment", "gemini://localhost/#fragment\r\n", 59, 59},
+
+		{"backslash", "gemini://localhost\\@example.com/\r\n", 59, 59},
+		{"backslash in path", "gemini://localhost/a\\b\r\n", 59, 20},
+		{"space", "gemini://localhost/a b\r\n", 59, 20},
+		{"tab", "gemini://localhost/a\tb\r\n", 59, 59},
+		{"control character", "gemini://localhost/a\x01b\r\n", 59, 59},
+		{"delete", "gemini://localhost/a\x7fb\r\n", 59, 59},
+		{"empty port", "gemini://localhost:/\r\n", 59, 20},
+		{"leading zero port", "gemini://localhost:01965/\r\n", 59, 20},
+		{"port out of range", "gemini://localhost:99999/\r\n", 59, 20},
+	}
+
+	ok := gemini.HandlerFunc(func(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+		w.WriteStatus(gemini.StatusSuccess, "text/plain")
+	})
+
+	strictAddr, _, _ := startServer(t, &gemini.Server{Handler: ok, StrictRequests: true})
+	laxAddr, _, _ := startServer(t, &gemini.Server{Handler: ok})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sendRaw(t, strictAddr, tt.line); !strings.HasPrefix(got, strconv.Itoa(tt.strict)+" ") {
+				t.Errorf("strict server replied %q, want status %d", got, tt.strict)
+			}
+			if got := sendRaw(t, laxAddr, tt.line); !strings.HasPrefix(got, strconv.Itoa(tt.lax)+" ") {
+				t.Errorf("server replied %q, want status %d", got, tt.lax)
+			}
+		})
+	}
+}
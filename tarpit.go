@@ -0,0 +1,218 @@
+package gemini
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tarpit is a middleware which wastes the time of abusive clients, such as
+// vulnerability scanners, by delaying their responses and optionally dripping
+// out a never-ending body a byte at a time. Timers are used for the delays, so
+// no goroutines are started beyond the one already serving the connection.
+//
+//	tarpit := &gemini.Tarpit{Drip: 5 * time.Minute}
+//	mux.Use(tarpit.Middleware)
+type Tarpit struct {
+	// Policy decides whether a request is tarpitted. If nil, clients whose
+	// IP has received MaxBadRequests gemini.StatusBadRequest responses within
+	// Window are tarpitted.
+	Policy func(ctx context.Context, r *Request) bool
+
+	// MaxBadRequests and Window configure the default policy. If zero, 5 and
+	// 10 minutes are used.
+	MaxBadRequests int
+	Window         time.Duration
+
+	// Delay is how long a tarpitted request waits before anything is sent.
+	// If zero, 10 seconds is used.
+	Delay time.Duration
+
+	// Drip, if non-zero, makes tarpitted requests get a success header after
+	// Delay, followed by a byte of body every second for this long. If zero,
+	// they get gemini.StatusSlowDown after Delay instead.
+	Drip time.Duration
+
+	mu          sync.Mutex
+	badRequests map[string][]time.Time
+}
+
+// Middleware wraps next with the tarpit. It can be passed to Router.Use.
+func (t *Tarpit) Middleware(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		if t.trapped(ctx, r) {
+			t.serveTrapped(ctx, w)
+			return
+		}
+
+		if t.Policy != nil {
+			next.ServeGemini(ctx, w, r)
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeGemini(ctx, rec, r)
+
+		if rec.status == StatusBadRequest {
+			t.recordBadRequest(remoteIP(ctx))
+		}
+	})
+}
+
+func (t *Tarpit) trapped(ctx context.Context, r *Request) bool {
+	if t.Policy != nil {
+		return t.Policy(ctx, r)
+	}
+
+	ip := remoteIP(ctx)
+	if ip == "" {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.recentBadRequests(ip)) >= t.maxBadRequests()
+}
+
+func (t *Tarpit) serveTrapped(ctx context.Context, w ResponseWriter) {
+	delay := t.Delay
+	if delay == 0 {
+		delay = 10 * time.Second
+	}
+
+	if !sleepContext(ctx, delay) {
+		return
+	}
+
+	if t.Drip == 0 {
+		w.WriteStatus(StatusSlowDown, strconv.Itoa(int(delay/time.Second)))
+		return
+	}
+
+	w.WriteStatus(StatusSuccess, "text/gemini")
+
+	deadline := time.Now().Add(t.Drip)
+	for time.Now().Before(deadline) {
+		if _, err := w.Write([]byte{'#'}); err != nil {
+			return
+		}
+		if err := Flush(w); err != nil {
+			return
+		}
+
+		if !sleepContext(ctx, time.Second) {
+			return
+		}
+	}
+}
+
+func (t *Tarpit) recordBadRequest(ip string) {
+	if ip == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.badRequests == nil {
+		t.badRequests = make(map[string][]time.Time)
+	}
+
+	t.badRequests[ip] = append(t.recentBadRequests(ip), time.Now())
+
+	// Keep the map from growing forever by dropping IPs with nothing recent.
+	if len(t.badRequests) > 10000 {
+		for other := range t.badRequests {
+			if len(t.recentBadRequests(other)) == 0 {
+				delete(t.badRequests, other)
+			}
+		}
+	}
+}
+
+// recentBadRequests returns the bad requests for ip within the window. t.mu
+// must be held.
+func (t *Tarpit) recentBadRequests(ip string) []time.Time {
+	window := t.Window
+	if window == 0 {
+		window = 10 * time.Minute
+	}
+
+	cutoff := time.Now().Add(-window)
+	times := t.badRequests[ip]
+	for len(times) > 0 && times[0].Before(cutoff) {
+		times = times[1:]
+	}
+
+	return times
+}
+
+func (t *Tarpit) maxBadRequests() int {
+	if t.MaxBadRequests == 0 {
+		return 5
+	}
+	return t.MaxBadRequests
+}
+
+// sleepContext waits for d, returning false if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// remoteIP returns the IP of the client from the context, or an empty string
+// if it isn't known.
+func remoteIP(ctx context.Context) string {
+	addr := CtxRemoteAddr(ctx)
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// statusRecorder records the first status written through it.
+type statusRecorder struct {
+	ResponseWriter
+
+	status int
+}
+
+func (w *statusRecorder) WriteStatus(statusCode int, meta string) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteStatus(statusCode, meta)
+}
+
+func (w *statusRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = StatusSuccess
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush implements Flusher.
+func (w *statusRecorder) Flush() error {
+	return Flush(w.ResponseWriter)
+}
+
+// Hijack implements Hijacker.
+func (w *statusRecorder) Hijack() (*tls.Conn, error) {
+	return Hijack(w.ResponseWriter)
+}
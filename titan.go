@@ -0,0 +1,130 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TitanUpload holds the parameters of a Titan upload, which are sent as
+// ";key=value" pairs at the end of the URL path. They are removed from
+// Request.URL before the request is routed.
+type TitanUpload struct {
+	// MIME is the media type of the upload. It defaults to text/gemini.
+	MIME string
+
+	// Size is the length of the upload in bytes.
+	Size int64
+
+	// Token is an optional token sent by the client, generally used in place
+	// of a client certificate to authorize uploads.
+	Token string
+}
+
+// parseTitanParams splits the Titan parameters off of the URL path.
+func parseTitanParams(u *url.URL) (*TitanUpload, error) {
+	split := strings.Split(u.Path, ";")
+
+	ret := &TitanUpload{MIME: "text/gemini", Size: -1}
+	for _, param := range split[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%w: malformed titan parameter %q", ErrInvalidRequest, param)
+		}
+
+		switch kv[0] {
+		case "mime":
+			ret.MIME = kv[1]
+		case "token":
+			ret.Token = kv[1]
+		case "size":
+			size, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("%w: invalid titan size %q", ErrInvalidRequest, kv[1])
+			}
+			ret.Size = size
+		}
+	}
+
+	if ret.Size < 0 {
+		return nil, fmt.Errorf("%w: missing titan size", ErrInvalidRequest)
+	}
+
+	u.Path = split[0]
+	u.RawPath = ""
+
+	return ret, nil
+}
+
+// TitanPolicy describes the uploads accepted by the LimitTitan middleware.
+type TitanPolicy struct {
+	// MaxSize is the largest upload accepted, in bytes. Zero means no limit.
+	MaxSize int64
+
+	// MIMETypes lists the accepted media types, such as "text/gemini" or
+	// "image/*". Parameters are ignored when matching. If empty, all types
+	// are accepted.
+	MIMETypes []string
+
+	// CheckToken, if set, is called with the upload token, which may be
+	// empty. The upload is rejected if it returns false.
+	CheckToken func(ctx context.Context, r *Request, token string) bool
+}
+
+// LimitTitan returns a middleware which rejects Titan uploads not allowed by
+// policy before the next handler sees the body. Uploads which are too large
+// or have an unaccepted type get gemini.StatusBadRequest, and uploads with a
+// rejected token get gemini.StatusCertificateNotAuthorized. Requests which
+// aren't Titan uploads are passed through.
+func LimitTitan(policy TitanPolicy) func(Handler) Handler {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+			if r.Titan == nil {
+				next.ServeGemini(ctx, w, r)
+				return
+			}
+
+			if policy.MaxSize > 0 && r.Titan.Size > policy.MaxSize {
+				w.WriteStatus(StatusBadRequest, "upload too large")
+				return
+			}
+
+			if len(policy.MIMETypes) > 0 && !matchMIME(r.Titan.MIME, policy.MIMETypes) {
+				w.WriteStatus(StatusBadRequest, "unsupported media type")
+				return
+			}
+
+			if policy.CheckToken != nil && !policy.CheckToken(ctx, r, r.Titan.Token) {
+				w.WriteStatus(StatusCertificateNotAuthorized, "invalid token")
+				return
+			}
+
+			next.ServeGemini(ctx, w, r)
+		})
+	}
+}
+
+// matchMIME reports whether the media type in meta matches any of patterns,
+// which may use a "type/*" wildcard.
+func matchMIME(meta string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(meta)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType {
+			return true
+		}
+
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
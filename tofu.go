@@ -0,0 +1,211 @@
+package gemini
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate, formatted as
+// colon separated upper case hex.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// A KnownHost is the certificate information a TOFUStore records for a host.
+type KnownHost struct {
+	Fingerprint string
+	Expires     time.Time
+
+	// ExpiryWarned records that a TOFUCertificateExpiring event has been sent
+	// for this certificate, so it is only sent once.
+	ExpiryWarned bool
+}
+
+// A TOFUStore records which certificates have been seen for which hosts, so the
+// Client can implement trust on first use. Hosts are in "hostname:port" form.
+//
+// Implementations must be safe for concurrent use by multiple goroutines.
+type TOFUStore interface {
+	Lookup(host string) (KnownHost, bool)
+	Store(host string, known KnownHost) error
+}
+
+// MemoryTOFUStore is a TOFUStore which only keeps hosts in memory. Its zero
+// value is ready to use.
+type MemoryTOFUStore struct {
+	lock  sync.RWMutex
+	hosts map[string]KnownHost
+}
+
+// Lookup implements TOFUStore.
+func (s *MemoryTOFUStore) Lookup(host string) (KnownHost, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	known, ok := s.hosts[host]
+	return known, ok
+}
+
+// Store implements TOFUStore.
+func (s *MemoryTOFUStore) Store(host string, known KnownHost) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.hosts == nil {
+		s.hosts = make(map[string]KnownHost)
+	}
+	s.hosts[host] = known
+
+	return nil
+}
+
+// A TOFUUpdater is a TOFUStore which can check and update a host in one step,
+// so concurrent connections to the same host can't race between looking it up
+// and storing it. The Client uses Update when a store implements it, and
+// otherwise only serializes its own Lookup and Store calls.
+type TOFUUpdater interface {
+	TOFUStore
+
+	// Update calls update with the known certificate for host, if there is
+	// one, and stores the KnownHost it returns if it also returns true. The
+	// store must be locked for the whole call, so update must not use it.
+	Update(host string, update func(known KnownHost, ok bool) (KnownHost, bool)) error
+}
+
+// Update implements TOFUUpdater.
+func (s *MemoryTOFUStore) Update(host string, update func(known KnownHost, ok bool) (KnownHost, bool)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	known, ok := s.hosts[host]
+	known, store := update(known, ok)
+	if !store {
+		return nil
+	}
+
+	if s.hosts == nil {
+		s.hosts = make(map[string]KnownHost)
+	}
+	s.hosts[host] = known
+
+	return nil
+}
+
+// A TOFUEventKind describes what happened in a TOFUEvent.
+type TOFUEventKind int
+
+const (
+	// TOFUCertificateChanged means a host presented a different certificate
+	// before the known one expired. The connection is refused with
+	// ErrCertificateChanged.
+	TOFUCertificateChanged TOFUEventKind = iota + 1
+
+	// TOFUCertificateReplaced means a host presented a new certificate after
+	// the known one expired, and it was trusted automatically.
+	TOFUCertificateReplaced
+
+	// TOFUCertificateExpiring means the known certificate for a host expires
+	// within Client.TOFUExpiryWarning. It is sent once for each certificate.
+	TOFUCertificateExpiring
+)
+
+// A TOFUEvent is sent to Client.OnTOFUEvent when something notable happens
+// while verifying a host, so long running clients can alert their users.
+type TOFUEvent struct {
+	Kind TOFUEventKind
+	Host string
+
+	// Known is the previously stored certificate information and Presented
+	// is the certificate the host presented this time.
+	Known     KnownHost
+	Presented KnownHost
+}
+
+// tofuLock serializes checking and updating TOFU stores which don't implement
+// TOFUUpdater.
+var tofuLock sync.Mutex
+
+// verifyTOFU checks cert against the certificate previously seen for host. If
+// the host hasn't been seen before, or the previously seen certificate has
+// expired, cert is trusted and stored.
+func (c *Client) verifyTOFU(host string, cert *x509.Certificate) error {
+	current := KnownHost{
+		Fingerprint: Fingerprint(cert),
+		Expires:     cert.NotAfter,
+	}
+
+	warning := c.TOFUExpiryWarning
+	if warning == 0 {
+		warning = 14 * 24 * time.Hour
+	}
+
+	// Events are sent once the store is unlocked, as OnTOFUEvent may be slow.
+	var event TOFUEventKind
+	var known KnownHost
+	var verifyErr error
+	update := func(k KnownHost, ok bool) (KnownHost, bool) {
+		known = k
+
+		if ok && k.Fingerprint == current.Fingerprint {
+			if k.ExpiryWarned || time.Until(k.Expires) >= warning {
+				return k, false
+			}
+
+			event = TOFUCertificateExpiring
+			k.ExpiryWarned = true
+			return k, true
+		}
+
+		if ok && time.Now().Before(k.Expires) {
+			event = TOFUCertificateChanged
+			verifyErr = ErrCertificateChanged
+			return k, false
+		}
+
+		if ok {
+			event = TOFUCertificateReplaced
+		}
+
+		return current, true
+	}
+
+	var err error
+	if updater, ok := c.TOFU.(TOFUUpdater); ok {
+		err = updater.Update(host, update)
+	} else {
+		tofuLock.Lock()
+		if updated, store := update(c.TOFU.Lookup(host)); store {
+			err = c.TOFU.Store(host, updated)
+		}
+		tofuLock.Unlock()
+	}
+
+	if event != 0 {
+		c.tofuEvent(event, host, known, current)
+	}
+
+	// Failing to record the warning doesn't make the certificate any less
+	// trusted.
+	if err != nil && event != TOFUCertificateExpiring {
+		return err
+	}
+
+	return verifyErr
+}
+
+func (c *Client) tofuEvent(kind TOFUEventKind, host string, known, presented KnownHost) {
+	if c.OnTOFUEvent != nil {
+		c.OnTOFUEvent(TOFUEvent{Kind: kind, Host: host, Known: known, Presented: presented})
+	}
+}
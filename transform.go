@@ -0,0 +1,161 @@
+package gemini
+
+import (
+	"context"
+	"io"
+	"mime"
+)
+
+// A Transformer rewrites the body of a success response as it is written.
+//
+// It is called once the status and meta of the response are known. If the
+// handler wrote the body without calling WriteStatus, meta is empty, which
+// generally means the body is gemtext. It returns the writer the body should
+// be written to, which should write its output to body, or nil to leave the
+// response unchanged. The returned writer is closed after the handler
+// returns, so it can write trailing content or flush anything it buffered.
+type Transformer func(ctx context.Context, r *Request, meta string, body io.Writer) io.WriteCloser
+
+// Transform returns a middleware which passes the body of every success
+// response through t. Other responses are not affected. Transformers stream,
+// so they don't add latency to large or slow responses.
+//
+//	mux.Use(gemini.Transform(gemini.WrapGemtext("=> / Home\n\n", "\n=> / Back to the index\n")))
+func Transform(t Transformer) func(Handler) Handler {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+			tw := &transformWriter{
+				ResponseWriter: w,
+				ctx:            ctx,
+				r:              r,
+				transform:      t,
+			}
+
+			next.ServeGemini(ctx, tw, r)
+
+			if tw.body != nil {
+				_ = tw.body.Close()
+			}
+		})
+	}
+}
+
+// WrapGemtext returns a Transformer which writes header before and footer
+// after the body of text/gemini responses.
+func WrapGemtext(header, footer string) Transformer {
+	return func(ctx context.Context, r *Request, meta string, body io.Writer) io.WriteCloser {
+		if !isGemtextMeta(meta) {
+			return nil
+		}
+
+		return &wrapWriter{w: body, header: header, footer: footer}
+	}
+}
+
+// isGemtextMeta returns true if meta is text/gemini or empty, as used for
+// success responses.
+func isGemtextMeta(meta string) bool {
+	if meta == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(meta)
+	return err == nil && mediaType == "text/gemini"
+}
+
+type transformWriter struct {
+	ResponseWriter
+
+	ctx       context.Context
+	r         *Request
+	transform Transformer
+
+	hasWritten bool
+
+	// body is the transformed writer, or nil if the response isn't being
+	// transformed.
+	body io.WriteCloser
+}
+
+func (w *transformWriter) WriteStatus(statusCode int, meta string) {
+	w.ResponseWriter.WriteStatus(statusCode, meta)
+
+	if !w.hasWritten {
+		w.start(statusCode, meta)
+	}
+}
+
+func (w *transformWriter) Write(data []byte) (int, error) {
+	if !w.hasWritten {
+		w.start(StatusSuccess, "")
+	}
+
+	if w.body == nil {
+		return w.ResponseWriter.Write(data)
+	}
+
+	return w.body.Write(data)
+}
+
+// Flush implements Flusher.
+func (w *transformWriter) Flush() error {
+	return Flush(w.ResponseWriter)
+}
+
+func (w *transformWriter) start(statusCode int, meta string) {
+	w.hasWritten = true
+
+	if statusCode < StatusSuccess || statusCode >= StatusRedirect {
+		return
+	}
+
+	// Writing to the underlying ResponseWriter rather than w means an
+	// implicit status is still sent with whatever meta the wrapped writer
+	// defaults to.
+	w.body = w.transform(w.ctx, w.r, meta, w.ResponseWriter)
+}
+
+// wrapWriter writes a header before the first write and a footer on Close.
+type wrapWriter struct {
+	w      io.Writer
+	header string
+	footer string
+
+	started bool
+}
+
+func (w *wrapWriter) writeHeader() error {
+	w.started = true
+
+	if w.header == "" {
+		return nil
+	}
+
+	_, err := io.WriteString(w.w, w.header)
+	return err
+}
+
+func (w *wrapWriter) Write(data []byte) (int, error) {
+	if !w.started {
+		if err := w.writeHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	return w.w.Write(data)
+}
+
+func (w *wrapWriter) Close() error {
+	if !w.started {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	if w.footer == "" {
+		return nil
+	}
+
+	_, err := io.WriteString(w.w, w.footer)
+	return err
+}
@@ -3,6 +3,7 @@ package gemini
 import (
 	"context"
 	"fmt"
+	"net/url"
 	"strings"
 )
 
@@ -14,22 +15,49 @@ type node struct {
 	// catchAllHandler.
 	parent *node
 
+	// depth is the number of path segments between the root and this node,
+	// and nparams is how many of those are params. These are used to work out
+	// what a catchAllHandler captures.
+	depth   int
+	nparams int
+
 	handler         Handler
 	slashHandler    Handler
 	catchAllHandler Handler
+	catchAllName    string
 	children        map[string]*node
 	param           *node
+	wildcard        *node
+
+	// middlewares are applied to every handler mounted at or below this node.
+	middlewares []func(Handler) Handler
+
+	// The handlers above wrapped in middlewares, so the chain isn't rebuilt
+	// on every request. They are updated by wrap.
+	wrappedHandler         Handler
+	wrappedSlashHandler    Handler
+	wrappedCatchAllHandler Handler
 }
 
 func newNode(parent *node) *node {
 	ret := &node{children: make(map[string]*node), parent: parent}
 	if parent != nil {
 		ret.mux = parent.mux
+		ret.depth = parent.depth + 1
+		ret.nparams = parent.nparams
 	}
 	return ret
 }
 
 func (n *node) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
+	if n.mux.CaseInsensitive || n.mux.Normalize != nil {
+		if canonical := n.canonicalPath(r.URL.Path); canonical != cleanPath(r.URL.Path) {
+			target := &url.URL{Path: canonical, RawQuery: r.URL.RawQuery}
+			w.WriteStatus(StatusPermanentRedirect, target.String())
+			return
+		}
+	}
+
 	params, handler := n.match(r.URL.Path, n.mux.RedirectSlash)
 	if handler == nil {
 		return
@@ -41,16 +69,13 @@ func (n *node) ServeGemini(ctx context.Context, w ResponseWriter, r *Request) {
 
 func (n *node) Handle(pattern string, h Handler) {
 	pattern = cleanPath(pattern)
-	hasRest := strings.HasSuffix(pattern, "/:rest")
+	restName, hasRest := catchAllName(pattern)
 	hasSlash := strings.HasSuffix(pattern, "/")
 
 	target := n.ensureNode(pattern)
 
 	if hasRest {
-		if target.catchAllHandler != nil {
-			panic("overlapping catchAllHandlers")
-		}
-		target.catchAllHandler = h
+		target.setCatchAll(restName, h)
 	} else if hasSlash {
 		if target.slashHandler != nil {
 			panic("overlapping handlers")
@@ -62,22 +87,91 @@ func (n *node) Handle(pattern string, h Handler) {
 		}
 		target.handler = h
 	}
+
+	target.wrap()
 }
 
 func (n *node) NotFound(h Handler) {
-	target := n.ensureNode(":rest")
-	if target.catchAllHandler != nil {
+	n.setCatchAll("rest", h)
+}
+
+func (n *node) setCatchAll(name string, h Handler) {
+	if n.catchAllHandler != nil {
 		panic("overlapping catchAllHandlers")
 	}
-	target.catchAllHandler = h
+	n.catchAllHandler = h
+	n.catchAllName = name
+	n.wrap()
+}
+
+// catchAllName returns the name of the trailing catch-all segment of pattern,
+// if there is one. Both the legacy ":rest" and "*name" forms are supported.
+func catchAllName(pattern string) (string, bool) {
+	last := pattern[strings.LastIndex(pattern, "/")+1:]
+	if last == ":rest" {
+		return "rest", true
+	}
+
+	if len(last) > 1 && last[0] == '*' {
+		return last[1:], true
+	}
+
+	return "", false
 }
 
 func (n *node) Route(pattern string, fn func(r Router)) Router {
 	target := n.ensureNode(cleanPath(pattern))
-	fn(target)
+	if fn != nil {
+		fn(target)
+	}
 	return target
 }
 
+func (n *node) Use(middlewares ...func(Handler) Handler) {
+	n.middlewares = append(n.middlewares, middlewares...)
+	n.wrapAll()
+}
+
+// wrap updates the wrapped handlers of this node. Middlewares are applied
+// when handlers are registered rather than per request, so any state they
+// keep is shared by every request to the handler.
+func (n *node) wrap() {
+	n.wrappedHandler = n.chain(n.handler)
+	n.wrappedSlashHandler = n.chain(n.slashHandler)
+	n.wrappedCatchAllHandler = n.chain(n.catchAllHandler)
+}
+
+// wrapAll updates the wrapped handlers of this node and every node below it,
+// which is needed when the middlewares change.
+func (n *node) wrapAll() {
+	if n == nil {
+		return
+	}
+
+	n.wrap()
+	for _, child := range n.children {
+		child.wrapAll()
+	}
+	n.param.wrapAll()
+	n.wildcard.wrapAll()
+}
+
+// chain wraps h in the middlewares of this node and all of its parents. The
+// middlewares closest to the root are the outermost ones.
+func (n *node) chain(h Handler) Handler {
+	if h == nil {
+		return nil
+	}
+
+	for target := n; target != nil; target = target.parent {
+		for i := len(target.middlewares) - 1; i >= 0; i-- {
+			h = target.middlewares[i](h)
+		}
+	}
+
+	return h
+}
+
 func (n *node) ensureNode(targetPath string) *node {
 	// NOTE: this assumes a pre-cleaned path has been passed in. ALL CALLERS
 	// MUST USE cleanPath BEFORE CALLING THIS FUNCTION.
@@ -93,14 +187,32 @@ func (n *node) ensureNodeImpl(path string) *node {
 	next, rest := pathSegment(path)
 
 	// As a special case, we want to have a catch-all option if the last param
-	// is named :rest
+	// is named :rest or starts with a *.
 	if next == ":rest" && rest == "" {
 		return n
 	}
 
+	if len(next) > 1 && next[0] == '*' {
+		if rest != "" {
+			panic("catch-all segments must be at the end of a pattern")
+		}
+
+		return n
+	}
+
+	// A bare * matches exactly one segment without recording it as a param.
+	if next == "*" {
+		if n.wildcard == nil {
+			n.wildcard = newNode(n)
+		}
+
+		return n.wildcard.ensureNodeImpl(rest)
+	}
+
 	if strings.HasPrefix(next, ":") {
 		if n.param == nil {
 			n.param = newNode(n)
+			n.param.nparams++
 		}
 
 		return n.param.ensureNodeImpl(rest)
@@ -129,7 +241,7 @@ func (n *node) matchImpl(origPath string, path string, allowRedirect bool, hasSl
 	if path == "" {
 		if hasSlash {
 			if n.slashHandler != nil {
-				return params, n.slashHandler
+				return params, n.wrappedSlashHandler
 			}
 
 			if allowRedirect && n.handler != nil {
@@ -137,7 +249,7 @@ func (n *node) matchImpl(origPath string, path string, allowRedirect bool, hasSl
 			}
 		} else {
 			if n.handler != nil {
-				return params, n.handler
+				return params, n.wrappedHandler
 			}
 
 			if allowRedirect && n.slashHandler != nil {
@@ -145,7 +257,7 @@ func (n *node) matchImpl(origPath string, path string, allowRedirect bool, hasSl
 			}
 		}
 
-		return params, n.catchAllHandler
+		return n.catchAll(origPath, params)
 	}
 
 	next, rest := pathSegment(path)
@@ -162,6 +274,12 @@ func (n *node) matchImpl(origPath string, path string, allowRedirect bool, hasSl
 		return retParams, retHandler
 	}
 
+	// Wildcard segments are the least specific, so they are attempted last.
+	retParams, retHandler = n.wildcard.matchImpl(origPath, rest, allowRedirect, hasSlash, params)
+	if retHandler != nil {
+		return retParams, retHandler
+	}
+
 	// Finally fall back to the catch all handler if it exists. Note that we
 	// also redirect to include a slash because all catchAllHandlers should
 	// match after a path separator. This fixes a number of edge cases with the
@@ -172,13 +290,103 @@ func (n *node) matchImpl(origPath string, path string, allowRedirect bool, hasSl
 
 	// Traverse back up the tree to find the most relevant catchAllHandler.
 	target := n
-	handler := n.catchAllHandler
-	for handler == nil && target.parent != nil {
+	for target.catchAllHandler == nil && target.parent != nil {
 		target = target.parent
-		handler = target.catchAllHandler
 	}
 
-	return append(params, rest), handler
+	return target.catchAll(origPath, params)
+}
+
+// catchAll returns the catchAllHandler of this node, if any. Params matched
+// below this node are dropped and the rest of the path is instead recorded as
+// a named param.
+func (n *node) catchAll(origPath string, params []string) ([]string, Handler) {
+	if n.catchAllHandler == nil {
+		return params, nil
+	}
+
+	rest := origPath
+	for i := 0; i < n.depth; i++ {
+		_, rest = pathSegment(rest)
+	}
+
+	name := n.catchAllName
+	handler := n.wrappedCatchAllHandler
+
+	return params[:n.nparams], HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		handler.ServeGemini(CtxWithParam(ctx, name, rest), w, r)
+	})
+}
+
+// canonicalPath returns targetPath with every static segment which only
+// matches after case folding or normalization replaced by the segment as it
+// was registered. Exact matches always take precedence.
+func (n *node) canonicalPath(targetPath string) string {
+	targetPath = cleanPath(targetPath)
+	if targetPath == "/" {
+		return targetPath
+	}
+
+	hasSlash := strings.HasSuffix(targetPath, "/")
+	segments := strings.Split(strings.Trim(targetPath, "/"), "/")
+
+	target := n
+	for i, segment := range segments {
+		if target == nil {
+			break
+		}
+
+		if next := target.children[segment]; next != nil {
+			target = next
+			continue
+		}
+
+		if key, next := target.foldedChild(segment); next != nil {
+			segments[i] = key
+			target = next
+			continue
+		}
+
+		if target.param != nil {
+			target = target.param
+		} else {
+			target = target.wildcard
+		}
+	}
+
+	ret := "/" + strings.Join(segments, "/")
+	if hasSlash {
+		ret += "/"
+	}
+
+	return ret
+}
+
+// foldedChild finds a static child which matches segment using the mux's
+// folding and normalization settings. If multiple children match, the
+// lexically smallest key wins so redirects are stable.
+func (n *node) foldedChild(segment string) (string, *node) {
+	normalize := n.mux.Normalize
+	if normalize == nil {
+		normalize = func(s string) string { return s }
+	}
+
+	var retKey string
+	var ret *node
+
+	segment = normalize(segment)
+	for key, child := range n.children {
+		normalized := normalize(key)
+		if normalized != segment && !(n.mux.CaseInsensitive && strings.EqualFold(normalized, segment)) {
+			continue
+		}
+
+		if ret == nil || key < retKey {
+			retKey, ret = key, child
+		}
+	}
+
+	return retKey, ret
 }
 
 func redirectAddSlash(ctx context.Context, w ResponseWriter, r *Request) {
@@ -213,4 +421,8 @@ func (n *node) print(prefix string) {
 	if n.param != nil {
 		n.param.print(prefix + "/:param")
 	}
+
+	if n.wildcard != nil {
+		n.wildcard.print(prefix + "/*")
+	}
 }
@@ -0,0 +1,231 @@
+package gemini
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseGeminiURL parses rawURL as a gemini URL and normalizes it with
+// NormalizeURL. URLs without a scheme, such as "//example.com/", are treated
+// as gemini URLs, but any other scheme is rejected.
+func ParseGeminiURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "" {
+		u.Scheme = "gemini"
+	}
+
+	if u.Scheme != "gemini" {
+		return nil, fmt.Errorf("%w: scheme %q is not gemini", ErrInvalidURL, u.Scheme)
+	}
+
+	if err := NormalizeURL(u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// NormalizeURL validates a gemini URL in place and converts it to its
+// canonical form. Userinfo and fragments aren't allowed in Gemini requests, so
+// they are rejected, as is a missing host. The host is lowercased, with
+// internationalized domain names converted to punycode, the default port is
+// removed and an empty path becomes "/".
+func NormalizeURL(u *url.URL) error {
+	if u.User != nil {
+		return fmt.Errorf("%w: userinfo is not allowed", ErrInvalidURL)
+	}
+
+	if u.Fragment != "" {
+		return fmt.Errorf("%w: fragments are not allowed", ErrInvalidURL)
+	}
+
+	hostname, port := u.Hostname(), u.Port()
+	if hostname == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidURL)
+	}
+
+	// IPv6 literals are left alone, other than being lowercased.
+	if strings.Contains(hostname, ":") {
+		hostname = strings.ToLower(hostname)
+	} else {
+		var err error
+		hostname, err = toASCIIHost(hostname)
+		if err != nil {
+			return err
+		}
+	}
+
+	if port == "1965" {
+		port = ""
+	}
+
+	if port != "" {
+		u.Host = net.JoinHostPort(hostname, port)
+	} else if strings.Contains(hostname, ":") {
+		u.Host = "[" + hostname + "]"
+	} else {
+		u.Host = hostname
+	}
+
+	if u.Path == "" && u.Opaque == "" {
+		u.Path = "/"
+	}
+
+	return nil
+}
+
+// WithQueryInput returns a copy of u with the query set to input, escaped the
+// way Gemini servers expect, with spaces as %20 rather than +.
+func WithQueryInput(u *url.URL, input string) *url.URL {
+	u2 := *u
+	u2.RawQuery = strings.Replace(url.QueryEscape(input), "+", "%20", -1)
+	u2.ForceQuery = false
+	return &u2
+}
+
+// toASCIIHost lowercases hostname and converts any non-ASCII labels to
+// punycode, as described in RFC 3492. Full IDNA mapping is not performed, so
+// hostnames are expected to already be in normalized form.
+func toASCIIHost(hostname string) (string, error) {
+	if !utf8.ValidString(hostname) {
+		return "", fmt.Errorf("%w: invalid host", ErrInvalidURL)
+	}
+
+	labels := strings.Split(strings.ToLower(hostname), ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+
+		encoded, err := punycodeEncode(label)
+		if err != nil {
+			return "", err
+		}
+		labels[i] = "xn--" + encoded
+	}
+
+	return strings.Join(labels, "."), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// Punycode parameters from RFC 3492.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+)
+
+func punycodeEncode(s string) (string, error) {
+	runes := []rune(s)
+
+	var out []byte
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for handled < len(runes) {
+		// Find the smallest code point which hasn't been handled yet.
+		m := math.MaxInt32
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+
+		if (m - n) > (math.MaxInt32-delta)/(handled+1) {
+			return "", fmt.Errorf("%w: host label too long", ErrInvalidURL)
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+
+			if int(r) != n {
+				continue
+			}
+
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := k - bias
+				if t < punyTMin {
+					t = punyTMin
+				} else if t > punyTMax {
+					t = punyTMax
+				}
+
+				if q < t {
+					break
+				}
+
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+
+		delta++
+		n++
+	}
+
+	return string(out), nil
+}
+
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
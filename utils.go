@@ -2,25 +2,94 @@ package gemini
 
 import (
 	"bufio"
+	"errors"
 	"io"
 	"path"
 	"strings"
+	"sync"
 )
 
+// readerPool holds bufio.Readers for reading requests and responses. Without
+// it, every connection on both sides allocates a fresh 4KB buffer, which
+// dominates the allocations for small requests.
+var readerPool sync.Pool
+
+func getBufioReader(r io.Reader) *bufio.Reader {
+	if br, ok := readerPool.Get().(*bufio.Reader); ok {
+		br.Reset(r)
+		return br
+	}
+
+	return bufio.NewReader(r)
+}
+
+func putBufioReader(br *bufio.Reader) {
+	// Drop the reference to the underlying reader so it can be collected.
+	br.Reset(nil)
+	readerPool.Put(br)
+}
+
+// writerPool holds bufio.Writers for writing responses, for the same reason.
+var writerPool sync.Pool
+
+func getBufioWriter(w io.Writer) *bufio.Writer {
+	if bw, ok := writerPool.Get().(*bufio.Writer); ok {
+		bw.Reset(w)
+		return bw
+	}
+
+	return bufio.NewWriter(w)
+}
+
+func putBufioWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	writerPool.Put(bw)
+}
+
+var errBodyClosed = errors.New("read on closed body")
+
+// wrappedBufferedReader is a response body. Its buffer is returned to the pool
+// when it is closed.
 type wrappedBufferedReader struct {
+	// mu guards buf. Close may be called while a Read is blocked, so rc is
+	// closed before taking the lock.
+	mu  sync.Mutex
 	buf *bufio.Reader
 	rc  io.ReadCloser
 }
 
 func (b *wrappedBufferedReader) Read(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.buf == nil {
+		return 0, errBodyClosed
+	}
+
 	return b.buf.Read(p)
 }
 
 func (b *wrappedBufferedReader) Close() error {
-	return b.rc.Close()
+	err := b.rc.Close()
+
+	b.mu.Lock()
+	if b.buf != nil {
+		putBufioReader(b.buf)
+		b.buf = nil
+	}
+	b.mu.Unlock()
+
+	return err
 }
 
 func (b *wrappedBufferedReader) WriteTo(w io.Writer) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.buf == nil {
+		return 0, errBodyClosed
+	}
+
 	return b.buf.WriteTo(w)
 }
 
@@ -0,0 +1,75 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/url"
+)
+
+// Warm fills the caches behind h, such as a ResponseCache, by making an
+// internal request through h for every page linked from the gemtext page at
+// indexURL, like a sitemap or the capsule's index. Only links to the same
+// host are followed, and each page is requested once. The responses are
+// discarded.
+//
+// It is generally run in the background after a deploy or restart, so the
+// first clients don't have to wait for expensive pages:
+//
+//	go gemini.Warm(context.Background(), mux, "gemini://example.com/sitemap.gmi")
+//
+// Warm returns the number of pages requested, including the index. It stops
+// early if ctx is done.
+func Warm(ctx context.Context, h Handler, indexURL string) (int, error) {
+	index, err := ParseGeminiURL(indexURL)
+	if err != nil {
+		return 0, err
+	}
+
+	rec := &cacheRecorder{ResponseWriter: discardResponseWriter{}, maxSize: 1 << 20}
+	h.ServeGemini(ctx, rec, warmRequest(index))
+
+	mediaType, _, _ := mime.ParseMediaType(rec.meta)
+	if rec.status != StatusSuccess || (rec.meta != "" && mediaType != "text/gemini") {
+		return 1, fmt.Errorf("index returned %d %s", rec.status, rec.meta)
+	}
+	if rec.tooLarge {
+		return 1, fmt.Errorf("index too large")
+	}
+
+	links, err := ExtractLinks(&rec.body)
+	if err != nil {
+		return 1, err
+	}
+
+	seen := map[string]bool{index.String(): true}
+	count := 1
+
+	for _, link := range links {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+
+		u, err := index.Parse(link.URL)
+		if err != nil || u.Scheme != index.Scheme || u.Host != index.Host {
+			continue
+		}
+		u.Fragment = ""
+
+		if seen[u.String()] {
+			continue
+		}
+		seen[u.String()] = true
+
+		h.ServeGemini(ctx, discardResponseWriter{}, warmRequest(u))
+		count++
+	}
+
+	return count, nil
+}
+
+func warmRequest(u *url.URL) *Request {
+	r := NewRequestURL(u)
+	r.ServerName = u.Hostname()
+	return r
+}
@@ -0,0 +1,168 @@
+package watch
+
+import "strings"
+
+// An Op is the kind of a Change.
+type Op int
+
+const (
+	// Removed means the line was in the old snapshot but not the new one.
+	Removed Op = iota + 1
+
+	// Added means the line is new.
+	Added
+)
+
+func (op Op) String() string {
+	switch op {
+	case Removed:
+		return "-"
+	case Added:
+		return "+"
+	}
+	return "?"
+}
+
+// A Change is a single added or removed line. Line numbers start at 1 and
+// refer to the old snapshot for removed lines and the new one for added
+// lines.
+type Change struct {
+	Op   Op
+	Line int
+	Text string
+}
+
+func (c Change) String() string {
+	return c.Op.String() + " " + c.Text
+}
+
+// maxDiffCells bounds the memory used by Diff. Pages which differ by more
+// than this fall back to a cheaper, less precise comparison.
+const maxDiffCells = 4 << 20
+
+// Normalize splits gemtext into lines, removing differences which don't
+// matter to a reader: line ending style, trailing whitespace, runs of blank
+// lines and blank lines at the start and end. Preformatted blocks are only
+// stripped of trailing whitespace.
+func Normalize(text string) []string {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+
+	var lines []string
+	preformatted := false
+	blank := true
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t")
+
+		if strings.HasPrefix(line, "```") {
+			preformatted = !preformatted
+		}
+
+		if line == "" && !preformatted {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+
+		lines = append(lines, line)
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// Diff returns the lines removed from old and added in new, in order, using
+// the longest common subsequence of lines.
+func Diff(old, new []string) []Change {
+	// Common prefixes and suffixes are cheap to skip and usually make up
+	// most of the page.
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+
+	a := old[prefix : len(old)-suffix]
+	b := new[prefix : len(new)-suffix]
+
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return setDiff(a, b, prefix)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var changes []Change
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			changes = append(changes, Change{Op: Removed, Line: prefix + i + 1, Text: a[i]})
+			i++
+		default:
+			changes = append(changes, Change{Op: Added, Line: prefix + j + 1, Text: b[j]})
+			j++
+		}
+	}
+
+	return changes
+}
+
+// setDiff compares lines as multisets, ignoring moves.
+func setDiff(a, b []string, offset int) []Change {
+	counts := make(map[string]int)
+	for _, line := range b {
+		counts[line]++
+	}
+
+	var changes []Change
+	for i, line := range a {
+		if counts[line] > 0 {
+			counts[line]--
+			continue
+		}
+		changes = append(changes, Change{Op: Removed, Line: offset + i + 1, Text: line})
+	}
+
+	counts = make(map[string]int)
+	for _, line := range a {
+		counts[line]++
+	}
+
+	for j, line := range b {
+		if counts[line] > 0 {
+			counts[line]--
+			continue
+		}
+		changes = append(changes, Change{Op: Added, Line: offset + j + 1, Text: line})
+	}
+
+	return changes
+}
@@ -0,0 +1,191 @@
+// Package watch detects changes to Gemini pages, for building "page changed"
+// notification tools.
+//
+// Pages are normalized before they are compared, so changes in whitespace
+// don't count, and the result is a list of added and removed lines.
+//
+//	w := &watch.Watcher{Store: watch.DirStore("/var/lib/watch")}
+//	report, err := w.Check(ctx, "gemini://example.com/news.gmi")
+//	if err == nil && report.Changed() {
+//		for _, change := range report.Changes {
+//			fmt.Println(change)
+//		}
+//	}
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/gemini.v0"
+)
+
+// maxPageSize is the largest page which will be compared.
+const maxPageSize = 4 << 20
+
+// ErrNoSnapshot is returned by a SnapshotStore when there is no snapshot for
+// a URL.
+var ErrNoSnapshot = errors.New("watch: no snapshot")
+
+// A SnapshotStore keeps the last seen version of each page.
+type SnapshotStore interface {
+	Load(url string) ([]string, error)
+	Save(url string, lines []string) error
+}
+
+// MemoryStore is a SnapshotStore which keeps snapshots in memory. The zero
+// value is ready to use.
+type MemoryStore struct {
+	mu        sync.Mutex
+	snapshots map[string][]string
+}
+
+// Load implements SnapshotStore.
+func (s *MemoryStore) Load(url string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines, ok := s.snapshots[url]
+	if !ok {
+		return nil, ErrNoSnapshot
+	}
+	return lines, nil
+}
+
+// Save implements SnapshotStore.
+func (s *MemoryStore) Save(url string, lines []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.snapshots == nil {
+		s.snapshots = make(map[string][]string)
+	}
+	s.snapshots[url] = lines
+
+	return nil
+}
+
+// DirStore is a SnapshotStore which keeps snapshots as files in a directory,
+// named after the hash of the URL.
+type DirStore string
+
+func (d DirStore) path(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(string(d), hex.EncodeToString(sum[:]))
+}
+
+// Load implements SnapshotStore.
+func (d DirStore) Load(url string) ([]string, error) {
+	data, err := ioutil.ReadFile(d.path(url))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSnapshot
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(data), "\n"), nil
+}
+
+// Save implements SnapshotStore.
+func (d DirStore) Save(url string, lines []string) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+
+	name := d.path(url)
+	if err := ioutil.WriteFile(name+".tmp", []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
+
+// A Report describes how a page changed since the last check.
+type Report struct {
+	URL  string
+	Time time.Time
+
+	// First is true if there was no previous snapshot, in which case Changes
+	// is empty.
+	First bool
+
+	Changes []Change
+}
+
+// Changed reports whether any lines were added or removed.
+func (r *Report) Changed() bool {
+	return len(r.Changes) > 0
+}
+
+// Watcher fetches pages and compares them to their previous snapshots.
+type Watcher struct {
+	// Client is used to fetch pages. If nil, a Client with a memory TOFU
+	// store and a 30 second timeout is used.
+	Client *gemini.Client
+
+	// Store keeps the snapshots. It is required.
+	Store SnapshotStore
+}
+
+// Check fetches url, compares it to the stored snapshot and saves the new
+// version. Only successful text responses are compared.
+func (w *Watcher) Check(ctx context.Context, url string) (*Report, error) {
+	client := w.Client
+	if client == nil {
+		client = &gemini.Client{TOFU: &gemini.MemoryTOFUStore{}, Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.GetContext(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !resp.IsSuccess() {
+		return nil, fmt.Errorf("watch: %d %s", resp.Status, resp.Meta)
+	}
+
+	if !resp.IsText() {
+		return nil, fmt.Errorf("watch: %s is not text", resp.Meta)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := Normalize(string(body))
+	report := &Report{URL: url, Time: time.Now()}
+
+	old, err := w.Store.Load(url)
+	switch {
+	case err == ErrNoSnapshot:
+		report.First = true
+	case err != nil:
+		return nil, err
+	default:
+		report.Changes = Diff(old, lines)
+	}
+
+	if report.First || report.Changed() {
+		if err := w.Store.Save(url, lines); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
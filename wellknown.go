@@ -0,0 +1,203 @@
+package gemini
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Favicon returns a handler which serves emoji following the favicon.txt
+// convention. It is generally mounted directly on a ServeMux:
+//
+//	mux.Handle("/favicon.txt", gemini.Favicon("🚀"))
+func Favicon(emoji string) Handler {
+	emoji = strings.TrimSpace(emoji)
+
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		w.WriteStatus(StatusSuccess, "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte(emoji + "\n"))
+	})
+}
+
+// Virtual user agents as described by the robots.txt companion spec
+// (gemini://gemini.circumlunar.space/docs/companion/robots.gmi).
+const (
+	UserAgentArchiver   = "archiver"
+	UserAgentIndexer    = "indexer"
+	UserAgentResearcher = "researcher"
+	UserAgentWebProxy   = "webproxy"
+)
+
+// A Rule is a single section of a robots.txt file. If UserAgents is empty, the
+// rule applies to all agents.
+type Rule struct {
+	UserAgents []string
+	Allow      []string
+	Disallow   []string
+}
+
+// RobotsHandler returns a handler which renders rules as a robots.txt file. It
+// is generally mounted directly on a ServeMux:
+//
+//	mux.Handle("/robots.txt", gemini.RobotsHandler(
+//		gemini.Rule{
+//			UserAgents: []string{gemini.UserAgentArchiver, gemini.UserAgentWebProxy},
+//			Disallow:   []string{"/"},
+//		},
+//	))
+func RobotsHandler(rules ...Rule) Handler {
+	var b strings.Builder
+
+	for i, rule := range rules {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		agents := rule.UserAgents
+		if len(agents) == 0 {
+			agents = []string{"*"}
+		}
+
+		for _, agent := range agents {
+			b.WriteString("User-agent: " + agent + "\n")
+		}
+
+		for _, p := range rule.Allow {
+			b.WriteString("Allow: " + p + "\n")
+		}
+
+		// An empty Disallow line means everything is allowed, which is
+		// required if a section would otherwise only have agents.
+		if len(rule.Allow) == 0 && len(rule.Disallow) == 0 {
+			b.WriteString("Disallow:\n")
+		}
+
+		for _, p := range rule.Disallow {
+			b.WriteString("Disallow: " + p + "\n")
+		}
+	}
+
+	body := []byte(b.String())
+
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		w.WriteStatus(StatusSuccess, "text/plain")
+		_, _ = w.Write(body)
+	})
+}
+
+// SecurityTxt describes the contents of a security.txt file, as defined by RFC
+// 9116. Contact and Expires are required by the RFC.
+type SecurityTxt struct {
+	Contact            []string
+	Expires            time.Time
+	Encryption         []string
+	Acknowledgments    []string
+	PreferredLanguages []string
+	Canonical          []string
+	Policy             []string
+	Hiring             []string
+}
+
+// String renders s in the security.txt format.
+func (s SecurityTxt) String() string {
+	var b strings.Builder
+
+	writeFields := func(name string, values []string) {
+		for _, v := range values {
+			b.WriteString(name + ": " + v + "\n")
+		}
+	}
+
+	writeFields("Contact", s.Contact)
+	if !s.Expires.IsZero() {
+		b.WriteString("Expires: " + s.Expires.UTC().Format(time.RFC3339) + "\n")
+	}
+	writeFields("Encryption", s.Encryption)
+	writeFields("Acknowledgments", s.Acknowledgments)
+	if len(s.PreferredLanguages) > 0 {
+		b.WriteString("Preferred-Languages: " + strings.Join(s.PreferredLanguages, ", ") + "\n")
+	}
+	writeFields("Canonical", s.Canonical)
+	writeFields("Policy", s.Policy)
+	writeFields("Hiring", s.Hiring)
+
+	return b.String()
+}
+
+// SecurityTxtHandler returns a handler which serves s. It is generally mounted
+// on a ServeMux at "/.well-known/security.txt".
+func SecurityTxtHandler(s SecurityTxt) Handler {
+	body := []byte(s.String())
+
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		w.WriteStatus(StatusSuccess, "text/plain; charset=utf-8")
+		_, _ = w.Write(body)
+	})
+}
+
+// CapsuleInfo describes a capsule for an "about" page.
+type CapsuleInfo struct {
+	Name        string
+	Description string
+	Author      string
+	Contact     string
+	License     string
+	Links       []Link
+}
+
+// A Link is a single gemtext link line.
+type Link struct {
+	URL   string
+	Label string
+}
+
+// String renders l as a gemtext link line, without the trailing newline.
+func (l Link) String() string {
+	if l.Label == "" {
+		return "=> " + l.URL
+	}
+	return "=> " + l.URL + " " + l.Label
+}
+
+// String renders c as a gemtext document.
+func (c CapsuleInfo) String() string {
+	var b strings.Builder
+
+	if c.Name != "" {
+		b.WriteString("# " + c.Name + "\n\n")
+	}
+
+	if c.Description != "" {
+		b.WriteString(c.Description + "\n\n")
+	}
+
+	fields := []struct{ name, value string }{
+		{"Author", c.Author},
+		{"Contact", c.Contact},
+		{"License", c.License},
+	}
+	for _, f := range fields {
+		if f.value != "" {
+			b.WriteString("* " + f.name + ": " + f.value + "\n")
+		}
+	}
+
+	if len(c.Links) > 0 {
+		b.WriteString("\n")
+		for _, l := range c.Links {
+			b.WriteString(l.String() + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+// AboutHandler returns a handler which serves info as a gemtext page.
+func AboutHandler(info CapsuleInfo) Handler {
+	body := []byte(info.String())
+
+	return HandlerFunc(func(ctx context.Context, w ResponseWriter, r *Request) {
+		w.WriteStatus(StatusSuccess, "text/gemini")
+		_, _ = w.Write(body)
+	})
+}
@@ -0,0 +1,237 @@
+package wiki
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store for pages which don't exist.
+var ErrNotFound = errors.New("wiki: page not found")
+
+// A Page is a single wiki page. Body is gemtext.
+type Page struct {
+	Name     string
+	Body     string
+	Modified time.Time
+
+	// Author identifies who last changed the page.
+	Author string
+}
+
+// A Change records a single edit, for the recent changes feed.
+type Change struct {
+	Name   string
+	Time   time.Time
+	Author string
+}
+
+// A Store saves wiki pages. Names passed to it have already been validated.
+type Store interface {
+	// Load returns the page called name, or ErrNotFound.
+	Load(ctx context.Context, name string) (*Page, error)
+
+	// Save creates or replaces a page and records the change.
+	Save(ctx context.Context, page *Page) error
+
+	// Names returns the names of all pages, sorted.
+	Names(ctx context.Context) ([]string, error)
+
+	// Changes returns up to limit of the most recent changes, newest first.
+	Changes(ctx context.Context, limit int) ([]Change, error)
+}
+
+// MemoryStore is a Store which keeps pages in memory. The zero value is ready
+// to use.
+type MemoryStore struct {
+	mu      sync.Mutex
+	pages   map[string]Page
+	changes []Change
+}
+
+// Load implements Store.
+func (s *MemoryStore) Load(ctx context.Context, name string) (*Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.pages[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &page, nil
+}
+
+// Save implements Store.
+func (s *MemoryStore) Save(ctx context.Context, page *Page) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pages == nil {
+		s.pages = make(map[string]Page)
+	}
+
+	s.pages[page.Name] = *page
+	s.changes = append(s.changes, Change{Name: page.Name, Time: page.Modified, Author: page.Author})
+
+	return nil
+}
+
+// Names implements Store.
+func (s *MemoryStore) Names(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.pages))
+	for name := range s.pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Changes implements Store.
+func (s *MemoryStore) Changes(ctx context.Context, limit int) ([]Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changes []Change
+	for i := len(s.changes) - 1; i >= 0 && len(changes) < limit; i-- {
+		changes = append(changes, s.changes[i])
+	}
+
+	return changes, nil
+}
+
+// changeLogFile is the name of the file in a DirStore which records changes.
+const changeLogFile = "changes.log"
+
+// DirStore is a Store which keeps each page as a .gmi file in a directory,
+// with changes appended to a log file next to them.
+type DirStore string
+
+func (d DirStore) path(name string) string {
+	return filepath.Join(string(d), name+".gmi")
+}
+
+// Load implements Store.
+func (d DirStore) Load(ctx context.Context, name string) (*Page, error) {
+	f, err := os.Open(d.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Page{Name: name, Body: string(body), Modified: info.ModTime()}, nil
+}
+
+// Save implements Store.
+func (d DirStore) Save(ctx context.Context, page *Page) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+
+	name := d.path(page.Name)
+	if err := ioutil.WriteFile(name+".tmp", []byte(page.Body), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+
+	log, err := os.OpenFile(filepath.Join(string(d), changeLogFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	author := strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}, page.Author)
+
+	_, err = fmt.Fprintf(log, "%s\t%s\t%s\n", page.Modified.UTC().Format(time.RFC3339), page.Name, author)
+	if cerr := log.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Names implements Store.
+func (d DirStore) Names(ctx context.Context) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(string(d), "*.gmi"))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(matches))
+	for i, match := range matches {
+		names[i] = strings.TrimSuffix(filepath.Base(match), ".gmi")
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Changes implements Store.
+func (d DirStore) Changes(ctx context.Context, limit int) ([]Change, error) {
+	f, err := os.Open(filepath.Join(string(d), changeLogFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var changes []Change
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, fields[0])
+		if err != nil {
+			continue
+		}
+
+		changes = append(changes, Change{Name: fields[1], Time: t, Author: fields[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// The log is oldest first.
+	var recent []Change
+	for i := len(changes) - 1; i >= 0 && len(recent) < limit; i-- {
+		recent = append(recent, changes[i])
+	}
+
+	return recent, nil
+}
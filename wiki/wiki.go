@@ -0,0 +1,499 @@
+// Package wiki is a small wiki built on this package. Pages are gemtext,
+// saved through a pluggable Store, and can be replaced by uploading them with
+// Titan or extended a line at a time with input prompts. Text like [[Other
+// Page]] gets a link to that page added after its line, and there are pages
+// for recent changes, which can be subscribed to as a Gemfeed, and search.
+//
+//	w := &wiki.Wiki{Store: wiki.DirStore("/srv/wiki")}
+//	mux.Handle("/wiki/*path", w)
+//
+// The wiki is served under Prefix, which defaults to "/wiki/". Names
+// starting with an underscore are reserved:
+//
+//	/wiki/Page_Name           a page
+//	/wiki/_append/Page_Name   add a line to a page
+//	/wiki/_recent             recent changes
+//	/wiki/_search             search
+//	/wiki/_index              all pages
+package wiki
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/gemini.v0"
+)
+
+// Wiki is a gemini.Handler serving a wiki.
+type Wiki struct {
+	Store Store
+
+	// Title is the name of the wiki. If empty, "Wiki" is used.
+	Title string
+
+	// Prefix is the path the wiki is mounted at. If empty, "/wiki/" is used.
+	Prefix string
+
+	// FrontPage is the page the prefix redirects to. If empty, "Home" is
+	// used.
+	FrontPage string
+
+	// CanEdit decides whether a request may change pages. If nil, any client
+	// with a certificate may edit, and others are asked for one.
+	CanEdit func(ctx context.Context, r *gemini.Request) bool
+
+	// MaxPageSize is the largest page which can be saved, in bytes. If zero,
+	// 64 KiB is used.
+	MaxPageSize int64
+}
+
+// wikiLink matches [[Page Name]] in text lines.
+var wikiLink = regexp.MustCompile(`\[\[([^\[\]]+)\]\]`)
+
+// maxSearchResults limits the number of pages shown by search.
+const maxSearchResults = 50
+
+// recentChanges is the number of changes shown on the recent changes page.
+const recentChanges = 50
+
+// PageName converts a title, such as "Page Name", to the name of its page,
+// "Page_Name". It returns false if the name isn't valid.
+func PageName(title string) (string, bool) {
+	name := strings.Replace(strings.TrimSpace(title), " ", "_", -1)
+	if name == "" || len(name) > 100 || name[0] == '_' || name[0] == '.' {
+		return "", false
+	}
+
+	for _, r := range name {
+		if r == '/' || r == '\\' || unicode.IsControl(r) || unicode.IsSpace(r) {
+			return "", false
+		}
+	}
+
+	return name, true
+}
+
+// PageTitle converts a page name back to a title.
+func PageTitle(name string) string {
+	return strings.Replace(name, "_", " ", -1)
+}
+
+func (wk *Wiki) prefix() string {
+	prefix := wk.Prefix
+	if prefix == "" {
+		prefix = "/wiki/"
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+func (wk *Wiki) title() string {
+	if wk.Title != "" {
+		return wk.Title
+	}
+	return "Wiki"
+}
+
+func (wk *Wiki) frontPage() string {
+	if wk.FrontPage != "" {
+		return wk.FrontPage
+	}
+	return "Home"
+}
+
+func (wk *Wiki) maxPageSize() int64 {
+	if wk.MaxPageSize > 0 {
+		return wk.MaxPageSize
+	}
+	return 64 << 10
+}
+
+// URL returns the path of the page called name.
+func (wk *Wiki) URL(name string) string {
+	return wk.prefix() + url.PathEscape(name)
+}
+
+// ServeGemini implements gemini.Handler.
+func (wk *Wiki) ServeGemini(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(wk.prefix(), "/"))
+	rest = strings.TrimPrefix(rest, "/")
+
+	if r.Titan != nil {
+		wk.serveUpload(ctx, w, r, rest)
+		return
+	}
+
+	switch {
+	case rest == "":
+		w.WriteStatus(gemini.StatusRedirect, wk.URL(wk.frontPage()))
+	case rest == "_recent":
+		wk.serveRecent(ctx, w)
+	case rest == "_search":
+		wk.serveSearch(ctx, w, r)
+	case rest == "_index":
+		wk.serveIndex(ctx, w)
+	case strings.HasPrefix(rest, "_append/"):
+		wk.serveAppend(ctx, w, r, strings.TrimPrefix(rest, "_append/"))
+	default:
+		wk.servePage(ctx, w, r, rest)
+	}
+}
+
+func (wk *Wiki) servePage(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request, name string) {
+	name, ok := PageName(name)
+	if !ok {
+		gemini.NotFound(ctx, r, w)
+		return
+	}
+
+	page, err := wk.Store.Load(ctx, name)
+	if err == ErrNotFound {
+		w.WriteStatus(gemini.StatusSuccess, "text/gemini")
+		fmt.Fprintf(w, "# %s\n\nThis page doesn't exist yet.\n\n", PageTitle(name))
+		fmt.Fprintf(w, "=> %s_append/%s Start it\n", wk.prefix(), url.PathEscape(name))
+		return
+	}
+	if err != nil {
+		w.WriteStatus(gemini.StatusTemporaryFailure, "Unable to load page")
+		return
+	}
+
+	names, err := wk.Store.Names(ctx)
+	if err != nil {
+		w.WriteStatus(gemini.StatusTemporaryFailure, "Unable to load page")
+		return
+	}
+
+	w.WriteStatus(gemini.StatusSuccess, "text/gemini")
+	_, _ = io.WriteString(w, wk.Render(page.Body, names))
+
+	fmt.Fprintf(w, "\n---\n")
+	if !page.Modified.IsZero() {
+		fmt.Fprintf(w, "Last changed %s", page.Modified.UTC().Format("2006-01-02 15:04 MST"))
+		if page.Author != "" {
+			fmt.Fprintf(w, " by %s", page.Author)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	fmt.Fprintf(w, "=> %s_append/%s Add a line\n", wk.prefix(), url.PathEscape(name))
+	fmt.Fprintf(w, "=> %s_recent Recent changes\n", wk.prefix())
+	fmt.Fprintf(w, "=> %s_search Search\n", wk.prefix())
+	fmt.Fprintf(w, "=> %s_index All pages\n", wk.prefix())
+}
+
+// Render formats a page body, adding a link after each line for every
+// [[Page Name]] in it. Links to pages which aren't in names are marked as
+// new.
+func (wk *Wiki) Render(body string, names []string) string {
+	exists := make(map[string]bool, len(names))
+	for _, name := range names {
+		exists[name] = true
+	}
+
+	var b strings.Builder
+	preformatted := false
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(nil, int(wk.maxPageSize())+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		b.WriteString(line)
+		b.WriteString("\n")
+
+		if strings.HasPrefix(line, "```") {
+			preformatted = !preformatted
+			continue
+		}
+
+		if preformatted || strings.HasPrefix(line, "=>") {
+			continue
+		}
+
+		for _, match := range wikiLink.FindAllStringSubmatch(line, -1) {
+			name, ok := PageName(match[1])
+			if !ok {
+				continue
+			}
+
+			b.WriteString("=> " + wk.URL(name) + " " + PageTitle(name))
+			if !exists[name] {
+				b.WriteString(" (new)")
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	return b.String()
+}
+
+// authorize checks whether r may edit, writing a response if it may not.
+func (wk *Wiki) authorize(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) bool {
+	if wk.CanEdit != nil {
+		if !wk.CanEdit(ctx, r) {
+			w.WriteStatus(gemini.StatusCertificateNotAuthorized, "Not allowed to edit")
+			return false
+		}
+		return true
+	}
+
+	if r.Identity == nil {
+		w.WriteStatus(gemini.StatusCertificateRequired, "A certificate is required to edit")
+		return false
+	}
+
+	return true
+}
+
+// author returns the name changes by r are recorded under.
+func author(r *gemini.Request) string {
+	if r.Identity == nil {
+		return ""
+	}
+
+	if cn := r.Identity.Subject.CommonName; cn != "" {
+		return cn
+	}
+
+	return gemini.Fingerprint(r.Identity)[:11]
+}
+
+func (wk *Wiki) save(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request, name, body string) {
+	if int64(len(body)) > wk.maxPageSize() {
+		w.WriteStatus(gemini.StatusBadRequest, "Page too large")
+		return
+	}
+
+	err := wk.Store.Save(ctx, &Page{
+		Name:     name,
+		Body:     body,
+		Modified: time.Now(),
+		Author:   author(r),
+	})
+	if err != nil {
+		w.WriteStatus(gemini.StatusTemporaryFailure, "Unable to save page")
+		return
+	}
+
+	target := &url.URL{Scheme: "gemini", Host: r.URL.Host, Path: wk.prefix() + name}
+	w.WriteStatus(gemini.StatusRedirect, target.String())
+}
+
+func (wk *Wiki) serveUpload(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request, name string) {
+	name, ok := PageName(name)
+	if !ok {
+		w.WriteStatus(gemini.StatusBadRequest, "Invalid page name")
+		return
+	}
+
+	if !wk.authorize(ctx, w, r) {
+		return
+	}
+
+	if r.Titan.Size == 0 {
+		w.WriteStatus(gemini.StatusBadRequest, "Pages can't be empty")
+		return
+	}
+
+	if r.Titan.Size > wk.maxPageSize() {
+		w.WriteStatus(gemini.StatusBadRequest, "Page too large")
+		return
+	}
+
+	if !strings.HasPrefix(r.Titan.MIME, "text/gemini") {
+		w.WriteStatus(gemini.StatusBadRequest, "Pages must be text/gemini")
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil || int64(len(body)) != r.Titan.Size {
+		w.WriteStatus(gemini.StatusBadRequest, "Incomplete upload")
+		return
+	}
+
+	wk.save(ctx, w, r, name, string(body))
+}
+
+func (wk *Wiki) serveAppend(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request, name string) {
+	name, ok := PageName(name)
+	if !ok {
+		gemini.NotFound(ctx, r, w)
+		return
+	}
+
+	if !wk.authorize(ctx, w, r) {
+		return
+	}
+
+	if r.URL.RawQuery == "" {
+		w.WriteStatus(gemini.StatusInput, "Line to add to "+PageTitle(name))
+		return
+	}
+
+	line, err := url.QueryUnescape(r.URL.RawQuery)
+	if err != nil {
+		w.WriteStatus(gemini.StatusBadRequest, "Invalid input")
+		return
+	}
+
+	body := ""
+	page, err := wk.Store.Load(ctx, name)
+	switch {
+	case err == ErrNotFound:
+		body = "# " + PageTitle(name) + "\n\n"
+	case err != nil:
+		w.WriteStatus(gemini.StatusTemporaryFailure, "Unable to load page")
+		return
+	default:
+		body = page.Body
+		if body != "" && !strings.HasSuffix(body, "\n") {
+			body += "\n"
+		}
+	}
+
+	wk.save(ctx, w, r, name, body+line+"\n")
+}
+
+func (wk *Wiki) serveRecent(ctx context.Context, w gemini.ResponseWriter) {
+	changes, err := wk.Store.Changes(ctx, recentChanges)
+	if err != nil {
+		w.WriteStatus(gemini.StatusTemporaryFailure, "Unable to load changes")
+		return
+	}
+
+	// This is formatted as a Gemfeed, so it can be subscribed to.
+	w.WriteStatus(gemini.StatusSuccess, "text/gemini")
+	fmt.Fprintf(w, "# %s: recent changes\n\n", wk.title())
+
+	for _, change := range changes {
+		fmt.Fprintf(w, "=> %s %s - %s", wk.URL(change.Name), change.Time.UTC().Format("2006-01-02"), PageTitle(change.Name))
+		if change.Author != "" {
+			fmt.Fprintf(w, " edited by %s", change.Author)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+func (wk *Wiki) serveIndex(ctx context.Context, w gemini.ResponseWriter) {
+	names, err := wk.Store.Names(ctx)
+	if err != nil {
+		w.WriteStatus(gemini.StatusTemporaryFailure, "Unable to list pages")
+		return
+	}
+
+	w.WriteStatus(gemini.StatusSuccess, "text/gemini")
+	fmt.Fprintf(w, "# %s: all pages\n\n", wk.title())
+	for _, name := range names {
+		fmt.Fprintf(w, "=> %s %s\n", wk.URL(name), PageTitle(name))
+	}
+}
+
+func (wk *Wiki) serveSearch(ctx context.Context, w gemini.ResponseWriter, r *gemini.Request) {
+	if r.URL.RawQuery == "" {
+		w.WriteStatus(gemini.StatusInput, "Search "+wk.title())
+		return
+	}
+
+	query, err := url.QueryUnescape(r.URL.RawQuery)
+	if err != nil {
+		w.WriteStatus(gemini.StatusBadRequest, "Invalid query")
+		return
+	}
+
+	results, err := wk.Search(ctx, query)
+	if err != nil {
+		w.WriteStatus(gemini.StatusTemporaryFailure, "Unable to search")
+		return
+	}
+
+	w.WriteStatus(gemini.StatusSuccess, "text/gemini")
+	fmt.Fprintf(w, "# Search results for %q\n\n", query)
+
+	if len(results) == 0 {
+		fmt.Fprintf(w, "No pages matched.\n")
+	}
+
+	for _, result := range results {
+		fmt.Fprintf(w, "=> %s %s\n", wk.URL(result.Name), PageTitle(result.Name))
+		if result.Snippet != "" {
+			fmt.Fprintf(w, "> %s\n", result.Snippet)
+		}
+	}
+}
+
+// A Result is a single search result.
+type Result struct {
+	Name string
+
+	// Snippet is the first line of the page which matched, if the name
+	// didn't.
+	Snippet string
+}
+
+// Search returns the pages whose name or body contains query, ignoring case.
+// Pages whose name matches come first.
+func (wk *Wiki) Search(ctx context.Context, query string) ([]Result, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, nil
+	}
+
+	names, err := wk.Store.Names(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var nameMatches, bodyMatches []Result
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(PageTitle(name)), query) {
+			nameMatches = append(nameMatches, Result{Name: name})
+			continue
+		}
+
+		page, err := wk.Store.Load(ctx, name)
+		if err != nil {
+			continue
+		}
+
+		for _, line := range strings.Split(page.Body, "\n") {
+			if strings.Contains(strings.ToLower(line), query) {
+				bodyMatches = append(bodyMatches, Result{Name: name, Snippet: snippet(line)})
+				break
+			}
+		}
+	}
+
+	results := append(nameMatches, bodyMatches...)
+	if len(results) > maxSearchResults {
+		results = results[:maxSearchResults]
+	}
+
+	return results, nil
+}
+
+// snippet trims a line to a reasonable length for search results.
+func snippet(line string) string {
+	line = strings.TrimSpace(strings.TrimLeft(line, "#*>=` "))
+
+	const max = 80
+	if len(line) <= max {
+		return line
+	}
+
+	cut := max
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+
+	return line[:cut] + "…"
+}